@@ -4,3 +4,70 @@ type AABB struct {
 	Min [3]float64 `json:"min,omitempty"`
 	Max [3]float64 `json:"max,omitempty"`
 }
+
+func (a AABB) Size() [3]float64 {
+	return [3]float64{a.Max[0] - a.Min[0], a.Max[1] - a.Min[1], a.Max[2] - a.Min[2]}
+}
+
+func (a AABB) Center() [3]float64 {
+	return [3]float64{(a.Min[0] + a.Max[0]) / 2, (a.Min[1] + a.Max[1]) / 2, (a.Min[2] + a.Max[2]) / 2}
+}
+
+func (a AABB) Child(index int) AABB {
+	ret := a
+	center := a.Center()
+	for i, bit := range [3]int{0b100, 0b010, 0b001} {
+		if index&bit != 0 {
+			ret.Min[i] = center[i]
+		} else {
+			ret.Max[i] = center[i]
+		}
+	}
+	return ret
+}
+
+func (a AABB) Contains(p [3]float64, tolerance [3]float64) bool {
+	for i := 0; i < 3; i++ {
+		if p[i] < a.Min[i]-tolerance[i] || p[i] > a.Max[i]+tolerance[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a AABB) Intersects(other AABB) bool {
+	for i := 0; i < 3; i++ {
+		if a.Max[i] < other.Min[i] || a.Min[i] > other.Max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a AABB) Cubic() AABB {
+	size := a.Size()
+	extent := size[0]
+	if size[1] > extent {
+		extent = size[1]
+	}
+	if size[2] > extent {
+		extent = size[2]
+	}
+	if extent <= 0 {
+		extent = 1
+	}
+	return AABB{Min: a.Min, Max: [3]float64{a.Min[0] + extent, a.Min[1] + extent, a.Min[2] + extent}}
+}
+
+func (a AABB) Union(other AABB) AABB {
+	ret := a
+	for i := 0; i < 3; i++ {
+		if other.Min[i] < ret.Min[i] {
+			ret.Min[i] = other.Min[i]
+		}
+		if other.Max[i] > ret.Max[i] {
+			ret.Max[i] = other.Max[i]
+		}
+	}
+	return ret
+}
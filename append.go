@@ -0,0 +1,187 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Append inserts points described by src into the archive on disk. Each
+// point is kept by the first node on its path from the root whose sampling
+// cell is still free, otherwise it ends up in a leaf; leaves that grow past
+// MaxPointsPerChunk are split. Only the touched nodes are re-encoded and
+// appended to octree.bin, hierarchy.bin and metadata.json are rewritten.
+// Points outside the octree bounds require a rebuild with Merge.
+func (b *PotreeArchive) Append(src *Metadata, points []Attribute) (err error) {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return err
+		}
+	}
+	added, err := ConformPoints(b.metadata, src, points)
+	if err != nil {
+		return err
+	}
+	position := FindAttribute(added, POSITION.Name)
+	numPoints := position.Len()
+	for i := 0; i < numPoints; i++ {
+		p := b.metadata.WorldPosition(position, i)
+		if !b.root.Box.Contains(p, [3]float64{}) {
+			return fmt.Errorf("point %v lies outside the octree bounds, merge instead", p)
+		}
+	}
+
+	saved := b.saveIndex()
+	defer func() {
+		if err != nil {
+			saved.restore()
+		}
+	}()
+
+	grids := make(map[*Node]map[int]bool)
+	targets := make(map[*Node][]int)
+	var order []*Node
+	for i := 0; i < numPoints; i++ {
+		p := b.metadata.WorldPosition(position, i)
+		n := b.root
+		for {
+			if ChildMaskOf(n) == 0 {
+				break
+			}
+			grid, ok := grids[n]
+			if !ok {
+				if grid, err = b.samplingGrid(n); err != nil {
+					return err
+				}
+				grids[n] = grid
+			}
+			key := samplingCell(n.Box, p)
+			if !grid[key] {
+				grid[key] = true
+				break
+			}
+			idx := octantOf(p, n.Box.Center())
+			if n.Childs[idx] == nil {
+				child := &Node{Name: n.Name + strconv.Itoa(idx), Box: n.Box.Child(idx), Parent: n}
+				child.Type = NT_LEAF
+				child.Attrs = NewPoints(b.metadata.Attrs, 0)
+				n.Childs[idx] = child
+				b.nodeMaps[child.Name] = child
+			}
+			n = n.Childs[idx]
+		}
+		if _, ok := targets[n]; !ok {
+			order = append(order, n)
+		}
+		targets[n] = append(targets[n], i)
+	}
+
+	var dirty []*Node
+	for _, n := range order {
+		if n.Attrs == nil {
+			if err = b.LoadNode(n); err != nil {
+				return err
+			}
+		}
+		var attrs []Attribute
+		attrs, err = b.schemaAttributes(n)
+		if err != nil {
+			return err
+		}
+		for i := range attrs {
+			subset := added[i].Subset(targets[n])
+			attrs[i].Append(&subset)
+		}
+		n.Attrs = attrs
+		n.NumPoints = uint32(attrs[0].Len())
+
+		if ChildMaskOf(n) == 0 && int(n.NumPoints) > MaxPointsPerChunk {
+			if err = b.split(n); err != nil {
+				return err
+			}
+			n.Traverse(func(c *Node) bool {
+				dirty = append(dirty, c)
+				return true
+			})
+			continue
+		}
+		dirty = append(dirty, n)
+	}
+
+	if err = b.appendNodes(dirty); err != nil {
+		return err
+	}
+	extendAttributeRanges(b.metadata, added)
+	return b.writeIndex()
+}
+
+// indexState is a copy of the in-memory index. Append restores it when it
+// fails before hierarchy.bin is replaced; bytes already appended to
+// octree.bin stay unreferenced until Compact.
+type indexState struct {
+	b            *PotreeArchive
+	meta         Metadata
+	octreeOffset int64
+	nodeMaps     map[string]*Node
+	nodes        []Node
+}
+
+func (b *PotreeArchive) saveIndex() *indexState {
+	s := &indexState{b: b, meta: *b.metadata, octreeOffset: b.octreeOffset, nodeMaps: make(map[string]*Node, len(b.nodeMaps))}
+	s.meta.Attrs = append([]Attribute(nil), b.metadata.Attrs...)
+	for name, n := range b.nodeMaps {
+		s.nodeMaps[name] = n
+		c := *n
+		if n.Attrs != nil {
+			c.Attrs = append([]Attribute(nil), n.Attrs...)
+		}
+		s.nodes = append(s.nodes, c)
+	}
+	return s
+}
+
+func (s *indexState) restore() {
+	*s.b.metadata = s.meta
+	s.b.octreeOffset = s.octreeOffset
+	s.b.nodeMaps = s.nodeMaps
+	for _, c := range s.nodes {
+		*s.nodeMaps[c.Name] = c
+	}
+}
+
+func (b *PotreeArchive) samplingGrid(n *Node) (map[int]bool, error) {
+	if n.Attrs == nil {
+		if err := b.LoadNode(n); err != nil {
+			return nil, err
+		}
+	}
+	grid := make(map[int]bool)
+	position := FindAttribute(n.Attrs, POSITION.Name)
+	if position == nil {
+		return nil, errors.New("archive has no position attribute")
+	}
+	for i := 0; i < position.Len(); i++ {
+		grid[samplingCell(n.Box, b.metadata.WorldPosition(position, i))] = true
+	}
+	return grid, nil
+}
+
+// split turns the leaf n into the root of a new subtree built from its
+// points.
+func (b *PotreeArchive) split(n *Node) error {
+	bld := &Builder{MaxPointsPerNode: MaxPointsPerChunk, metadata: b.metadata, points: n.Attrs}
+	position := FindAttribute(bld.points, POSITION.Name)
+	if position == nil {
+		return errors.New("archive has no position attribute")
+	}
+	indices := make([]int, position.Len())
+	for i := range indices {
+		indices[i] = i
+	}
+	bld.build(n, indices, position)
+	n.Traverse(func(c *Node) bool {
+		b.nodeMaps[c.Name] = c
+		return true
+	})
+	return nil
+}
@@ -1,8 +1,8 @@
 package potree
 
 import (
-	"math"
 	"reflect"
+	"sort"
 	"unsafe"
 )
 
@@ -101,6 +101,67 @@ func (a *Attribute) GetType() AttributeType {
 	return TypenameToType(a.Type)
 }
 
+func (a *Attribute) Len() int {
+	_, _, count := attributeDataPointer(a.Data)
+	if a.NumElements == 0 {
+		return 0
+	}
+	return count / a.NumElements
+}
+
+func (a *Attribute) GetFloat64(i, element int) float64 {
+	idx := i*a.NumElements + element
+	switch rawdata := a.Data.(type) {
+	case []int8:
+		return float64(rawdata[idx])
+	case []int16:
+		return float64(rawdata[idx])
+	case []int32:
+		return float64(rawdata[idx])
+	case []int64:
+		return float64(rawdata[idx])
+	case []uint8:
+		return float64(rawdata[idx])
+	case []uint16:
+		return float64(rawdata[idx])
+	case []uint32:
+		return float64(rawdata[idx])
+	case []uint64:
+		return float64(rawdata[idx])
+	case []float32:
+		return float64(rawdata[idx])
+	case []float64:
+		return rawdata[idx]
+	}
+	return 0
+}
+
+func (a *Attribute) SetFloat64(i, element int, v float64) {
+	idx := i*a.NumElements + element
+	switch rawdata := a.Data.(type) {
+	case []int8:
+		rawdata[idx] = int8(v)
+	case []int16:
+		rawdata[idx] = int16(v)
+	case []int32:
+		rawdata[idx] = int32(v)
+	case []int64:
+		rawdata[idx] = int64(v)
+	case []uint8:
+		rawdata[idx] = uint8(v)
+	case []uint16:
+		rawdata[idx] = uint16(v)
+	case []uint32:
+		rawdata[idx] = uint32(v)
+	case []uint64:
+		rawdata[idx] = uint64(v)
+	case []float32:
+		rawdata[idx] = float32(v)
+	case []float64:
+		rawdata[idx] = v
+	}
+}
+
 func unsafeCopy(data unsafe.Pointer, dst []byte) {
 	var bufSlice []byte
 	bufHeader := (*reflect.SliceHeader)((unsafe.Pointer(&bufSlice)))
@@ -121,63 +182,119 @@ func unsafeCopyDst(src []byte, dst unsafe.Pointer) {
 	copy(bufSlice, src)
 }
 
-func (a *Attribute) unpack(isBrotliEncoded bool) {
-	tp := TypenameToType(a.Type)
-	elsize := len(a.Buffer) / a.ElementSize
+func makeAttributeData(tp AttributeType, size int) interface{} {
 	switch tp {
 	case ATTR_INT8:
-		rawdata := make([]int8, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]int8, size)
 	case ATTR_INT16:
-		rawdata := make([]int16, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]int16, size)
 	case ATTR_INT32:
-		rawdata := make([]int32, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]int32, size)
 	case ATTR_INT64:
-		rawdata := make([]int64, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]int64, size)
 	case ATTR_UINT8:
-		rawdata := make([]uint8, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]uint8, size)
 	case ATTR_UINT16:
-		rawdata := make([]uint16, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]uint16, size)
 	case ATTR_UINT32:
-		rawdata := make([]uint32, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]uint32, size)
 	case ATTR_UINT64:
-		rawdata := make([]uint64, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]uint64, size)
 	case ATTR_FLOAT:
-		rawdata := make([]float32, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
+		return make([]float32, size)
 	case ATTR_DOUBLE:
-		rawdata := make([]float64, elsize)
-		unsafeCopyDst(a.Buffer, unsafe.Pointer(&rawdata[0]))
-	case ATTR_UNDEFINED:
+		return make([]float64, size)
 	}
+	return nil
+}
+
+func attributeDataPointer(data interface{}) (AttributeType, unsafe.Pointer, int) {
+	switch rawdata := data.(type) {
+	case []int8:
+		if len(rawdata) > 0 {
+			return ATTR_INT8, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_INT8, nil, 0
+	case []int16:
+		if len(rawdata) > 0 {
+			return ATTR_INT16, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_INT16, nil, 0
+	case []int32:
+		if len(rawdata) > 0 {
+			return ATTR_INT32, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_INT32, nil, 0
+	case []int64:
+		if len(rawdata) > 0 {
+			return ATTR_INT64, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_INT64, nil, 0
+	case []uint8:
+		if len(rawdata) > 0 {
+			return ATTR_UINT8, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_UINT8, nil, 0
+	case []uint16:
+		if len(rawdata) > 0 {
+			return ATTR_UINT16, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_UINT16, nil, 0
+	case []uint32:
+		if len(rawdata) > 0 {
+			return ATTR_UINT32, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_UINT32, nil, 0
+	case []uint64:
+		if len(rawdata) > 0 {
+			return ATTR_UINT64, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_UINT64, nil, 0
+	case []float32:
+		if len(rawdata) > 0 {
+			return ATTR_FLOAT, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_FLOAT, nil, 0
+	case []float64:
+		if len(rawdata) > 0 {
+			return ATTR_DOUBLE, unsafe.Pointer(&rawdata[0]), len(rawdata)
+		}
+		return ATTR_DOUBLE, nil, 0
+	}
+	return ATTR_UNDEFINED, nil, 0
+}
+
+func (a *Attribute) unpack(isBrotliEncoded bool) {
+	tp := TypenameToType(a.Type)
+	if tp == ATTR_UNDEFINED || a.ElementSize == 0 {
+		return
+	}
+	elsize := len(a.Buffer) / a.ElementSize
+	rawdata := makeAttributeData(tp, elsize)
+	if _, ptr, _ := attributeDataPointer(rawdata); ptr != nil {
+		unsafeCopyDst(a.Buffer[:elsize*a.ElementSize], ptr)
+	}
+	a.Data = rawdata
 
 	if a.Name == "position_morton" && isBrotliEncoded {
 		raw := a.Data.([]uint64)
 
-		si := len(raw) / a.Size
-
-		var pos []uint32
-		posHeader := (*reflect.SliceHeader)((unsafe.Pointer(&pos)))
-		posHeader.Cap = int(len(raw) * 2)
-		posHeader.Len = int(len(raw) * 2)
-		posHeader.Data = uintptr(unsafe.Pointer(&raw[0]))
+		si := len(raw) / a.NumElements
 
 		newPos := make([]int32, si*3)
 
 		for i := 0; i < si; i++ {
-			mc_0, mc_1, mc_2, mc_3 := uint32(pos[i*4]), uint32(pos[i*4+1]), uint32(pos[i*4+2]), uint32(pos[i*4+3])
+			// the words as DecoderWorker_brotli.js of the Potree viewer
+			// reads them at byte 4, 0, 12 and 8, the upper code first
+			mc_0, mc_1 := uint32(raw[i*2]>>32), uint32(raw[i*2])
+			mc_2, mc_3 := uint32(raw[i*2+1]>>32), uint32(raw[i*2+1])
 
 			X := dealign24b((mc_3&0x00FFFFFF)>>0) | (dealign24b(((mc_3>>24)|(mc_2<<8))>>0) << 8)
 			Y := dealign24b((mc_3&0x00FFFFFF)>>1) | (dealign24b(((mc_3>>24)|(mc_2<<8))>>1) << 8)
 			Z := dealign24b((mc_3&0x00FFFFFF)>>2) | (dealign24b(((mc_3>>24)|(mc_2<<8))>>2) << 8)
 
+			// like the viewer the upper code is only added if mc_1 or mc_2
+			// is set
 			if mc_1 != 0 || mc_2 != 0 {
 				X = X | (dealign24b((mc_1&0x00FFFFFF)>>0) << 16) | (dealign24b(((mc_1>>24)|(mc_0<<8))>>0) << 24)
 				Y = Y | (dealign24b((mc_1&0x00FFFFFF)>>1) << 16) | (dealign24b(((mc_1>>24)|(mc_0<<8))>>1) << 24)
@@ -200,18 +317,12 @@ func (a *Attribute) unpack(isBrotliEncoded bool) {
 	if a.Name == "rgb_morton" && isBrotliEncoded {
 		raw := a.Data.([]uint64)
 
-		si := len(raw) / a.Size
-
-		var pos []uint32
-		posHeader := (*reflect.SliceHeader)((unsafe.Pointer(&pos)))
-		posHeader.Cap = int(len(raw) * 2)
-		posHeader.Len = int(len(raw) * 2)
-		posHeader.Data = uintptr(unsafe.Pointer(&raw[0]))
+		si := len(raw)
 
 		newColor := make([]uint16, si*3)
 
 		for i := 0; i < si; i++ {
-			mc_0, mc_1 := uint32(pos[i*2]), uint32(pos[i*2+1])
+			mc_0, mc_1 := uint32(raw[i]>>32), uint32(raw[i])
 
 			r := dealign24b((mc_1&0x00FFFFFF)>>0) | (dealign24b(((mc_1>>24)|(mc_0<<8))>>0) << 8)
 			g := dealign24b((mc_1&0x00FFFFFF)>>1) | (dealign24b(((mc_1>>24)|(mc_0<<8))>>1) << 8)
@@ -236,155 +347,19 @@ func (a *Attribute) pack(isBrotliEncoded bool) {
 	if a.Data == nil {
 		return
 	}
-	numPoints := 0
-	switch tp {
-	case ATTR_INT8:
-		rawdata, ok := a.Data.([]int8)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_INT16:
-		rawdata, ok := a.Data.([]int16)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_INT32:
-		rawdata, ok := a.Data.([]int32)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_INT64:
-		rawdata, ok := a.Data.([]int64)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_UINT8:
-		rawdata, ok := a.Data.([]uint8)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_UINT16:
-		rawdata, ok := a.Data.([]uint16)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_UINT32:
-		rawdata, ok := a.Data.([]uint32)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_UINT64:
-		rawdata, ok := a.Data.([]uint64)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_FLOAT:
-		rawdata, ok := a.Data.([]float32)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_DOUBLE:
-		rawdata, ok := a.Data.([]float64)
-		if !ok {
-			return
-		}
-		numPoints := len(rawdata)
-		a.Buffer = make([]byte, a.Size*numPoints)
-		unsafeCopy(unsafe.Pointer(&rawdata[0]), a.Buffer)
-	case ATTR_UNDEFINED:
+	dtp, _, count := attributeDataPointer(a.Data)
+	if dtp != tp || a.NumElements == 0 {
+		return
 	}
+	numPoints := count / a.NumElements
 
 	if a.Name == "position" && isBrotliEncoded {
 		rawdata, _ := a.Data.([]int32)
 
-		mcs := []MortonCode{}
-
-		type P struct {
-			x, y, z int32
-		}
-		ps := []P{}
-		min := P{}
-		min.x = math.MaxInt32
-		min.y = math.MaxInt32
-		min.z = math.MaxInt32
-
+		pos := make([]uint64, 0, numPoints*2)
 		for i := 0; i < numPoints; i++ {
-
-			var p P
-			p.x = rawdata[i*3]
-			p.y = rawdata[i*3+1]
-			p.z = rawdata[i*3+2]
-
-			min.x = MinInt(min.x, p.x)
-			min.y = MinInt(min.y, p.y)
-			min.z = MinInt(min.z, p.z)
-
-			ps = append(ps, p)
-		}
-
-		i := uint64(0)
-		for _, p := range ps {
-
-			mx := uint32(p.x - min.x)
-			my := uint32(p.y - min.y)
-			mz := uint32(p.z - min.z)
-
-			mx_l := uint32(mx & 0x0000ffff)
-			my_l := uint32(my & 0x0000ffff)
-			mz_l := uint32(mz & 0x0000ffff)
-
-			mx_h := mx >> 16
-			my_h := my >> 16
-			mz_h := mz >> 16
-
-			mc_l := MortonEncodeMagicBits(mx_l, my_l, mz_l)
-			mc_h := MortonEncodeMagicBits(mx_h, my_h, mz_h)
-
-			var mc MortonCode
-			mc.lower = mc_l
-			mc.upper = mc_h
-			mc.whatever = MortonEncodeMagicBits(mx, my, mz)
-			mc.index = i
-
-			mcs = append(mcs, mc)
-
-			i++
-		}
-
-		pos := make([]uint64, numPoints*2)
-
-		for i := 0; i < numPoints; i++ {
-			mc := mcs[i]
-
-			pos = append(pos, mc.upper)
-			pos = append(pos, mc.lower)
+			mc := positionMortonCode(rawdata[i*3], rawdata[i*3+1], rawdata[i*3+2])
+			pos = append(pos, mc.upper, mc.lower)
 		}
 		a.Name = "position_morton"
 		a.Type = "uint64"
@@ -397,14 +372,11 @@ func (a *Attribute) pack(isBrotliEncoded bool) {
 	if a.Name == "rgb" && isBrotliEncoded {
 		rawdata, _ := a.Data.([]uint16)
 
-		color := make([]uint64, numPoints)
-
+		color := make([]uint64, 0, numPoints)
 		for i := 0; i < numPoints; i++ {
 			r, g, b := rawdata[i*3], rawdata[i*3+1], rawdata[i*3+2]
-			mc := MortonEncodeMagicBits(uint32(r), uint32(g), uint32(b))
-			color = append(color, mc)
+			color = append(color, MortonEncodeMagicBits(uint32(r), uint32(g), uint32(b)))
 		}
-
 		a.Name = "rgb_morton"
 		a.Type = "uint64"
 		a.NumElements = 1
@@ -412,6 +384,88 @@ func (a *Attribute) pack(isBrotliEncoded bool) {
 		a.Size = 8
 		a.Data = color
 	}
+
+	a.Buffer = append([]byte(nil), attributeBytes(a.Data)...)
+}
+
+// positionMortonCode interleaves the low and high 16 bits of the integer
+// coordinates into the two halves of a 96 bit Morton code, as written by
+// PotreeConverter 2.
+func positionMortonCode(x, y, z int32) MortonCode {
+	mx, my, mz := uint32(x), uint32(y), uint32(z)
+	var mc MortonCode
+	mc.lower = MortonEncodeMagicBits(mx&0xffff, my&0xffff, mz&0xffff)
+	mc.upper = MortonEncodeMagicBits(mx>>16, my>>16, mz>>16)
+	return mc
+}
+
+// mortonOrder returns the point indices sorted by the Morton code of their
+// position, the order of points in BROTLI nodes.
+func mortonOrder(position *Attribute) []int {
+	rawdata, _ := position.Data.([]int32)
+	mcs := make([]MortonCode, len(rawdata)/3)
+	for i := range mcs {
+		mcs[i] = positionMortonCode(rawdata[i*3], rawdata[i*3+1], rawdata[i*3+2])
+		mcs[i].index = uint64(i)
+	}
+	sort.SliceStable(mcs, func(i, j int) bool {
+		if mcs[i].upper == mcs[j].upper {
+			return mcs[i].lower < mcs[j].lower
+		}
+		return mcs[i].upper < mcs[j].upper
+	})
+	order := make([]int, len(mcs))
+	for i := range mcs {
+		order[i] = int(mcs[i].index)
+	}
+	return order
+}
+
+func attributeBytes(data interface{}) []byte {
+	tp, ptr, count := attributeDataPointer(data)
+	if ptr == nil {
+		return nil
+	}
+	var buf []byte
+	bufHeader := (*reflect.SliceHeader)((unsafe.Pointer(&buf)))
+	bufHeader.Cap = count * AttributeTypeSize[tp]
+	bufHeader.Len = count * AttributeTypeSize[tp]
+	bufHeader.Data = uintptr(ptr)
+	return buf
+}
+
+func (a *Attribute) Subset(indices []int) Attribute {
+	ret := *a
+	ret.Buffer = nil
+	ret.Data = makeAttributeData(a.GetType(), len(indices)*a.NumElements)
+	src := attributeBytes(a.Data)
+	dst := attributeBytes(ret.Data)
+	for i, idx := range indices {
+		copy(dst[i*a.Size:(i+1)*a.Size], src[idx*a.Size:(idx+1)*a.Size])
+	}
+	return ret
+}
+
+func (a *Attribute) Append(other *Attribute) {
+	n := a.Len()
+	data := makeAttributeData(a.GetType(), (n+other.Len())*a.NumElements)
+	dst := attributeBytes(data)
+	copy(dst, attributeBytes(a.Data))
+	copy(dst[n*a.Size:], attributeBytes(other.Data))
+	a.Buffer = nil
+	a.Data = data
+}
+
+func encodedAttribute(a *Attribute, isBrotliEncoded bool) *Attribute {
+	if isBrotliEncoded {
+		switch a.Name {
+		case POSITION.Name:
+			return &POSITION_MORTON
+		case COLOR.Name:
+			return &COLOR_MORTON
+		}
+	}
+	return a
 }
 
 var (
@@ -436,3 +490,22 @@ var (
 	CLASSIFICATION_FLAGS       = Attribute{Name: "classification flags", Type: "uint8", NumElements: 1, ElementSize: 1, Size: 1}
 	POSITION_PROJECTED_PROFILE = Attribute{Name: "position_projected_profile", Type: "int32", NumElements: 2, ElementSize: 4, Size: 8}
 )
+
+func FindAttribute(attributes []Attribute, name string) *Attribute {
+	for i := range attributes {
+		if attributes[i].Name == name {
+			return &attributes[i]
+		}
+	}
+	return nil
+}
+
+func NewPoints(attributes []Attribute, numPoints int) []Attribute {
+	ret := make([]Attribute, len(attributes))
+	for i := range attributes {
+		ret[i] = attributes[i]
+		ret[i].Buffer = nil
+		ret[i].Data = makeAttributeData(attributes[i].GetType(), numPoints*attributes[i].NumElements)
+	}
+	return ret
+}
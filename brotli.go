@@ -19,28 +19,45 @@ func (Brotli) NewReader(r io.Reader) io.ReadCloser {
 	return ioutil.NopCloser(brotli.NewReader(r))
 }
 
-func (b Brotli) EncodeLevel(dst, src []byte, level int) []byte {
+func (b Brotli) EncodeLevel(dst, src []byte, level int) ([]byte, error) {
 	maxlen := int(b.CompressBound(int64(len(src))))
 	if dst == nil || cap(dst) < maxlen {
 		dst = make([]byte, 0, maxlen)
 	}
 	buf := bytes.NewBuffer(dst[:0])
 	w := brotli.NewWriterLevel(buf, level)
-	_, err := w.Write(src)
-	if err != nil {
-		panic(err)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
 	}
 	if err := w.Close(); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
-func (b Brotli) Encode(dst, src []byte) []byte {
+// EncodeWindow compresses with the given quality and base 2 logarithm of
+// the window size, a window of 0 is chosen from the quality.
+func (b Brotli) EncodeWindow(dst, src []byte, quality, window int) ([]byte, error) {
+	maxlen := int(b.CompressBound(int64(len(src))))
+	if dst == nil || cap(dst) < maxlen {
+		dst = make([]byte, 0, maxlen)
+	}
+	buf := bytes.NewBuffer(dst[:0])
+	w := brotli.NewWriterOptions(buf, brotli.WriterOptions{Quality: quality, LGWin: window})
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (b Brotli) Encode(dst, src []byte) ([]byte, error) {
 	return b.EncodeLevel(dst, src, DefaultCompression)
 }
 
-func (Brotli) Decode(dst, src []byte) []byte {
+func (Brotli) Decode(dst, src []byte) ([]byte, error) {
 	rdr := brotli.NewReader(bytes.NewReader(src))
 	if dst != nil {
 		var (
@@ -53,17 +70,11 @@ func (Brotli) Decode(dst, src []byte) []byte {
 			sofar += n
 		}
 		if err != nil && err != io.EOF {
-			panic(err)
+			return nil, err
 		}
-		return dst[:sofar]
+		return dst[:sofar], nil
 	}
-
-	dst, err := ioutil.ReadAll(rdr)
-	if err != nil {
-		panic(err)
-	}
-
-	return dst
+	return ioutil.ReadAll(rdr)
 }
 
 func (Brotli) CompressBound(len int64) int64 {
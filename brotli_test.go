@@ -1,8 +0,0 @@
-package potree
-
-import "testing"
-
-func TestReadPoTree(t *testing.T) {
-	arch := NewArchive("./tests")
-	arch.Load()
-}
@@ -0,0 +1,288 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	SamplingGridSize = 128
+	MaxOctreeDepth   = 24
+)
+
+// Builder creates an octree from points held in memory. Every node keeps a
+// grid sample of the points that reach it, one point per cell of a
+// SamplingGridSize^3 grid over the node box, and passes the rest on to its
+// children until fewer than MaxPointsPerNode remain.
+type Builder struct {
+	MaxPointsPerNode int
+	metadata         *Metadata
+	points           []Attribute
+}
+
+// NewBuilder creates a builder for the schema, Scale and Offset of meta. The
+// BoundingBox of meta is replaced by the cubic octree bounds on Build.
+func NewBuilder(meta *Metadata) *Builder {
+	return &Builder{MaxPointsPerNode: MaxPointsPerChunk, metadata: meta, points: NewPoints(meta.Attrs, 0)}
+}
+
+func (b *Builder) GetMetadata() *Metadata {
+	return b.metadata
+}
+
+// Add appends points quantized with the Scale and Offset of src, converting
+// them to the builder's quantization and schema. Attributes missing in the
+// points are filled with zeros.
+func (b *Builder) Add(src *Metadata, points []Attribute) error {
+	conformed, err := ConformPoints(b.metadata, src, points)
+	if err != nil {
+		return err
+	}
+	for i := range b.points {
+		b.points[i].Append(&conformed[i])
+	}
+	return nil
+}
+
+func (b *Builder) AddReader(rd PointReader) error {
+	for {
+		points, err := rd.ReadPoints(MaxPointsPerChunk * 10)
+		if len(points) > 0 {
+			if aerr := b.Add(rd.GetMetadata(), points); aerr != nil {
+				return aerr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *Builder) NumPoints() int {
+	return b.points[0].Len()
+}
+
+func (b *Builder) Build() (*Node, error) {
+	position := FindAttribute(b.points, POSITION.Name)
+	if position == nil {
+		return nil, errors.New("schema has no position attribute")
+	}
+	numPoints := position.Len()
+	if numPoints == 0 {
+		return nil, errors.New("no points to build an octree from")
+	}
+
+	tight := pointBounds(b.metadata, position)
+	box := tight.Cubic()
+	spacing := box.Size()[0] / SamplingGridSize
+	b.metadata.BoundingBox = box
+	b.metadata.Spacing = &spacing
+	b.metadata.BytesPerPoint = b.metadata.bytesPerPoint()
+	updateAttributeRanges(b.metadata, b.points)
+
+	indices := make([]int, numPoints)
+	for i := range indices {
+		indices[i] = i
+	}
+	root := &Node{Name: "r", Box: box}
+	b.build(root, indices, position)
+	return root, nil
+}
+
+func (b *Builder) build(n *Node, indices []int, position *Attribute) {
+	if len(indices) <= b.MaxPointsPerNode || n.Level() >= MaxOctreeDepth {
+		b.setPoints(n, indices)
+		return
+	}
+
+	var (
+		own    []int
+		childs [8][]int
+	)
+	center := n.Box.Center()
+	taken := make(map[int]bool)
+	for _, i := range indices {
+		p := b.metadata.WorldPosition(position, i)
+		key := samplingCell(n.Box, p)
+		if !taken[key] {
+			taken[key] = true
+			own = append(own, i)
+			continue
+		}
+		childs[octantOf(p, center)] = append(childs[octantOf(p, center)], i)
+	}
+
+	b.setPoints(n, own)
+	for idx, ci := range childs {
+		if len(ci) == 0 {
+			continue
+		}
+		child := &Node{Name: fmt.Sprintf("%s%d", n.Name, idx), Box: n.Box.Child(idx), Parent: n}
+		n.Childs[idx] = child
+		b.build(child, ci, position)
+	}
+	n.ChildMask = ChildMaskOf(n)
+	if n.ChildMask != 0 {
+		n.Type = NT_NORMAL
+	}
+}
+
+func (b *Builder) setPoints(n *Node, indices []int) {
+	n.Attrs = make([]Attribute, len(b.points))
+	for i := range b.points {
+		n.Attrs[i] = b.points[i].Subset(indices)
+	}
+	n.NumPoints = uint32(len(indices))
+	n.Type = NT_LEAF
+}
+
+func samplingCell(box AABB, p [3]float64) int {
+	size := box.Size()
+	key := 0
+	for k := 0; k < 3; k++ {
+		c := int((p[k] - box.Min[k]) / size[k] * SamplingGridSize)
+		if c < 0 {
+			c = 0
+		} else if c >= SamplingGridSize {
+			c = SamplingGridSize - 1
+		}
+		key = key*SamplingGridSize + c
+	}
+	return key
+}
+
+func octantOf(p, center [3]float64) int {
+	idx := 0
+	if p[0] >= center[0] {
+		idx |= 0b100
+	}
+	if p[1] >= center[1] {
+		idx |= 0b010
+	}
+	if p[2] >= center[2] {
+		idx |= 0b001
+	}
+	return idx
+}
+
+func pointBounds(meta *Metadata, position *Attribute) AABB {
+	box := AABB{Min: [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, Max: [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}}
+	for i := 0; i < position.Len(); i++ {
+		p := meta.WorldPosition(position, i)
+		for k := 0; k < 3; k++ {
+			box.Min[k] = math.Min(box.Min[k], p[k])
+			box.Max[k] = math.Max(box.Max[k], p[k])
+		}
+	}
+	return box
+}
+
+func updateAttributeRanges(meta *Metadata, points []Attribute) {
+	for i := range meta.Attrs {
+		meta.Attrs[i].Min = nil
+		meta.Attrs[i].Max = nil
+	}
+	extendAttributeRanges(meta, points)
+}
+
+func extendAttributeRanges(meta *Metadata, points []Attribute) {
+	for i := range meta.Attrs {
+		a := FindAttribute(points, meta.Attrs[i].Name)
+		if a == nil || a.Len() == 0 {
+			continue
+		}
+		min := make([]float64, a.NumElements)
+		max := make([]float64, a.NumElements)
+		for e := range min {
+			min[e] = math.Inf(1)
+			max[e] = math.Inf(-1)
+			if len(meta.Attrs[i].Min) == a.NumElements && len(meta.Attrs[i].Max) == a.NumElements {
+				min[e] = meta.Attrs[i].Min[e]
+				max[e] = meta.Attrs[i].Max[e]
+			}
+		}
+		for p := 0; p < a.Len(); p++ {
+			for e := range min {
+				v := a.GetFloat64(p, e)
+				if a.Name == POSITION.Name {
+					v = v*meta.Scale[e] + meta.offsetVector()[e]
+				}
+				min[e] = math.Min(min[e], v)
+				max[e] = math.Max(max[e], v)
+			}
+		}
+		meta.Attrs[i].Min = min
+		meta.Attrs[i].Max = max
+	}
+}
+
+// ConformPoints converts points described by src into the schema and
+// position quantization of dst. Positions are reprojected if both have a
+// different CRS.
+func ConformPoints(dst, src *Metadata, points []Attribute) ([]Attribute, error) {
+	position := FindAttribute(points, POSITION.Name)
+	if position == nil {
+		return nil, errors.New("points have no position attribute")
+	}
+	numPoints := position.Len()
+	tr, err := projectionTransform(src, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Attribute, len(dst.Attrs))
+	for i := range dst.Attrs {
+		a := FindAttribute(points, dst.Attrs[i].Name)
+		switch {
+		case a != nil && a.Name == POSITION.Name:
+			if ret[i], err = requantize(a, src, dst, tr); err != nil {
+				return nil, err
+			}
+		case a != nil && a.Type == dst.Attrs[i].Type && a.NumElements == dst.Attrs[i].NumElements:
+			ret[i] = *a
+			ret[i].Description = dst.Attrs[i].Description
+			ret[i].Min = dst.Attrs[i].Min
+			ret[i].Max = dst.Attrs[i].Max
+		default:
+			ret[i] = NewPoints(dst.Attrs[i:i+1], numPoints)[0]
+			if a == nil {
+				continue
+			}
+			for p := 0; p < numPoints; p++ {
+				for e := 0; e < a.NumElements && e < ret[i].NumElements; e++ {
+					ret[i].SetFloat64(p, e, a.GetFloat64(p, e))
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func requantize(position *Attribute, src, dst *Metadata, tr *Transform) (Attribute, error) {
+	if tr == nil && src.Scale == dst.Scale && src.offsetVector() == dst.offsetVector() {
+		return *position, nil
+	}
+	ret := NewPoints([]Attribute{POSITION}, position.Len())[0]
+	for i := 0; i < position.Len(); i++ {
+		world := src.WorldPosition(position, i)
+		if tr != nil {
+			var err error
+			if world, err = tr.Apply(world); err != nil {
+				return ret, err
+			}
+		}
+		p, err := dst.IntegerPosition(world)
+		if err != nil {
+			return ret, err
+		}
+		for k := 0; k < 3; k++ {
+			ret.SetFloat64(i, k, float64(p[k]))
+		}
+	}
+	return ret, nil
+}
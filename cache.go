@@ -0,0 +1,116 @@
+package potree
+
+import (
+	"container/list"
+	"sync"
+)
+
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+	Bytes     int64
+	MaxBytes  int64
+}
+
+type cacheEntry struct {
+	name  string
+	attrs []Attribute
+	size  int64
+}
+
+// NodeCache keeps the decoded points of recently read nodes, keyed by node
+// name, evicting the least recently used ones once their decoded size
+// exceeds MaxBytes. A cache belongs to a single archive. Cached attributes
+// are shared between readers and must not be modified.
+type NodeCache struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	bytes     int64
+	lru       *list.List
+	items     map[string]*list.Element
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func NewNodeCache(maxBytes int64) *NodeCache {
+	return &NodeCache{maxBytes: maxBytes, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+func decodedSize(attrs []Attribute) int64 {
+	size := int64(0)
+	for i := range attrs {
+		size += int64(len(attributeBytes(attrs[i].Data)))
+	}
+	return size
+}
+
+func (c *NodeCache) Get(name string) ([]Attribute, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[name]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).attrs, true
+}
+
+// Put stores attrs for the node name. Nodes larger than the whole cache are
+// not kept.
+func (c *NodeCache) Put(name string, attrs []Attribute) {
+	size := decodedSize(attrs)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[name]; ok {
+		c.removeElement(e)
+	}
+	if size > c.maxBytes {
+		return
+	}
+	c.items[name] = c.lru.PushFront(&cacheEntry{name: name, attrs: attrs, size: size})
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *NodeCache) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[name]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *NodeCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+func (c *NodeCache) removeElement(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.items, entry.name)
+	c.bytes -= entry.size
+}
+
+func (c *NodeCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   len(c.items),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
@@ -0,0 +1,45 @@
+package potree
+
+import (
+	"testing"
+)
+
+func TestNodeCache(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+
+	loaded := NewArchive(arch.path)
+	if err := loaded.LoadHierarchy(); err != nil {
+		t.Fatal(err)
+	}
+	// position and intensity take 14 bytes per point, enough for the root
+	// and one child
+	cache := NewNodeCache(100*14 + 50*14)
+	loaded.SetCache(cache)
+
+	for _, name := range []string{"r", "r0", "r", "r3", "r0"} {
+		points, err := loaded.ReadNode(loaded.GetNode(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := FindAttribute(points, POSITION.Name).Len(); n != int(loaded.GetNode(name).NumPoints) {
+			t.Fatalf("node %s: got %d points", name, n)
+		}
+		if loaded.GetNode(name).Attrs != nil {
+			t.Fatalf("node %s keeps its points", name)
+		}
+	}
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 4 || stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Bytes > stats.MaxBytes {
+		t.Errorf("cache holds %d bytes, limit %d", stats.Bytes, stats.MaxBytes)
+	}
+
+	if _, err := loaded.SetAttribute(INTENSITY.Name, 0, 7, func(p *Point) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Stats().Entries != 0 {
+		t.Error("rewritten nodes stay cached")
+	}
+}
@@ -0,0 +1,404 @@
+package potree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+const (
+	ChunkGridLevels = 7
+	ChunkGridSize   = 1 << ChunkGridLevels
+
+	chunkFlushSize = 1 << 20
+)
+
+type chunk struct {
+	name      string
+	box       AABB
+	numPoints int
+	path      string
+	pending   []byte
+}
+
+// chunker splits the input into chunks small enough to be indexed in
+// memory, following the counting sort of PotreeConverter 2: points are
+// counted into a ChunkGridSize^3 grid, neighbouring cells are merged along
+// the octree while they stay below maxChunkPoints, and the points of each
+// chunk are spilled to a file in dir.
+type chunker struct {
+	meta           *Metadata
+	box            AABB
+	dir            string
+	maxChunkPoints int64
+	maxPending     int
+	chunks         []*chunk
+	lookup         []int32
+	pending        int
+}
+
+// convertChunked builds the octree without holding all points in memory.
+// The inputs are read twice, once to count and once to distribute the
+// points to chunk files. The chunks are indexed in parallel into subtrees
+// that are streamed to octree.bin, the levels above the chunks are sampled
+// from the chunk roots.
+func convertChunked(inputs []string, opts *Options) (*PotreeArchive, error) {
+	readers, err := openReaders(inputs)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := convertMetadata(readers, opts)
+	inputMetas := readerMetadata(readers)
+	closeReaders(readers)
+	if err != nil {
+		return nil, err
+	}
+	if FindAttribute(meta.Attrs, POSITION.Name) == nil {
+		return nil, fmt.Errorf("inputs have no position attribute")
+	}
+
+	dir, err := ioutil.TempDir(opts.TempDir, "potree-chunks")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	meta.BoundingBox = meta.BoundingBox.Cubic()
+	meta.BytesPerPoint = meta.bytesPerPoint()
+	workers := workerCount(opts.Concurrency, math.MaxInt32)
+	// a chunk is held about four times while indexing: decoded, sampled into
+	// nodes, encoded and written
+	maxChunkPoints := opts.MemoryBudget / int64(workers*meta.BytesPerPoint*4)
+	if maxChunkPoints < MaxPointsPerChunk {
+		maxChunkPoints = MaxPointsPerChunk
+	}
+	c := &chunker{
+		meta:           meta,
+		box:            meta.BoundingBox,
+		dir:            dir,
+		maxChunkPoints: maxChunkPoints,
+		maxPending:     int(opts.MemoryBudget / 4),
+	}
+
+	counts := make([]int64, ChunkGridSize*ChunkGridSize*ChunkGridSize)
+	err = c.readInputs(inputs, func(points []Attribute) error {
+		position := FindAttribute(points, POSITION.Name)
+		for i := 0; i < position.Len(); i++ {
+			cell, err := c.cell(meta.WorldPosition(position, i))
+			if err != nil {
+				return err
+			}
+			counts[cell]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.makeChunks(counts)
+	counts = nil
+
+	if err := c.readInputs(inputs, c.distribute); err != nil {
+		return nil, err
+	}
+	for _, ch := range c.chunks {
+		if err := c.flush(ch); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := NewStreamWriter(opts.Outdir, meta)
+	if err != nil {
+		return nil, err
+	}
+	w.GetArchive().SetOptions(opts)
+	if err := c.index(w, opts.Concurrency); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	arch := w.GetArchive()
+	q := inputQuantization(arch.metadata, inputMetas)
+	arch.quantization = &q
+	return arch, nil
+}
+
+// readInputs calls fn with batches of points of every input converted to
+// the chunker schema.
+func (c *chunker) readInputs(inputs []string, fn func(points []Attribute) error) error {
+	for _, p := range inputs {
+		rd, err := OpenPointReader(p)
+		if err != nil {
+			return err
+		}
+		for err == nil {
+			var points []Attribute
+			points, err = rd.ReadPoints(MaxPointsPerChunk * 10)
+			if len(points) > 0 {
+				conformed, cerr := ConformPoints(c.meta, rd.GetMetadata(), points)
+				if cerr == nil {
+					cerr = fn(conformed)
+				}
+				if cerr != nil {
+					err = cerr
+				}
+			}
+		}
+		rd.Close()
+		if err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// cell returns the grid cell of p, points on the upper faces of the box
+// belong to the last cell.
+func (c *chunker) cell(p [3]float64) (int, error) {
+	size := c.box.Size()
+	idx := 0
+	for k := 0; k < 3; k++ {
+		v := int((p[k] - c.box.Min[k]) / size[k] * ChunkGridSize)
+		if p[k] < c.box.Min[k]-c.meta.Scale[k] || p[k] > c.box.Max[k]+c.meta.Scale[k] {
+			return 0, fmt.Errorf("point %v lies outside the bounding box of its input", p)
+		}
+		if v < 0 {
+			v = 0
+		} else if v >= ChunkGridSize {
+			v = ChunkGridSize - 1
+		}
+		idx = idx*ChunkGridSize + v
+	}
+	return idx, nil
+}
+
+func (c *chunker) makeChunks(counts []int64) {
+	pyramid := make([][]int64, ChunkGridLevels+1)
+	pyramid[ChunkGridLevels] = counts
+	for l := ChunkGridLevels; l > 0; l-- {
+		size, half := 1<<uint(l), 1<<uint(l-1)
+		parent := make([]int64, half*half*half)
+		for x := 0; x < size; x++ {
+			for y := 0; y < size; y++ {
+				for z := 0; z < size; z++ {
+					parent[((x/2)*half+y/2)*half+z/2] += pyramid[l][(x*size+y)*size+z]
+				}
+			}
+		}
+		pyramid[l-1] = parent
+	}
+
+	c.lookup = make([]int32, len(counts))
+	var visit func(l, x, y, z int, name string, box AABB)
+	visit = func(l, x, y, z int, name string, box AABB) {
+		size := 1 << uint(l)
+		count := pyramid[l][(x*size+y)*size+z]
+		if count == 0 {
+			return
+		}
+		if count > c.maxChunkPoints && l < ChunkGridLevels {
+			for i := 0; i < 8; i++ {
+				visit(l+1, 2*x+(i>>2)&1, 2*y+(i>>1)&1, 2*z+i&1, name+strconv.Itoa(i), box.Child(i))
+			}
+			return
+		}
+		idx := int32(len(c.chunks))
+		c.chunks = append(c.chunks, &chunk{name: name, box: box, path: path.Join(c.dir, name+".bin")})
+		span := 1 << uint(ChunkGridLevels-l)
+		for cx := x * span; cx < (x+1)*span; cx++ {
+			for cy := y * span; cy < (y+1)*span; cy++ {
+				for cz := z * span; cz < (z+1)*span; cz++ {
+					c.lookup[(cx*ChunkGridSize+cy)*ChunkGridSize+cz] = idx
+				}
+			}
+		}
+	}
+	visit(0, 0, 0, 0, "r", c.box)
+}
+
+// distribute appends the points to the pending buffers of their chunks.
+func (c *chunker) distribute(points []Attribute) error {
+	position := FindAttribute(points, POSITION.Name)
+	groups := make(map[int32][]int)
+	var order []int32
+	for i := 0; i < position.Len(); i++ {
+		cell, err := c.cell(c.meta.WorldPosition(position, i))
+		if err != nil {
+			return err
+		}
+		idx := c.lookup[cell]
+		if _, ok := groups[idx]; !ok {
+			order = append(order, idx)
+		}
+		groups[idx] = append(groups[idx], i)
+	}
+	for _, idx := range order {
+		ch := c.chunks[idx]
+		subset := make([]Attribute, len(points))
+		for i := range points {
+			subset[i] = points[i].Subset(groups[idx])
+		}
+		n := &Node{}
+		n.NumPoints = uint32(len(groups[idx]))
+		data := n.compact(subset, false)
+		ch.pending = append(ch.pending, data...)
+		ch.numPoints += len(groups[idx])
+		c.pending += len(data)
+		if len(ch.pending) >= chunkFlushSize {
+			if err := c.flush(ch); err != nil {
+				return err
+			}
+		}
+	}
+	if c.pending > c.maxPending {
+		for _, ch := range c.chunks {
+			if err := c.flush(ch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *chunker) flush(ch *chunk) error {
+	if len(ch.pending) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(ch.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(ch.pending)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	c.pending -= len(ch.pending)
+	ch.pending = nil
+	return err
+}
+
+// index builds the subtree of every chunk and writes all its nodes but the
+// chunk root, then samples the levels above the chunks from the chunk roots.
+func (c *chunker) index(w *StreamWriter, concurrency int) error {
+	schema := append([]Attribute(nil), c.meta.Attrs...)
+	roots := make([]*Node, len(c.chunks))
+	err := forEach(context.Background(), concurrency, len(c.chunks), func(i int) error {
+		ch := c.chunks[i]
+		data, err := ioutil.ReadFile(ch.path)
+		if err != nil {
+			return err
+		}
+		os.Remove(ch.path)
+
+		n := &Node{Name: ch.name, Box: ch.box}
+		n.NumPoints = uint32(ch.numPoints)
+		points, err := n.uncompact(data, schema, false)
+		if err != nil {
+			return fmt.Errorf("chunk %s: %v", ch.name, err)
+		}
+		bld := &Builder{MaxPointsPerNode: MaxPointsPerChunk, metadata: c.meta, points: points}
+		indices := make([]int, ch.numPoints)
+		for i := range indices {
+			indices[i] = i
+		}
+		bld.build(n, indices, FindAttribute(points, POSITION.Name))
+		n.Traverse(func(d *Node) bool {
+			if d != n {
+				if err = w.WriteNode(d.Name, d.Attrs); err != nil {
+					return false
+				}
+				d.Attrs = nil
+			}
+			return true
+		})
+		roots[i] = n
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return c.stitch(w, roots)
+}
+
+// stitch creates the nodes above the chunk roots bottom up, each keeps one
+// point per sampling cell taken from its children, and writes them.
+func (c *chunker) stitch(w *StreamWriter, roots []*Node) error {
+	nodes := make(map[string]*Node)
+	for _, n := range roots {
+		nodes[n.Name] = n
+	}
+	var upper []*Node
+	for _, n := range roots {
+		for n.Name != "r" {
+			name := n.Name[:len(n.Name)-1]
+			parent, ok := nodes[name]
+			if !ok {
+				parent = &Node{Name: name}
+				nodes[name] = parent
+				upper = append(upper, parent)
+			}
+			idx := int(n.Name[len(n.Name)-1] - '0')
+			parent.Childs[idx] = n
+			n.Parent = parent
+			n = parent
+		}
+	}
+	root := nodes["r"]
+	root.Box = c.box
+	root.Traverse(func(n *Node) bool {
+		for i, child := range n.Childs {
+			if child != nil && child.Box == (AABB{}) {
+				child.Box = n.Box.Child(i)
+			}
+		}
+		return true
+	})
+
+	// deeper nodes first so that every node samples from finished children
+	sort.SliceStable(upper, func(i, j int) bool {
+		return upper[i].Level() > upper[j].Level()
+	})
+	for _, n := range upper {
+		n.Attrs = NewPoints(c.meta.Attrs, 0)
+		taken := make(map[int]bool)
+		for _, child := range n.Childs {
+			if child == nil {
+				continue
+			}
+			position := FindAttribute(child.Attrs, POSITION.Name)
+			var take, keep []int
+			for i := 0; i < position.Len(); i++ {
+				key := samplingCell(n.Box, c.meta.WorldPosition(position, i))
+				if taken[key] {
+					keep = append(keep, i)
+					continue
+				}
+				taken[key] = true
+				take = append(take, i)
+			}
+			for i := range child.Attrs {
+				sampled := child.Attrs[i].Subset(take)
+				n.Attrs[i].Append(&sampled)
+				child.Attrs[i] = child.Attrs[i].Subset(keep)
+			}
+			if len(keep) == 0 && ChildMaskOf(child) == 0 {
+				n.Childs[child.Name[len(child.Name)-1]-'0'] = nil
+				child.Attrs = nil
+				continue
+			}
+			if err := w.WriteNode(child.Name, child.Attrs); err != nil {
+				return err
+			}
+			child.Attrs = nil
+		}
+	}
+	return w.WriteNode(root.Name, root.Attrs)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runAppend(args []string) error {
+	fs := flag.NewFlagSet("append", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree append <archive> <input>...")
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errors.New("missing archive or inputs")
+	}
+
+	arch := potree.NewArchive(fs.Arg(0))
+	for _, p := range fs.Args()[1:] {
+		rd, err := potree.OpenPointReader(p)
+		if err != nil {
+			return err
+		}
+		points, err := potree.ReadAllPoints(rd)
+		if err == nil {
+			err = arch.Append(rd.GetMetadata(), points)
+		}
+		rd.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+	}
+	fmt.Printf("%s now holds %d points in %d nodes\n", fs.Arg(0), *arch.GetMetadata().Points, len(arch.GetNodes()))
+	return nil
+}
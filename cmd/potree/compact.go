@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runCompact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree compact <archive>...")
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing archive")
+	}
+	for _, p := range fs.Args() {
+		reclaimed, err := potree.NewArchive(p).Compact()
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		fmt.Printf("%s: reclaimed %s\n", p, formatBytes(reclaimed))
+	}
+	return nil
+}
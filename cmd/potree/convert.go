@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	opts := &potree.Options{}
+	fs.StringVar(&opts.Outdir, "o", "", "output directory")
+	fs.StringVar(&opts.Encoding, "encoding", potree.ENCODING_DEFAULT, "node encoding: DEFAULT, BROTLI, UNCOMPRESSED, or ZSTD and LZ4 which the viewer can't read")
+	fs.StringVar(&opts.Name, "name", "", "name stored in metadata.json")
+	fs.IntVar(&opts.BrotliQuality, "quality", 0, "brotli quality from 1 to 11, 0 for the default")
+	fs.IntVar(&opts.Concurrency, "j", 0, "number of encoding goroutines, 0 for one per CPU")
+	fs.IntVar(&opts.HierarchyStepSize, "hierarchy-step", potree.HierarchyStepSize, "octree levels per hierarchy chunk")
+	fs.StringVar(&opts.CRS, "crs", "", "reproject into this CRS, as EPSG:code, WKT or PROJ string")
+	fs.Float64Var(&opts.Precision, "precision", 0, "largest position rounding error in world units, 0 keeps the finest input scale")
+	memory := fs.Int64("memory", 0, "memory budget in MiB, enables out of core conversion")
+	fs.StringVar(&opts.TempDir, "tmp", "", "directory for temporary chunk files")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree convert -o <dir> [options] <input>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || opts.Outdir == "" {
+		fs.Usage()
+		return errors.New("missing inputs or output directory")
+	}
+
+	opts.MemoryBudget = *memory << 20
+	arch, err := potree.Convert(fs.Args(), opts)
+	if err != nil {
+		return err
+	}
+	meta := arch.GetMetadata()
+	fmt.Printf("wrote %d points in %d nodes to %s\n", *meta.Points, len(arch.GetNodes()), opts.Outdir)
+	fmt.Printf("quantization: %s\n", arch.GetQuantization())
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("%q needs %d comma separated values", s, n)
+	}
+	ret := make([]float64, n)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+func parseBox(s string) (*potree.AABB, error) {
+	v, err := parseFloats(s, 6)
+	if err != nil {
+		return nil, errors.New("box needs minx,miny,minz,maxx,maxy,maxz")
+	}
+	box := &potree.AABB{}
+	copy(box.Min[:], v[:3])
+	copy(box.Max[:], v[3:])
+	return box, nil
+}
+
+func newFrame(meta *potree.Metadata, s string) (*potree.LocalFrame, error) {
+	if s == "center" {
+		return potree.NewCenteredFrame(meta)
+	}
+	v, err := parseFloats(s, 3)
+	if err != nil {
+		return nil, errors.New("origin needs x,y,z or center")
+	}
+	return potree.NewLocalFrame(meta, [3]float64{v[0], v[1], v[2]})
+}
+
+func runExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	out := fs.String("o", "", "output file, .las, .ply or .csv")
+	format := fs.String("format", "", "output format, defaults to the output file extension")
+	boxFlag := fs.String("box", "", "region as minx,miny,minz,maxx,maxy,maxz in world coordinates")
+	level := fs.Int("level", -1, "deepest level to extract, all levels if negative")
+	nodes := fs.String("nodes", "", "comma separated node names, extracts their subtrees")
+	crsFlag := fs.String("crs", "", "reproject the points into this CRS, as EPSG:code, WKT or PROJ string")
+	originFlag := fs.String("origin", "", "write ply or csv float positions in an east-north-up frame at x,y,z or the center of the bounding box")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree extract -o <file> [options] <archive>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *out == "" {
+		fs.Usage()
+		return errors.New("missing archive or output file")
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*out), ".")
+	}
+	write := map[string]func(*os.File, *potree.Metadata, []potree.Attribute) error{
+		"las": func(f *os.File, m *potree.Metadata, p []potree.Attribute) error { return potree.WriteLAS(f, m, p) },
+		"ply": func(f *os.File, m *potree.Metadata, p []potree.Attribute) error { return potree.WritePLY(f, m, p) },
+		"csv": func(f *os.File, m *potree.Metadata, p []potree.Attribute) error { return potree.WriteCSV(f, m, p) },
+	}[strings.ToLower(*format)]
+	if write == nil {
+		return fmt.Errorf("unsupported output format %q", *format)
+	}
+	writeLocal := map[string]func(*os.File, *potree.LocalFrame, []potree.Attribute) error{
+		"ply": func(f *os.File, fr *potree.LocalFrame, p []potree.Attribute) error {
+			return potree.WriteLocalPLY(f, fr, p)
+		},
+		"csv": func(f *os.File, fr *potree.LocalFrame, p []potree.Attribute) error {
+			return potree.WriteLocalCSV(f, fr, p)
+		},
+	}[strings.ToLower(*format)]
+	if *originFlag != "" && writeLocal == nil {
+		return fmt.Errorf("%s output can't use a local origin", *format)
+	}
+
+	var box *potree.AABB
+	if *boxFlag != "" {
+		var err error
+		if box, err = parseBox(*boxFlag); err != nil {
+			return err
+		}
+	}
+	var names []string
+	if *nodes != "" {
+		names = strings.Split(*nodes, ",")
+	}
+
+	arch := potree.NewArchive(fs.Arg(0))
+	if err := arch.LoadHierarchy(); err != nil {
+		return err
+	}
+	points, err := arch.Query(box, *level, names...)
+	if err != nil {
+		return err
+	}
+
+	meta := arch.GetMetadata()
+	if *crsFlag != "" {
+		crs, err := potree.ParseCRS(*crsFlag)
+		if err != nil {
+			return err
+		}
+		if meta, points, err = potree.ReprojectPoints(meta, points, crs); err != nil {
+			return err
+		}
+	}
+	if *originFlag != "" {
+		frame, err := newFrame(meta, *originFlag)
+		if err != nil {
+			return err
+		}
+		write = func(f *os.File, m *potree.Metadata, p []potree.Attribute) error { return writeLocal(f, frame, p) }
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := write(f, meta, points); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d points to %s\n", points[0].Len(), *out)
+	return nil
+}
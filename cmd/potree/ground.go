@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runGround(args []string) error {
+	fs := flag.NewFlagSet("ground", flag.ExitOnError)
+	opts := potree.GroundOptions{}
+	fs.Float64Var(&opts.CellSize, "cell", 0, "grid cell size in world units, 0 for 1")
+	fs.Float64Var(&opts.MaxWindow, "window", 0, "largest filter window in world units, larger than the largest building, 0 for 33")
+	fs.Float64Var(&opts.Slope, "slope", 0, "terrain slope as height per distance, 0 for 0.7")
+	fs.Float64Var(&opts.InitialDistance, "initial-distance", 0, "height above the terrain still ground for the smallest window, 0 for 0.15")
+	fs.Float64Var(&opts.MaxDistance, "max-distance", 0, "largest height above the terrain still ground, 0 for 2.5")
+	fs.Float64Var(&opts.TileSize, "tile", 0, "horizontal tile size in world units, 0 for 8 windows")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree ground [options] <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing archive")
+	}
+	for _, p := range fs.Args() {
+		n, err := potree.NewArchive(p).ClassifyGround(opts)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		fmt.Printf("%s: %d ground points\n", p, n)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree info <archive>")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing archive")
+	}
+
+	arch := potree.NewArchive(fs.Arg(0))
+	if err := arch.LoadHierarchy(); err != nil {
+		return err
+	}
+	meta := arch.GetMetadata()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "path:\t%s\n", fs.Arg(0))
+	fmt.Fprintf(w, "version:\t%s\n", meta.Version)
+	if meta.Name != "" {
+		fmt.Fprintf(w, "name:\t%s\n", meta.Name)
+	}
+	if meta.Points != nil {
+		fmt.Fprintf(w, "points:\t%d\n", *meta.Points)
+	}
+	encoding := potree.ENCODING_DEFAULT
+	if meta.Encoding != nil {
+		encoding = *meta.Encoding
+	}
+	fmt.Fprintf(w, "encoding:\t%s\n", encoding)
+	fmt.Fprintf(w, "bounding box:\t%v - %v\n", meta.BoundingBox.Min, meta.BoundingBox.Max)
+	fmt.Fprintf(w, "scale:\t%v\n", meta.Scale)
+	if meta.Offset != nil {
+		fmt.Fprintf(w, "offset:\t%v\n", *meta.Offset)
+	}
+	q := meta.GetQuantization()
+	fmt.Fprintf(w, "max error:\t%v\n", q.MaxError)
+	if q.Overflows() {
+		fmt.Fprintf(w, "warning:\tbounds overflow int32 positions on axes %v\n", q.Overflow)
+	}
+	if meta.Spacing != nil {
+		fmt.Fprintf(w, "spacing:\t%v\n", *meta.Spacing)
+	}
+	if crs, err := meta.GetCRS(); err != nil {
+		fmt.Fprintf(w, "projection:\t%s (%v)\n", *meta.Projection, err)
+	} else if crs != nil {
+		unit, _ := crs.GetUnit()
+		fmt.Fprintf(w, "crs:\t%s, %s\n", crs.Name, crs)
+		fmt.Fprintf(w, "axes:\t%s in %s\n", strings.Join(crs.Axes, ", "), unit)
+		if crs.Vertical != nil {
+			fmt.Fprintf(w, "vertical crs:\t%s in %s\n", crs.Vertical.Name, crs.Vertical.Unit)
+		}
+	}
+	if meta.Hierarchy != nil {
+		fmt.Fprintf(w, "hierarchy:\tstep size %d, first chunk %d bytes\n", meta.Hierarchy.StepSize, meta.Hierarchy.FirstChunkSize)
+	}
+	fmt.Fprintf(w, "bytes per point:\t%d\n", meta.BytesPerPoint)
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ATTRIBUTE\tTYPE\tELEMENTS\tSIZE\tMIN\tMAX")
+	for _, a := range meta.Attrs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", a.Name, a.Type, a.NumElements, a.Size, formatRange(a.Min), formatRange(a.Max))
+	}
+	w.Flush()
+
+	type levelStats struct {
+		nodes  int
+		points int64
+	}
+	var (
+		levels     []levelStats
+		minPoints  = int64(-1)
+		maxPoints  int64
+		numPoints  int64
+		octreeSize int64
+		nodes      = arch.GetNodes()
+	)
+	for _, n := range nodes {
+		for len(levels) <= n.Level() {
+			levels = append(levels, levelStats{})
+		}
+		levels[n.Level()].nodes++
+		levels[n.Level()].points += int64(n.NumPoints)
+		numPoints += int64(n.NumPoints)
+		octreeSize += n.ByteSize
+		if minPoints < 0 || int64(n.NumPoints) < minPoints {
+			minPoints = int64(n.NumPoints)
+		}
+		if int64(n.NumPoints) > maxPoints {
+			maxPoints = int64(n.NumPoints)
+		}
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "LEVEL\tNODES\tPOINTS\t")
+	for i, l := range levels {
+		fmt.Fprintf(w, "%d\t%d\t%d\t\n", i, l.nodes, l.points)
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "nodes:\t%d\n", len(nodes))
+	if len(nodes) > 0 {
+		fmt.Fprintf(w, "points per node:\tmin %d, avg %d, max %d\n", minPoints, numPoints/int64(len(nodes)), maxPoints)
+	}
+	fmt.Fprintf(w, "node payloads:\t%s\n", formatBytes(octreeSize))
+	for _, name := range []string{potree.OctreeName, potree.HierarchyName} {
+		if info, err := os.Stat(path.Join(fs.Arg(0), name)); err == nil {
+			fmt.Fprintf(w, "%s:\t%s\n", name, formatBytes(info.Size()))
+		}
+	}
+	if numPoints > 0 {
+		fmt.Fprintf(w, "stored bytes per point:\t%.2f\n", float64(octreeSize)/float64(numPoints))
+	}
+	return w.Flush()
+}
+
+func formatRange(v []float64) string {
+	if len(v) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(v))
+	for i, f := range v {
+		parts[i] = fmt.Sprintf("%g", f)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"info", "print metadata, attributes and hierarchy statistics of an archive", runInfo},
+	{"validate", "check the integrity of an archive", runValidate},
+	{"convert", "build an archive from LAS, CSV or single-file .potree inputs", runConvert},
+	{"extract", "dump nodes or a region of an archive to LAS, PLY or CSV", runExtract},
+	{"append", "add points to an existing archive without rebuilding it", runAppend},
+	{"compact", "rewrite octree.bin without the payloads of replaced nodes", runCompact},
+	{"reencode", "convert the nodes of an archive to another encoding", runReencode},
+	{"requantize", "rewrite the positions of an archive with another precision", runRequantize},
+	{"normals", "estimate point normals from their nearest neighbours", runNormals},
+	{"ground", "classify ground points with a progressive morphological filter", runGround},
+	{"merge", "merge archives with compatible attributes into one", runMerge},
+	{"serve", "serve archives over HTTP for local viewer testing", runServe},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: potree <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "potree %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	opts := &potree.Options{}
+	fs.StringVar(&opts.Outdir, "o", "", "output directory")
+	fs.StringVar(&opts.Encoding, "encoding", "", "node encoding, defaults to the encoding of the first archive")
+	fs.StringVar(&opts.Name, "name", "", "name stored in metadata.json")
+	fs.IntVar(&opts.BrotliQuality, "quality", 0, "brotli quality from 1 to 11, 0 for the default")
+	fs.IntVar(&opts.Concurrency, "j", 0, "number of encoding goroutines, 0 for one per CPU")
+	fs.IntVar(&opts.HierarchyStepSize, "hierarchy-step", potree.HierarchyStepSize, "octree levels per hierarchy chunk")
+	fs.StringVar(&opts.CRS, "crs", "", "reproject into this CRS, as EPSG:code, WKT or PROJ string")
+	fs.Float64Var(&opts.Precision, "precision", 0, "largest position rounding error in world units, 0 keeps the finest input scale")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree merge -o <dir> [options] <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || opts.Outdir == "" {
+		fs.Usage()
+		return errors.New("missing archives or output directory")
+	}
+
+	archives := make([]*potree.PotreeArchive, fs.NArg())
+	for i, p := range fs.Args() {
+		archives[i] = potree.NewArchive(p)
+	}
+	arch, err := potree.Merge(archives, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("merged %d points into %d nodes in %s\n", *arch.GetMetadata().Points, len(arch.GetNodes()), opts.Outdir)
+	fmt.Printf("quantization: %s\n", arch.GetQuantization())
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runNormals(args []string) error {
+	fs := flag.NewFlagSet("normals", flag.ExitOnError)
+	k := fs.Int("k", 16, "number of nearest neighbours fitted per point")
+	viewpointFlag := fs.String("viewpoint", "", "orient normals towards x,y,z instead of upwards")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree normals [options] <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing archive")
+	}
+	var viewpoint *[3]float64
+	if *viewpointFlag != "" {
+		v, err := parseFloats(*viewpointFlag, 3)
+		if err != nil {
+			return err
+		}
+		viewpoint = &[3]float64{v[0], v[1], v[2]}
+	}
+	for _, p := range fs.Args() {
+		if err := potree.NewArchive(p).EstimateNormals(*k, viewpoint); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		fmt.Printf("%s: normals estimated from %d neighbours\n", p, *k)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runReencode(args []string) error {
+	fs := flag.NewFlagSet("reencode", flag.ExitOnError)
+	opts := &potree.Options{}
+	fs.StringVar(&opts.Encoding, "encoding", potree.ENCODING_BROTLI, "node encoding: DEFAULT, BROTLI, UNCOMPRESSED, or ZSTD and LZ4 which the viewer can't read")
+	fs.IntVar(&opts.BrotliQuality, "quality", 0, "brotli quality from 1 to 11, 0 for the default")
+	fs.IntVar(&opts.BrotliWindow, "window", 0, "brotli window size as base 2 logarithm from 10 to 24, 0 for automatic")
+	fs.IntVar(&opts.ZstdLevel, "zstd-level", 0, "zstd level from 1 to 22, 0 for the default")
+	fs.IntVar(&opts.LZ4Level, "lz4-level", 0, "lz4 level from 1 to 9, 0 for fast compression")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree reencode [options] <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing archive")
+	}
+	for _, p := range fs.Args() {
+		arch := potree.NewArchive(p)
+		arch.SetOptions(opts)
+		if err := arch.Reencode(opts.Encoding); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		fmt.Printf("%s: encoded as %s\n", p, opts.Encoding)
+	}
+	return nil
+}
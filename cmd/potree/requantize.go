@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runRequantize(args []string) error {
+	fs := flag.NewFlagSet("requantize", flag.ExitOnError)
+	precision := fs.Float64("precision", 0, "largest position rounding error in world units, the scale is twice it")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree requantize -precision <value> <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *precision <= 0 {
+		fs.Usage()
+		return errors.New("missing archive or precision")
+	}
+	for _, p := range fs.Args() {
+		q, err := potree.NewArchive(p).Requantize(*precision)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		fmt.Printf("%s: %s\n", p, q)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "listen address")
+	origin := fs.String("cors", "*", "Access-Control-Allow-Origin value, empty disables CORS headers")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree serve [options] [prefix=]<archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing archive")
+	}
+
+	srv := potree.NewServer()
+	srv.AllowOrigin = *origin
+	for _, arg := range fs.Args() {
+		prefix, p := "", arg
+		if i := strings.Index(arg, "="); i >= 0 {
+			prefix, p = arg[:i], arg[i+1:]
+		} else {
+			prefix = strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		}
+		if err := srv.Add(prefix, p); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range srv.Prefixes() {
+		log.Printf("serving http://%s%s%s", *addr, prefix, potree.MetadataName)
+	}
+	return http.ListenAndServe(*addr, srv)
+}
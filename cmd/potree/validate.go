@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	potree "github.com/flywave/go-potree"
+)
+
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: potree validate [-json] <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing archive")
+	}
+
+	failed := 0
+	for _, p := range fs.Args() {
+		report, err := potree.NewArchive(p).Validate()
+		if err != nil {
+			return err
+		}
+		if !report.OK() {
+			failed++
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			fmt.Print(report)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d archives have issues", failed, fs.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package potree
+
+// nodeCodec compresses node payloads laid out like BROTLI ones: attribute
+// after attribute with positions and colors as Morton codes.
+type nodeCodec struct {
+	encode func(data []byte, opts *Options) ([]byte, error)
+	decode func(data []byte) ([]byte, error)
+}
+
+var nodeCodecs = map[string]nodeCodec{
+	ENCODING_BROTLI: {
+		encode: func(data []byte, opts *Options) ([]byte, error) {
+			quality := opts.BrotliQuality
+			if quality <= 0 {
+				quality = DefaultCompression
+			}
+			return Brotli{}.EncodeWindow(nil, data, quality, opts.BrotliWindow)
+		},
+		decode: func(data []byte) ([]byte, error) {
+			return Brotli{}.Decode(nil, data)
+		},
+	},
+	ENCODING_ZSTD: {
+		encode: func(data []byte, opts *Options) ([]byte, error) {
+			return Zstd{}.EncodeLevel(nil, data, opts.ZstdLevel)
+		},
+		decode: func(data []byte) ([]byte, error) {
+			return Zstd{}.Decode(nil, data)
+		},
+	},
+	ENCODING_LZ4: {
+		encode: func(data []byte, opts *Options) ([]byte, error) {
+			return LZ4{}.EncodeLevel(nil, data, opts.LZ4Level)
+		},
+		decode: func(data []byte) ([]byte, error) {
+			return LZ4{}.Decode(nil, data)
+		},
+	},
+}
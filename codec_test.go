@@ -0,0 +1,116 @@
+package potree
+
+import (
+	"bytes"
+	"testing"
+)
+
+// samplePayload returns the points of the bundled sample laid out as a
+// BROTLI node before compression.
+func samplePayload(tb testing.TB) []byte {
+	rd, err := OpenCPotreeReader("cpotree_2.0.potree")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer rd.Close()
+	points, err := ReadAllPoints(rd)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	n := &Node{}
+	n.NumPoints = uint32(points[0].Len())
+	return n.compact(points, true)
+}
+
+func TestCodecs(t *testing.T) {
+	raw := samplePayload(t)
+	for _, encoding := range []string{ENCODING_BROTLI, ENCODING_ZSTD, ENCODING_LZ4} {
+		codec := nodeCodecs[encoding]
+		for _, opts := range []*Options{{}, {BrotliQuality: 9, ZstdLevel: 19, LZ4Level: 9}} {
+			compressed, err := codec.encode(raw, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := codec.decode(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, raw) {
+				t.Errorf("%s: round trip differs", encoding)
+			}
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, encoding string) {
+	raw := samplePayload(b)
+	codec := nodeCodecs[encoding]
+	var compressed []byte
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if compressed, err = codec.encode(raw, &Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(raw))/float64(len(compressed)), "ratio")
+}
+
+func benchmarkDecode(b *testing.B, encoding string) {
+	raw := samplePayload(b)
+	codec := nodeCodecs[encoding]
+	compressed, err := codec.encode(raw, &Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.decode(compressed); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(raw))/float64(len(compressed)), "ratio")
+}
+
+// benchmarkParallel encodes and decodes from GOMAXPROCS goroutines at once,
+// as the workers of Load and Save do.
+func benchmarkParallel(b *testing.B, encoding string, decode bool) {
+	raw := samplePayload(b)
+	codec := nodeCodecs[encoding]
+	compressed, err := codec.encode(raw, &Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var err error
+			if decode {
+				_, err = codec.decode(compressed)
+			} else {
+				_, err = codec.encode(raw, &Options{})
+			}
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkEncodeBrotli(b *testing.B) { benchmarkEncode(b, ENCODING_BROTLI) }
+func BenchmarkEncodeZstd(b *testing.B)   { benchmarkEncode(b, ENCODING_ZSTD) }
+func BenchmarkEncodeLZ4(b *testing.B)    { benchmarkEncode(b, ENCODING_LZ4) }
+func BenchmarkDecodeBrotli(b *testing.B) { benchmarkDecode(b, ENCODING_BROTLI) }
+func BenchmarkDecodeZstd(b *testing.B)   { benchmarkDecode(b, ENCODING_ZSTD) }
+func BenchmarkDecodeLZ4(b *testing.B)    { benchmarkDecode(b, ENCODING_LZ4) }
+
+func BenchmarkEncodeParallelBrotli(b *testing.B) { benchmarkParallel(b, ENCODING_BROTLI, false) }
+func BenchmarkEncodeParallelZstd(b *testing.B)   { benchmarkParallel(b, ENCODING_ZSTD, false) }
+func BenchmarkEncodeParallelLZ4(b *testing.B)    { benchmarkParallel(b, ENCODING_LZ4, false) }
+func BenchmarkDecodeParallelBrotli(b *testing.B) { benchmarkParallel(b, ENCODING_BROTLI, true) }
+func BenchmarkDecodeParallelZstd(b *testing.B)   { benchmarkParallel(b, ENCODING_ZSTD, true) }
+func BenchmarkDecodeParallelLZ4(b *testing.B)    { benchmarkParallel(b, ENCODING_LZ4, true) }
@@ -0,0 +1,289 @@
+package potree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+)
+
+// The archives in testdata/reference are written by generate.py next to
+// them from the Potree 2.0 format description, without code of this
+// package, and expected.json records their hierarchy records and decoded
+// points. converterSample is written by PotreeConverter 2.x.
+const (
+	referenceDirectory = "testdata/reference"
+	converterSample    = "cpotree_2.0.potree"
+)
+
+var referenceEncodings = []string{ENCODING_DEFAULT, ENCODING_BROTLI}
+
+type referenceNode struct {
+	Name           string   `json:"name"`
+	Type           NodeType `json:"type"`
+	ChildMask      uint8    `json:"childMask"`
+	NumPoints      uint32   `json:"numPoints"`
+	ByteOffset     int64    `json:"byteOffset"`
+	ByteSize       int64    `json:"byteSize"`
+	Position       []int32  `json:"position"`
+	Intensity      []uint16 `json:"intensity"`
+	Classification []uint8  `json:"classification"`
+	RGB            []uint16 `json:"rgb"`
+}
+
+type referenceArchive struct {
+	HierarchySize int64           `json:"hierarchySize"`
+	OctreeSize    int64           `json:"octreeSize"`
+	Nodes         []referenceNode `json:"nodes"`
+}
+
+// checksum hashes the points in Morton order so that it doesn't depend on
+// the order an encoding stores them in.
+func checksum(attrs []Attribute) string {
+	order := mortonOrder(FindAttribute(attrs, POSITION.Name))
+	h := fnv.New64a()
+	for i := range attrs {
+		sorted := attrs[i].Subset(order)
+		h.Write([]byte(attrs[i].Name))
+		h.Write(attributeBytes(sorted.Data))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func readReference(t *testing.T) map[string]referenceArchive {
+	data, err := ioutil.ReadFile(path.Join(referenceDirectory, "expected.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string]referenceArchive)
+	if err := json.Unmarshal(data, &ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// checkReferencePoints compares decoded points with want in stored order.
+func checkReferencePoints(t *testing.T, label string, attrs []Attribute, want referenceNode) {
+	for _, c := range []struct {
+		name string
+		want interface{}
+	}{
+		{POSITION.Name, want.Position},
+		{INTENSITY.Name, want.Intensity},
+		{CLASSIFICATION.Name, want.Classification},
+		{COLOR.Name, want.RGB},
+	} {
+		got := FindAttribute(attrs, c.name)
+		if got == nil {
+			t.Errorf("%s: node %s has no %s", label, want.Name, c.name)
+			continue
+		}
+		if !bytes.Equal(attributeBytes(got.Data), attributeBytes(c.want)) {
+			t.Errorf("%s: node %s decodes %s %v, want %v", label, want.Name, c.name, got.Data, c.want)
+		}
+	}
+}
+
+func TestReferenceArchives(t *testing.T) {
+	reference := readReference(t)
+	for _, encoding := range referenceEncodings {
+		want := reference[encoding]
+		dir := path.Join(referenceDirectory, encoding)
+		arch := NewArchive(dir)
+		if err := arch.Load(); err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		meta := arch.GetMetadata()
+		if meta.GetEncoding() != encoding || meta.Scale != [3]float64{0.001, 0.001, 0.001} || meta.offsetVector() != [3]float64{500000, 4000000, 0} {
+			t.Errorf("%s: encoding %s, scale %v, offset %v", encoding, meta.GetEncoding(), meta.Scale, meta.offsetVector())
+		}
+		for name, size := range map[string]int64{HierarchyName: want.HierarchySize, OctreeName: want.OctreeSize} {
+			if info, err := os.Stat(path.Join(dir, name)); err != nil || info.Size() != size {
+				t.Fatalf("%s: %s is not the generated file: %v", encoding, name, err)
+			}
+		}
+		if len(arch.GetNodes()) != len(want.Nodes) {
+			t.Fatalf("%s: %d nodes, want %d", encoding, len(arch.GetNodes()), len(want.Nodes))
+		}
+		for _, w := range want.Nodes {
+			n := arch.GetNode(w.Name)
+			if n == nil {
+				t.Fatalf("%s: node %s missing", encoding, w.Name)
+			}
+			if n.Type != w.Type || n.ChildMask != w.ChildMask || n.NumPoints != w.NumPoints || n.ByteOffset != w.ByteOffset || n.ByteSize != w.ByteSize {
+				t.Errorf("%s: node %s has record %+v, want %+v", encoding, w.Name, n.node, w)
+			}
+			checkReferencePoints(t, encoding, n.Attrs, w)
+			position := FindAttribute(n.Attrs, POSITION.Name)
+			for i := 0; i < position.Len(); i++ {
+				p := meta.WorldPosition(position, i)
+				if !n.Box.Contains(p, [3]float64{}) {
+					t.Fatalf("%s: node %s point %d at %v lies outside %v", encoding, w.Name, i, p, n.Box)
+				}
+			}
+		}
+
+		report, err := arch.Validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.OK() {
+			t.Errorf("%s: %s", encoding, report)
+		}
+	}
+}
+
+// TestReferenceRoundTrip writes the reference points with this package and
+// compares the decompressed payloads with the reference ones.
+func TestReferenceRoundTrip(t *testing.T) {
+	reference := readReference(t)
+	dir := makeTestDir(t)
+
+	for _, encoding := range referenceEncodings {
+		src := path.Join(referenceDirectory, encoding)
+		arch := NewArchive(src)
+		if err := arch.Load(); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range arch.GetNodes() {
+			n.Buffer = nil
+		}
+		dst := path.Join(dir, encoding)
+		saved := NewArchive(dst)
+		saved.SetMetadata(arch.GetMetadata())
+		saved.SetRoot(arch.GetRoot())
+		if err := saved.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded := NewArchive(dst)
+		if err := loaded.Load(); err != nil {
+			t.Fatal(err)
+		}
+		octree, err := ioutil.ReadFile(path.Join(src, OctreeName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		codec, compressed := nodeCodecs[encoding]
+		decode := func(data []byte) []byte {
+			if !compressed {
+				return data
+			}
+			ret, err := codec.decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ret
+		}
+		for _, w := range reference[encoding].Nodes {
+			n := loaded.GetNode(w.Name)
+			if n == nil || n.ChildMask != w.ChildMask || n.NumPoints != w.NumPoints {
+				t.Fatalf("%s: node %s differs after a round trip", encoding, w.Name)
+			}
+			checkReferencePoints(t, encoding+" round trip", n.Attrs, w)
+			payload, err := loaded.FetchNode(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decode(payload), decode(octree[w.ByteOffset:w.ByteOffset+w.ByteSize])) {
+				t.Errorf("%s: node %s is written differently from the reference", encoding, w.Name)
+			}
+		}
+	}
+}
+
+// converterBox is the bounding box PotreeConverter 2.x computed for the
+// sample, converterPoints are points of it decoded by hand from the raw file.
+var (
+	converterBox    = AABB{Min: [3]float64{2524150.48, 1197228.51, 1110.459951171875}, Max: [3]float64{2524831.1899999999, 1197878.96, 1276.3699511718751}}
+	converterPoints = []struct {
+		position       [3]float64
+		intensity      float64
+		classification float64
+		rgb            [3]float64
+		gpsTime        float64
+	}{
+		{[3]float64{2524224.399, 1197350.379, 1208.488951171875}, 26, 5, [3]float64{57856, 55808, 48640}, 224494455.34972262},
+		{[3]float64{2524321.149, 1197327.8, 1233.369951171875}, 20, 5, [3]float64{11520, 20480, 25344}, 224499600.16612887},
+		{[3]float64{2524738.689, 1197877.379, 1140.359951171875}, 88, 5, [3]float64{29184, 30464, 20992}, 224499375.74816012},
+	}
+)
+
+func millimetres(p [3]float64) [3]int64 {
+	return [3]int64{int64(math.Round(p[0] * 1000)), int64(math.Round(p[1] * 1000)), int64(math.Round(p[2] * 1000))}
+}
+
+// TestConverterReference converts the sample written by PotreeConverter and
+// checks the decoded points against values that don't come from this
+// package: the bounds PotreeConverter computed, hand decoded points and the
+// dip angles the sample carries next to its normals.
+func TestConverterReference(t *testing.T) {
+	dir := makeTestDir(t)
+
+	for _, encoding := range referenceEncodings {
+		outdir := path.Join(dir, encoding)
+		if _, err := Convert([]string{converterSample}, &Options{Outdir: outdir, Encoding: encoding}); err != nil {
+			t.Fatal(err)
+		}
+		arch := NewArchive(outdir)
+		if err := arch.Load(); err != nil {
+			t.Fatal(err)
+		}
+		meta := arch.GetMetadata()
+
+		known := make(map[[3]int64]int)
+		for i, p := range converterPoints {
+			known[millimetres(p.position)] = i
+		}
+		found := 0
+		count := 0
+		min := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+		max := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+		for _, n := range arch.GetNodes() {
+			position := FindAttribute(n.Attrs, POSITION.Name)
+			dip := FindAttribute(n.Attrs, "Dip (degrees)")
+			normalZ := FindAttribute(n.Attrs, "NormalZ")
+			for i := 0; i < position.Len(); i++ {
+				p := meta.WorldPosition(position, i)
+				for k := 0; k < 3; k++ {
+					min[k], max[k] = math.Min(min[k], p[k]), math.Max(max[k], p[k])
+				}
+				if d := math.Acos(math.Abs(normalZ.GetFloat64(i, 0)))*180/math.Pi - dip.GetFloat64(i, 0); math.Abs(d) > 1e-3 {
+					t.Fatalf("%s: node %s point %d: dip %f doesn't match its normal", encoding, n.Name, i, dip.GetFloat64(i, 0))
+				}
+				k, ok := known[millimetres(p)]
+				if !ok {
+					continue
+				}
+				found++
+				want := converterPoints[k]
+				rgb := FindAttribute(n.Attrs, COLOR.Name)
+				got := []float64{
+					FindAttribute(n.Attrs, INTENSITY.Name).GetFloat64(i, 0),
+					FindAttribute(n.Attrs, CLASSIFICATION.Name).GetFloat64(i, 0),
+					rgb.GetFloat64(i, 0), rgb.GetFloat64(i, 1), rgb.GetFloat64(i, 2),
+					FindAttribute(n.Attrs, "gps-time").GetFloat64(i, 0),
+				}
+				if fmt.Sprint(got) != fmt.Sprint([]float64{want.intensity, want.classification, want.rgb[0], want.rgb[1], want.rgb[2], want.gpsTime}) {
+					t.Errorf("%s: point at %v decodes to %v", encoding, want.position, got)
+				}
+			}
+			count += position.Len()
+		}
+		if count != 24666 || found != len(converterPoints) {
+			t.Errorf("%s: %d points, found %d of the known points", encoding, count, found)
+		}
+		// PotreeConverter truncates positions, so the maximum may lie a
+		// step below its bounds
+		for k := 0; k < 3; k++ {
+			if math.Abs(min[k]-converterBox.Min[k]) > meta.Scale[k]/2 || math.Abs(max[k]-converterBox.Max[k]) > 1.5*meta.Scale[k] {
+				t.Errorf("%s: points span %v %v, PotreeConverter computed %v", encoding, min, max, converterBox)
+			}
+		}
+	}
+}
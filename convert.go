@@ -0,0 +1,145 @@
+package potree
+
+import (
+	"errors"
+
+	vec3d "github.com/flywave/go3d/float64/vec3"
+)
+
+// Convert builds an archive in opts.Outdir from point cloud files in any
+// format supported by OpenPointReader. The schema is the union of the input
+// attributes and positions use the finest input scale unless opts.Precision
+// is set. With a MemoryBudget
+// the octree is built out of core, see convertChunked.
+func Convert(inputs []string, opts *Options) (*PotreeArchive, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("no input files")
+	}
+	if opts.MemoryBudget > 0 {
+		return convertChunked(inputs, opts)
+	}
+	readers, err := openReaders(inputs)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReaders(readers)
+
+	arch, err := buildArchive(readers, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := arch.Save(); err != nil {
+		return nil, err
+	}
+	return arch, nil
+}
+
+func openReaders(inputs []string) ([]PointReader, error) {
+	readers := make([]PointReader, 0, len(inputs))
+	for _, p := range inputs {
+		rd, err := OpenPointReader(p)
+		if err != nil {
+			closeReaders(readers)
+			return nil, err
+		}
+		readers = append(readers, rd)
+	}
+	return readers, nil
+}
+
+func closeReaders(readers []PointReader) {
+	for _, rd := range readers {
+		rd.Close()
+	}
+}
+
+// convertMetadata returns the metadata of an archive holding the points of
+// all readers. The CRS is the one of the options or else of the first input
+// that has one, the bounds of inputs in other CRSs are reprojected. The
+// scale is the finest of the inputs or follows opts.Precision, coarsened if
+// the bounds don't fit into int32.
+func convertMetadata(readers []PointReader, opts *Options) (*Metadata, error) {
+	meta := NewMetadata(nil)
+	if opts.CRS != "" {
+		crs, err := ParseCRS(opts.CRS)
+		if err != nil {
+			return nil, err
+		}
+		meta.SetCRS(crs)
+	}
+	for i, rd := range readers {
+		src := rd.GetMetadata()
+		for _, attr := range src.Attrs {
+			if meta.Get(attr.Name) == nil {
+				attr.Min, attr.Max = nil, nil
+				meta.Add(&attr)
+			}
+		}
+		if meta.Projection == nil {
+			meta.Projection = src.Projection
+		}
+		box, scale, err := reprojectBounds(src.BoundingBox, src.Scale, src, meta)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			meta.BoundingBox = box
+			meta.Scale = scale
+			continue
+		}
+		meta.BoundingBox = meta.BoundingBox.Union(box)
+		for k := 0; k < 3; k++ {
+			if scale[k] < meta.Scale[k] {
+				meta.Scale[k] = scale[k]
+			}
+		}
+	}
+	if opts.Precision > 0 {
+		step := 2 * opts.Precision
+		meta.Scale = [3]float64{step, step, step}
+	}
+	so := ComputeScaleOffset(vec3d.T(meta.BoundingBox.Min), vec3d.T(meta.BoundingBox.Max), vec3d.T(meta.Scale))
+	offset := so.offset
+	meta.Scale = so.scale
+	meta.Offset = &offset
+	meta.Name = opts.Name
+	if opts.Encoding != "" {
+		encoding := opts.Encoding
+		meta.Encoding = &encoding
+	}
+	return meta, nil
+}
+
+func buildArchive(readers []PointReader, opts *Options) (*PotreeArchive, error) {
+	meta, err := convertMetadata(readers, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := NewBuilder(meta)
+	for _, rd := range readers {
+		if err := builder.AddReader(rd); err != nil {
+			return nil, err
+		}
+	}
+	root, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	arch := NewArchive(opts.Outdir)
+	arch.SetOptions(opts)
+	arch.SetMetadata(builder.GetMetadata())
+	arch.SetRoot(root)
+	q := inputQuantization(arch.metadata, readerMetadata(readers))
+	arch.quantization = &q
+	return arch, nil
+}
+
+func readerMetadata(readers []PointReader) []*Metadata {
+	ret := make([]*Metadata, len(readers))
+	for i, rd := range readers {
+		ret[i] = rd.GetMetadata()
+	}
+	return ret
+}
@@ -0,0 +1,95 @@
+package potree
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+)
+
+var trailingCommas = regexp.MustCompile(`,(\s*[}\]])`)
+
+// CPotreeReader reads the single-file .potree containers written by
+// PotreeConverter's extract tools: a little endian uint32 header size, a
+// JSON header and the points stored attribute by attribute.
+type CPotreeReader struct {
+	file     *os.File
+	metadata *Metadata
+	base     int64
+	next     int64
+}
+
+func OpenCPotreeReader(p string) (*CPotreeReader, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	rd := &CPotreeReader{file: f}
+	if err := rd.readHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rd, nil
+}
+
+func (r *CPotreeReader) readHeader() error {
+	var headerSize uint32
+	if err := binary.Read(r.file, POTREE_BYTEORDER, &headerSize); err != nil {
+		return err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.file, header); err != nil {
+		return err
+	}
+	header = trailingCommas.ReplaceAll(header, []byte("$1"))
+
+	meta := &Metadata{}
+	if err := json.Unmarshal(header, meta); err != nil {
+		return err
+	}
+	if meta.Points == nil {
+		return errors.New("potree header has no point count")
+	}
+	if meta.Offset == nil {
+		offset := meta.BoundingBox.Min
+		meta.Offset = &offset
+	}
+	meta.Version = POTREE_VERSION
+
+	r.metadata = meta
+	r.base = int64(4 + headerSize)
+	return nil
+}
+
+func (r *CPotreeReader) GetMetadata() *Metadata {
+	return r.metadata
+}
+
+func (r *CPotreeReader) ReadPoints(max int) ([]Attribute, error) {
+	total := *r.metadata.Points
+	if r.next >= total {
+		return nil, io.EOF
+	}
+	count := total - r.next
+	if count > int64(max) {
+		count = int64(max)
+	}
+
+	ret := NewPoints(r.metadata.Attrs, int(count))
+	offset := r.base
+	for i := range ret {
+		buf := attributeBytes(ret[i].Data)
+		if _, err := r.file.ReadAt(buf, offset+r.next*int64(ret[i].Size)); err != nil {
+			return nil, err
+		}
+		offset += total * int64(ret[i].Size)
+	}
+	r.next += count
+	return ret, nil
+}
+
+func (r *CPotreeReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,644 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type CRSType uint8
+
+const (
+	CRS_UNKNOWN    CRSType = 0
+	CRS_GEOGRAPHIC CRSType = 1
+	CRS_PROJECTED  CRSType = 2
+	CRS_GEOCENTRIC CRSType = 3
+)
+
+// Projection methods, named like their PROJ counterparts.
+const (
+	PROJ_LONGLAT = "longlat"
+	PROJ_GEOCENT = "geocent"
+	PROJ_TMERC   = "tmerc"
+	PROJ_MERC    = "merc"
+	PROJ_WEBMERC = "webmerc"
+	PROJ_SOMERC  = "somerc"
+)
+
+const (
+	UNIT_METRE  = 1.0
+	UNIT_FOOT   = 0.3048
+	UNIT_USFOOT = 0.3048006096012192
+	UNIT_DEGREE = math.Pi / 180
+)
+
+// Axis directions as used in WKT.
+const (
+	AXIS_EAST        = "east"
+	AXIS_NORTH       = "north"
+	AXIS_UP          = "up"
+	AXIS_GEOCENTRICX = "geocentricX"
+	AXIS_GEOCENTRICY = "geocentricY"
+	AXIS_GEOCENTRICZ = "geocentricZ"
+)
+
+// Ellipsoid is given by its semi-major axis in metres and inverse
+// flattening, InvF is 0 for a sphere.
+type Ellipsoid struct {
+	Name string
+	A    float64
+	InvF float64
+}
+
+var (
+	WGS84_ELLIPSOID    = Ellipsoid{Name: "WGS 84", A: 6378137, InvF: 298.257223563}
+	GRS80_ELLIPSOID    = Ellipsoid{Name: "GRS 1980", A: 6378137, InvF: 298.257222101}
+	BESSEL_ELLIPSOID   = Ellipsoid{Name: "Bessel 1841", A: 6377397.155, InvF: 299.1528128}
+	AIRY_ELLIPSOID     = Ellipsoid{Name: "Airy 1830", A: 6377563.396, InvF: 299.3249646}
+	INTL_ELLIPSOID     = Ellipsoid{Name: "International 1924", A: 6378388, InvF: 297}
+	CLARKE66_ELLIPSOID = Ellipsoid{Name: "Clarke 1866", A: 6378206.4, InvF: 294.978698213898}
+)
+
+var projEllipsoids = map[string]Ellipsoid{
+	"WGS84":  WGS84_ELLIPSOID,
+	"GRS80":  GRS80_ELLIPSOID,
+	"bessel": BESSEL_ELLIPSOID,
+	"airy":   AIRY_ELLIPSOID,
+	"intl":   INTL_ELLIPSOID,
+	"clrk66": CLARKE66_ELLIPSOID,
+}
+
+// Datum is a geodetic datum. ToWGS84 holds the position vector Helmert
+// parameters to WGS 84: translations in metres, rotations in arc seconds and
+// the scale difference in ppm, it is nil if the shift is unknown.
+type Datum struct {
+	Name      string
+	Ellipsoid Ellipsoid
+	ToWGS84   []float64
+}
+
+var projDatums = map[string]Datum{
+	"WGS84":   {Name: "WGS_1984", Ellipsoid: WGS84_ELLIPSOID, ToWGS84: make([]float64, 7)},
+	"NAD83":   {Name: "North_American_Datum_1983", Ellipsoid: GRS80_ELLIPSOID, ToWGS84: make([]float64, 7)},
+	"NAD27":   {Name: "North_American_Datum_1927", Ellipsoid: CLARKE66_ELLIPSOID, ToWGS84: []float64{-8, 160, 176, 0, 0, 0, 0}},
+	"OSGB36":  {Name: "OSGB_1936", Ellipsoid: AIRY_ELLIPSOID, ToWGS84: []float64{446.448, -125.157, 542.06, 0.15, 0.247, 0.842, -20.489}},
+	"potsdam": {Name: "Deutsches_Hauptdreiecksnetz", Ellipsoid: BESSEL_ELLIPSOID, ToWGS84: []float64{598.1, 73.7, 418.2, 0.202, 0.045, -2.455, 6.7}},
+}
+
+// VerticalCRS describes the heights of a compound CRS. UnitFactor converts
+// its unit to metres.
+type VerticalCRS struct {
+	Name       string
+	EPSG       int
+	Datum      string
+	Unit       string
+	UnitFactor float64
+}
+
+// CRS is a horizontal coordinate reference system with an optional vertical
+// one. UnitFactor converts the unit of the coordinates to metres, or to
+// radians for geographic systems. Axes lists the axis directions in the
+// order coordinates are given. Projection parameters are in degrees and
+// metres whatever the unit of the CRS.
+type CRS struct {
+	Name          string
+	EPSG          int
+	Type          CRSType
+	Method        string
+	Datum         Datum
+	Lat0          float64
+	Lon0          float64
+	LatTS         float64
+	K0            float64
+	FalseEasting  float64
+	FalseNorthing float64
+	Unit          string
+	UnitFactor    float64
+	Axes          []string
+	Vertical      *VerticalCRS
+}
+
+// ParseCRS reads a CRS given as WKT1, WKT2, a PROJ string or an EPSG code
+// such as EPSG:2056 or EPSG:2056+5728 with a vertical CRS.
+func ParseCRS(s string) (*CRS, error) {
+	s = strings.TrimSpace(s)
+	upper := strings.ToUpper(s)
+	switch {
+	case s == "":
+		return nil, errors.New("empty CRS")
+	case strings.ContainsAny(s, "[("):
+	case strings.HasPrefix(s, "+") || strings.Contains(s, "+proj=") || strings.Contains(s, "+init="):
+		return crsFromPROJ(s)
+	case strings.HasPrefix(upper, "EPSG:"):
+		return crsFromEPSGCodes(s[5:])
+	case strings.HasPrefix(upper, "URN:OGC:DEF:CRS:EPSG:"):
+		return crsFromEPSGCodes(s[strings.LastIndex(s, ":")+1:])
+	case strings.HasPrefix(upper, "HTTP://WWW.OPENGIS.NET/DEF/CRS/EPSG/"):
+		return crsFromEPSGCodes(s[strings.LastIndex(s, "/")+1:])
+	case strings.Trim(s, "0123456789+") == "":
+		return crsFromEPSGCodes(s)
+	}
+	n, err := parseWKT(s)
+	if err != nil {
+		return nil, err
+	}
+	return crsFromWKT(n)
+}
+
+func crsFromEPSGCodes(s string) (*CRS, error) {
+	parts := strings.Split(s, "+")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid EPSG code %s", s)
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EPSG code %s", s)
+	}
+	ret, err := NewCRSFromEPSG(code)
+	if err != nil || len(parts) == 1 {
+		return ret, err
+	}
+	vcode, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EPSG code %s", s)
+	}
+	v, ok := epsgVertical[vcode]
+	if !ok {
+		return nil, &ErrUnsupportedCRS{EPSG: vcode, Vertical: true}
+	}
+	v.EPSG = vcode
+	ret.Vertical = &v
+	return ret, nil
+}
+
+// GetUnit returns the name and factor to metres or radians of the
+// horizontal unit.
+func (c *CRS) GetUnit() (string, float64) {
+	return c.Unit, c.UnitFactor
+}
+
+// GetVerticalUnit returns the name and factor to metres of the height unit,
+// heights of a CRS without vertical CRS are in metres unless the CRS is
+// projected in other units.
+func (c *CRS) GetVerticalUnit() (string, float64) {
+	if c.Vertical != nil {
+		return c.Vertical.Unit, c.Vertical.UnitFactor
+	}
+	if c.Type == CRS_PROJECTED || c.Type == CRS_GEOCENTRIC {
+		return c.Unit, c.UnitFactor
+	}
+	return "metre", UNIT_METRE
+}
+
+// IsLatLon reports whether the first axis is the latitude, as for
+// EPSG:4326.
+func (c *CRS) IsLatLon() bool {
+	return c.Type == CRS_GEOGRAPHIC && len(c.Axes) > 0 && c.Axes[0] == AXIS_NORTH
+}
+
+// String returns the EPSG code when known, the PROJ string otherwise.
+func (c *CRS) String() string {
+	if c.EPSG == 0 {
+		return c.PROJ()
+	}
+	if c.Vertical != nil && c.Vertical.EPSG != 0 {
+		return fmt.Sprintf("EPSG:%d+%d", c.EPSG, c.Vertical.EPSG)
+	}
+	return fmt.Sprintf("EPSG:%d", c.EPSG)
+}
+
+// Equal reports whether c and o describe the same coordinates, names are
+// ignored.
+func (c *CRS) Equal(o *CRS) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	if (c.Vertical == nil) != (o.Vertical == nil) {
+		return false
+	}
+	if c.Vertical != nil && (c.Vertical.EPSG != o.Vertical.EPSG || !sameFloat(c.Vertical.UnitFactor, o.Vertical.UnitFactor)) {
+		return false
+	}
+	if c.EPSG != 0 && o.EPSG != 0 {
+		return c.EPSG == o.EPSG
+	}
+	if c.Type != o.Type || c.Method != o.Method || !sameFloat(c.UnitFactor, o.UnitFactor) {
+		return false
+	}
+	if !sameFloat(c.Datum.Ellipsoid.A, o.Datum.Ellipsoid.A) || !sameFloat(c.Datum.Ellipsoid.InvF, o.Datum.Ellipsoid.InvF) {
+		return false
+	}
+	if !sameHelmert(c.Datum.ToWGS84, o.Datum.ToWGS84) {
+		return false
+	}
+	a := []float64{c.Lat0, c.Lon0, c.LatTS, c.K0, c.FalseEasting, c.FalseNorthing}
+	b := []float64{o.Lat0, o.Lon0, o.LatTS, o.K0, o.FalseEasting, o.FalseNorthing}
+	for i := range a {
+		if !sameFloat(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFloat(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+func wktNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func wktAuthority(code int) string {
+	if code == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`,AUTHORITY["EPSG","%d"]`, code)
+}
+
+func wktAxisName(dir string) (string, string) {
+	switch dir {
+	case AXIS_EAST:
+		return "Easting", "EAST"
+	case AXIS_NORTH:
+		return "Northing", "NORTH"
+	case AXIS_UP:
+		return "Up", "UP"
+	case AXIS_GEOCENTRICX:
+		return "Geocentric X", "OTHER"
+	case AXIS_GEOCENTRICY:
+		return "Geocentric Y", "EAST"
+	case AXIS_GEOCENTRICZ:
+		return "Geocentric Z", "NORTH"
+	}
+	return dir, strings.ToUpper(dir)
+}
+
+func (c *CRS) axesWKT() string {
+	var buf strings.Builder
+	for _, dir := range c.Axes {
+		name, wdir := wktAxisName(dir)
+		if c.Type == CRS_GEOGRAPHIC && dir == AXIS_NORTH {
+			name = "Latitude"
+		} else if c.Type == CRS_GEOGRAPHIC && dir == AXIS_EAST {
+			name = "Longitude"
+		}
+		fmt.Fprintf(&buf, `,AXIS["%s",%s]`, name, wdir)
+	}
+	return buf.String()
+}
+
+func (d *Datum) wkt() string {
+	name := d.Name
+	if name == "" {
+		name = "unknown"
+	}
+	ret := fmt.Sprintf(`DATUM["%s",SPHEROID["%s",%s,%s]`, name, d.Ellipsoid.Name, wktNumber(d.Ellipsoid.A), wktNumber(d.Ellipsoid.InvF))
+	if d.ToWGS84 != nil {
+		parts := make([]string, len(d.ToWGS84))
+		for i, v := range d.ToWGS84 {
+			parts[i] = wktNumber(v)
+		}
+		ret += ",TOWGS84[" + strings.Join(parts, ",") + "]"
+	}
+	return ret + "]"
+}
+
+func (c *CRS) geogcsWKT(name string, axes string, code int) string {
+	return fmt.Sprintf(`GEOGCS["%s",%s,PRIMEM["Greenwich",0],UNIT["degree",%s]%s%s]`, name, c.Datum.wkt(), wktNumber(UNIT_DEGREE), axes, wktAuthority(code))
+}
+
+func (v *VerticalCRS) wkt() string {
+	return fmt.Sprintf(`VERT_CS["%s",VERT_DATUM["%s",2005],UNIT["%s",%s],AXIS["Gravity-related height",UP]%s]`, v.Name, v.Datum, v.Unit, wktNumber(v.UnitFactor), wktAuthority(v.EPSG))
+}
+
+// WKT returns the CRS as OGC WKT1 as stored in LAS files, a vertical CRS
+// makes it a COMPD_CS.
+func (c *CRS) WKT() string {
+	h := c.horizontalWKT()
+	if c.Vertical == nil {
+		return h
+	}
+	return fmt.Sprintf(`COMPD_CS["%s + %s",%s,%s]`, c.Name, c.Vertical.Name, h, c.Vertical.wkt())
+}
+
+func (c *CRS) horizontalWKT() string {
+	switch c.Type {
+	case CRS_GEOGRAPHIC:
+		return c.geogcsWKT(c.Name, c.axesWKT(), c.EPSG)
+	case CRS_GEOCENTRIC:
+		return fmt.Sprintf(`GEOCCS["%s",%s,PRIMEM["Greenwich",0],UNIT["%s",%s]%s%s]`, c.Name, c.Datum.wkt(), c.Unit, wktNumber(c.UnitFactor), c.axesWKT(), wktAuthority(c.EPSG))
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `PROJCS["%s",%s`, c.Name, c.geogcsWKT(c.Datum.Name, "", 0))
+	param := func(name string, v float64) {
+		fmt.Fprintf(&buf, `,PARAMETER["%s",%s]`, name, wktNumber(v))
+	}
+	fe, fn := c.FalseEasting/c.UnitFactor, c.FalseNorthing/c.UnitFactor
+	switch c.Method {
+	case PROJ_TMERC:
+		buf.WriteString(`,PROJECTION["Transverse_Mercator"]`)
+		param("latitude_of_origin", c.Lat0)
+		param("central_meridian", c.Lon0)
+		param("scale_factor", c.K0)
+	case PROJ_MERC:
+		if c.LatTS != 0 {
+			buf.WriteString(`,PROJECTION["Mercator_2SP"]`)
+			param("standard_parallel_1", c.LatTS)
+			param("central_meridian", c.Lon0)
+		} else {
+			buf.WriteString(`,PROJECTION["Mercator_1SP"]`)
+			param("central_meridian", c.Lon0)
+			param("scale_factor", c.K0)
+		}
+	case PROJ_WEBMERC:
+		buf.WriteString(`,PROJECTION["Mercator_1SP"]`)
+		param("central_meridian", c.Lon0)
+		param("scale_factor", 1)
+	case PROJ_SOMERC:
+		buf.WriteString(`,PROJECTION["Hotine_Oblique_Mercator_Azimuth_Center"]`)
+		param("latitude_of_center", c.Lat0)
+		param("longitude_of_center", c.Lon0)
+		param("azimuth", 90)
+		param("rectified_grid_angle", 90)
+		param("scale_factor", c.K0)
+	}
+	param("false_easting", fe)
+	param("false_northing", fn)
+	fmt.Fprintf(&buf, `,UNIT["%s",%s]%s`, c.Unit, wktNumber(c.UnitFactor), c.axesWKT())
+	if c.Method == PROJ_WEBMERC {
+		buf.WriteString(`,EXTENSION["PROJ4","+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +nadgrids=@null +wktext +no_defs"]`)
+	}
+	buf.WriteString(wktAuthority(c.EPSG))
+	buf.WriteString("]")
+	return buf.String()
+}
+
+// PROJ returns the CRS as a PROJ string.
+func (c *CRS) PROJ() string {
+	var parts []string
+	add := func(format string, args ...interface{}) {
+		parts = append(parts, fmt.Sprintf(format, args...))
+	}
+	switch c.Method {
+	case PROJ_WEBMERC:
+		add("+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +nadgrids=@null +wktext")
+	default:
+		switch c.Type {
+		case CRS_GEOGRAPHIC:
+			add("+proj=longlat")
+		case CRS_GEOCENTRIC:
+			add("+proj=geocent")
+		default:
+			add("+proj=%s", c.Method)
+			switch c.Method {
+			case PROJ_MERC:
+				if c.LatTS != 0 {
+					add("+lat_ts=%s", wktNumber(c.LatTS))
+				}
+				add("+lon_0=%s", wktNumber(c.Lon0))
+				if c.LatTS == 0 {
+					add("+k=%s", wktNumber(c.K0))
+				}
+			default:
+				add("+lat_0=%s +lon_0=%s +k=%s", wktNumber(c.Lat0), wktNumber(c.Lon0), wktNumber(c.K0))
+			}
+			add("+x_0=%s +y_0=%s", wktNumber(c.FalseEasting), wktNumber(c.FalseNorthing))
+		}
+		c.projDatum(add)
+		if c.Type != CRS_GEOGRAPHIC {
+			add("+units=%s", projUnit(c.UnitFactor))
+		}
+	}
+	if c.Vertical != nil {
+		add("+vunits=%s", projUnit(c.Vertical.UnitFactor))
+	}
+	add("+no_defs")
+	return strings.Join(parts, " ")
+}
+
+func (c *CRS) projDatum(add func(string, ...interface{})) {
+	for name, d := range projDatums {
+		if d.Ellipsoid == c.Datum.Ellipsoid && sameHelmert(d.ToWGS84, c.Datum.ToWGS84) && d.Name == c.Datum.Name {
+			add("+datum=%s", name)
+			return
+		}
+	}
+	ellps := ""
+	for name, e := range projEllipsoids {
+		if sameFloat(e.A, c.Datum.Ellipsoid.A) && sameFloat(e.InvF, c.Datum.Ellipsoid.InvF) {
+			ellps = name
+		}
+	}
+	switch {
+	case ellps != "":
+		add("+ellps=%s", ellps)
+	case c.Datum.Ellipsoid.InvF == 0:
+		add("+R=%s", wktNumber(c.Datum.Ellipsoid.A))
+	default:
+		add("+a=%s +rf=%s", wktNumber(c.Datum.Ellipsoid.A), wktNumber(c.Datum.Ellipsoid.InvF))
+	}
+	if c.Datum.ToWGS84 != nil {
+		vals := make([]string, len(c.Datum.ToWGS84))
+		for i, v := range c.Datum.ToWGS84 {
+			vals[i] = wktNumber(v)
+		}
+		add("+towgs84=%s", strings.Join(vals, ","))
+	}
+}
+
+func sameHelmert(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameFloat(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func projUnit(factor float64) string {
+	switch {
+	case sameFloat(factor, UNIT_METRE):
+		return "m"
+	case sameFloat(factor, UNIT_FOOT):
+		return "ft"
+	case sameFloat(factor, UNIT_USFOOT):
+		return "us-ft"
+	}
+	return "m +to_meter=" + wktNumber(factor)
+}
+
+func crsFromPROJ(s string) (*CRS, error) {
+	params := make(map[string]string)
+	for _, f := range strings.Fields(s) {
+		f = strings.TrimPrefix(f, "+")
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		} else {
+			params[kv[0]] = ""
+		}
+	}
+	if init, ok := params["init"]; ok {
+		if !strings.HasPrefix(strings.ToLower(init), "epsg:") {
+			return nil, fmt.Errorf("unsupported PROJ init %s", init)
+		}
+		return crsFromEPSGCodes(init[5:])
+	}
+	number := func(name string, def float64) (float64, error) {
+		v, ok := params[name]
+		if !ok {
+			return def, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid PROJ parameter +%s=%s", name, v)
+		}
+		return f, nil
+	}
+
+	ret := &CRS{Unit: "metre", UnitFactor: UNIT_METRE, K0: 1, Axes: []string{AXIS_EAST, AXIS_NORTH}}
+	if name, ok := params["datum"]; ok {
+		d, ok := projDatums[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported PROJ datum %s", name)
+		}
+		ret.Datum = d
+	} else {
+		ret.Datum.Ellipsoid = WGS84_ELLIPSOID
+		if name, ok := params["ellps"]; ok {
+			e, ok := projEllipsoids[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported PROJ ellipsoid %s", name)
+			}
+			ret.Datum.Ellipsoid = e
+		}
+		if _, ok := params["a"]; ok {
+			a, err := number("a", 0)
+			if err != nil {
+				return nil, err
+			}
+			ret.Datum.Ellipsoid = Ellipsoid{Name: "unknown", A: a}
+			if rf, err := number("rf", 0); err != nil {
+				return nil, err
+			} else if rf != 0 {
+				ret.Datum.Ellipsoid.InvF = rf
+			} else if b, err := number("b", a); err != nil {
+				return nil, err
+			} else if b != a {
+				ret.Datum.Ellipsoid.InvF = a / (a - b)
+			}
+		}
+		if _, ok := params["R"]; ok {
+			r, err := number("R", 0)
+			if err != nil {
+				return nil, err
+			}
+			ret.Datum.Ellipsoid = Ellipsoid{Name: "sphere", A: r}
+		}
+		ret.Datum.Name = ret.Datum.Ellipsoid.Name
+	}
+	if v, ok := params["towgs84"]; ok {
+		ret.Datum.ToWGS84 = make([]float64, 7)
+		for i, p := range strings.Split(v, ",") {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil || i >= 7 {
+				return nil, fmt.Errorf("invalid PROJ parameter +towgs84=%s", v)
+			}
+			ret.Datum.ToWGS84[i] = f
+		}
+	}
+	if ret.Datum.ToWGS84 == nil && (ret.Datum.Ellipsoid == WGS84_ELLIPSOID || ret.Datum.Ellipsoid == GRS80_ELLIPSOID) {
+		ret.Datum.ToWGS84 = make([]float64, 7)
+	}
+	if u, ok := params["units"]; ok {
+		f, ok := map[string]float64{"m": UNIT_METRE, "km": 1000, "ft": UNIT_FOOT, "us-ft": UNIT_USFOOT}[u]
+		if !ok {
+			return nil, fmt.Errorf("unsupported PROJ unit %s", u)
+		}
+		ret.UnitFactor = f
+		ret.Unit = map[string]string{"m": "metre", "km": "kilometre", "ft": "foot", "us-ft": "US survey foot"}[u]
+	}
+	if _, ok := params["to_meter"]; ok {
+		f, err := number("to_meter", 1)
+		if err != nil {
+			return nil, err
+		}
+		ret.Unit, ret.UnitFactor = "unknown", f
+	}
+
+	var err error
+	get := func(dst *float64, def float64, names ...string) {
+		*dst = def
+		for _, name := range names {
+			if _, ok := params[name]; ok && err == nil {
+				*dst, err = number(name, def)
+			}
+		}
+	}
+	ret.Method = params["proj"]
+	switch ret.Method {
+	case "longlat", "latlong", "lonlat", "latlon":
+		ret.Type, ret.Method = CRS_GEOGRAPHIC, PROJ_LONGLAT
+		ret.Unit, ret.UnitFactor = "degree", UNIT_DEGREE
+	case "geocent", "cart":
+		ret.Type, ret.Method = CRS_GEOCENTRIC, PROJ_GEOCENT
+		ret.Axes = []string{AXIS_GEOCENTRICX, AXIS_GEOCENTRICY, AXIS_GEOCENTRICZ}
+	case "utm":
+		zone, zerr := strconv.Atoi(params["zone"])
+		if zerr != nil || zone < 1 || zone > 60 {
+			return nil, fmt.Errorf("invalid UTM zone %s", params["zone"])
+		}
+		ret.Type, ret.Method = CRS_PROJECTED, PROJ_TMERC
+		ret.Lon0, ret.K0, ret.FalseEasting = float64(zone*6-183), 0.9996, 500000
+		if _, ok := params["south"]; ok {
+			ret.FalseNorthing = 10000000
+		}
+	case "tmerc", "etmerc", "somerc":
+		if ret.Method == "etmerc" {
+			ret.Method = PROJ_TMERC
+		}
+		ret.Type = CRS_PROJECTED
+		get(&ret.Lat0, 0, "lat_0")
+		get(&ret.Lon0, 0, "lon_0")
+		get(&ret.K0, 1, "k", "k_0")
+		get(&ret.FalseEasting, 0, "x_0")
+		get(&ret.FalseNorthing, 0, "y_0")
+	case "merc", "webmerc":
+		ret.Type = CRS_PROJECTED
+		_, null := params["nadgrids"]
+		if ret.Method == "webmerc" || (null && params["nadgrids"] == "@null" && ret.Datum.Ellipsoid.A == 6378137 && ret.Datum.Ellipsoid.InvF == 0) {
+			ret.Method = PROJ_WEBMERC
+			ret.Datum = projDatums["WGS84"]
+			ret.Name = "WGS 84 / Pseudo-Mercator"
+			break
+		}
+		get(&ret.LatTS, 0, "lat_ts")
+		get(&ret.Lon0, 0, "lon_0")
+		get(&ret.K0, 1, "k", "k_0")
+		get(&ret.FalseEasting, 0, "x_0")
+		get(&ret.FalseNorthing, 0, "y_0")
+	default:
+		return nil, fmt.Errorf("unsupported PROJ projection %s", params["proj"])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret.Name == "" {
+		ret.Name = "unknown"
+	}
+	if u, ok := params["vunits"]; ok {
+		f, ok := map[string]float64{"m": UNIT_METRE, "ft": UNIT_FOOT, "us-ft": UNIT_USFOOT}[u]
+		if !ok {
+			return nil, fmt.Errorf("unsupported PROJ unit %s", u)
+		}
+		ret.Vertical = &VerticalCRS{Name: "unknown", Datum: "unknown", Unit: map[string]string{"m": "metre", "ft": "foot", "us-ft": "US survey foot"}[u], UnitFactor: f}
+	}
+	return ret, nil
+}
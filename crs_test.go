@@ -0,0 +1,156 @@
+package potree
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+const wkt2UTM32N = `PROJCRS["WGS 84 / UTM zone 32N",
+    BASEGEOGCRS["WGS 84",
+        ENSEMBLE["World Geodetic System 1984 ensemble",
+            MEMBER["World Geodetic System 1984 (G2139)"],
+            ELLIPSOID["WGS 84",6378137,298.257223563,LENGTHUNIT["metre",1]],
+            ENSEMBLEACCURACY[2.0]],
+        PRIMEM["Greenwich",0,ANGLEUNIT["degree",0.0174532925199433]],
+        ID["EPSG",4326]],
+    CONVERSION["UTM zone 32N",
+        METHOD["Transverse Mercator",ID["EPSG",9807]],
+        PARAMETER["Latitude of natural origin",0,ANGLEUNIT["degree",0.0174532925199433]],
+        PARAMETER["Longitude of natural origin",9,ANGLEUNIT["degree",0.0174532925199433]],
+        PARAMETER["Scale factor at natural origin",0.9996,SCALEUNIT["unity",1]],
+        PARAMETER["False easting",500000,LENGTHUNIT["metre",1]],
+        PARAMETER["False northing",0,LENGTHUNIT["metre",1]]],
+    CS[Cartesian,2],
+        AXIS["(E)",east,ORDER[1],LENGTHUNIT["metre",1]],
+        AXIS["(N)",north,ORDER[2],LENGTHUNIT["metre",1]],
+    ID["EPSG",32632]]`
+
+const wkt1LV95 = `PROJCS["CH1903+ / LV95",GEOGCS["CH1903+",DATUM["CH1903+",SPHEROID["Bessel 1841",6377397.155,299.1528128]],` +
+	`PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],PROJECTION["Hotine_Oblique_Mercator_Azimuth_Center"],` +
+	`PARAMETER["latitude_of_center",46.9524055555556],PARAMETER["longitude_of_center",7.43958333333333],` +
+	`PARAMETER["azimuth",90],PARAMETER["rectified_grid_angle",90],PARAMETER["scale_factor",1],` +
+	`PARAMETER["false_easting",2600000],PARAMETER["false_northing",1200000],UNIT["metre",1],` +
+	`AXIS["Easting",EAST],AXIS["Northing",NORTH],AUTHORITY["EPSG","2056"]]`
+
+func TestParseCRS(t *testing.T) {
+	utm, err := ParseCRS(wkt2UTM32N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utm.EPSG != 32632 || utm.Method != PROJ_TMERC || utm.Lon0 != 9 || utm.K0 != 0.9996 || utm.FalseEasting != 500000 {
+		t.Fatalf("unexpected UTM CRS %+v", utm)
+	}
+	for _, s := range []string{"EPSG:32632", "+proj=utm +zone=32 +datum=WGS84 +units=m +no_defs", utm.WKT(), utm.PROJ()} {
+		other, err := ParseCRS(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		other.EPSG = 0
+		if !utm.Equal(other) {
+			t.Errorf("%s: %+v differs from %+v", s, other, utm)
+		}
+	}
+
+	lv95, err := ParseCRS(wkt1LV95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv95.Method != PROJ_SOMERC || len(lv95.Datum.ToWGS84) != 7 || lv95.Datum.ToWGS84[0] != 674.374 {
+		t.Fatalf("unexpected LV95 CRS %+v", lv95)
+	}
+	compound, err := ParseCRS("EPSG:2056+5728")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compound.Vertical == nil || compound.Vertical.Name != "LN02 height" {
+		t.Fatalf("unexpected vertical CRS %+v", compound.Vertical)
+	}
+	again, err := ParseCRS(compound.WKT())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Equal(compound) || again.String() != "EPSG:2056+5728" {
+		t.Errorf("compound CRS changed to %s", again)
+	}
+
+	wgs84, err := ParseCRS("urn:ogc:def:crs:EPSG::4326")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wgs84.IsLatLon() || wgs84.Unit != "degree" {
+		t.Errorf("EPSG:4326 axes %v in %s", wgs84.Axes, wgs84.Unit)
+	}
+	ecef, err := ParseCRS("EPSG:4978")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := ParseCRS(ecef.WKT()); err != nil || parsed.Type != CRS_GEOCENTRIC {
+		t.Errorf("geocentric WKT parsed as %+v, %v", parsed, err)
+	}
+	webmerc, err := ParseCRS("EPSG:3857")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{webmerc.WKT(), webmerc.PROJ()} {
+		if parsed, err := ParseCRS(s); err != nil || parsed.Method != PROJ_WEBMERC {
+			t.Errorf("%s parsed as %+v, %v", s, parsed, err)
+		}
+	}
+
+	for _, s := range []string{"", "EPSG:1", "PROJCS[\"x\"", "+proj=lcc"} {
+		if _, err := ParseCRS(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+	for _, s := range []string{"EPSG:2154", "EPSG:32661", "EPSG:2056+5773000"} {
+		var unsupported *ErrUnsupportedCRS
+		if _, err := ParseCRS(s); !errors.As(err, &unsupported) {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+}
+
+func TestLASProjection(t *testing.T) {
+	dir := makeTestDir(t)
+
+	crs, err := ParseCRS("EPSG:2056+5728")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := NewMetadata([]Attribute{POSITION, INTENSITY})
+	meta.Scale = [3]float64{0.01, 0.01, 0.01}
+	meta.Offset = &[3]float64{2600000, 1200000, 400}
+	meta.SetCRS(crs)
+	points := NewPoints(meta.Attrs, 10)
+	buf := &bytes.Buffer{}
+	if err := WriteLAS(buf, meta, points); err != nil {
+		t.Fatal(err)
+	}
+	p := path.Join(dir, "points.las")
+	if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := OpenLASReader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	read, err := rd.GetMetadata().GetCRS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(crs) {
+		t.Errorf("LAS file has CRS %s", read)
+	}
+	// metadata.json and the WKT record carry the same text
+	if projection := rd.GetMetadata().Projection; projection == nil || *projection != *meta.Projection {
+		t.Errorf("LAS WKT %v differs from the metadata projection %s", projection, *meta.Projection)
+	}
+	if _, err := rd.ReadPoints(100); err != nil {
+		t.Fatal(err)
+	}
+}
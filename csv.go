@@ -0,0 +1,296 @@
+package potree
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var csvColumns = map[string]struct {
+	attr    *Attribute
+	element int
+}{
+	"intensity":      {&INTENSITY, 0},
+	"classification": {&CLASSIFICATION, 0},
+	"r":              {&COLOR, 0},
+	"g":              {&COLOR, 1},
+	"b":              {&COLOR, 2},
+	"red":            {&COLOR, 0},
+	"green":          {&COLOR, 1},
+	"blue":           {&COLOR, 2},
+	"gps-time":       {&GPS_TIME, 0},
+	"gps_time":       {&GPS_TIME, 0},
+	"nx":             {&NORMAL, 0},
+	"ny":             {&NORMAL, 1},
+	"nz":             {&NORMAL, 2},
+}
+
+func splitCSVLine(line string) []string {
+	for _, sep := range []string{",", ";"} {
+		if strings.Contains(line, sep) {
+			fields := strings.Split(line, sep)
+			for i := range fields {
+				fields[i] = strings.TrimSpace(fields[i])
+			}
+			return fields
+		}
+	}
+	return strings.Fields(line)
+}
+
+// CSVReader reads delimited text files with one point per line. A header
+// line naming the columns is optional, without it the columns are x, y, z
+// and optionally intensity. The whole file is read on open to determine the
+// bounds, positions are quantized with DefaultCSVScale relative to the
+// minimum.
+type CSVReader struct {
+	metadata *Metadata
+	points   []Attribute
+	next     int
+}
+
+var DefaultCSVScale = [3]float64{0.001, 0.001, 0.001}
+
+func OpenCSVReader(p string) (*CSVReader, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCSV(f)
+}
+
+func ReadCSV(r io.Reader) (*CSVReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	type column struct {
+		attr    int
+		element int
+	}
+	var (
+		columns []column
+		attrs   []Attribute
+		xyz     [][3]float64
+		values  [][]float64
+	)
+	setup := func(names []string) {
+		attrs = []Attribute{POSITION}
+		columns = make([]column, len(names))
+		for i, name := range names {
+			name = strings.ToLower(strings.TrimSpace(name))
+			switch name {
+			case "x", "y", "z":
+				columns[i] = column{0, int(name[0] - 'x')}
+				continue
+			}
+			c, ok := csvColumns[name]
+			if !ok {
+				columns[i] = column{-1, 0}
+				continue
+			}
+			idx := -1
+			for k := range attrs {
+				if attrs[k].Name == c.attr.Name {
+					idx = k
+				}
+			}
+			if idx < 0 {
+				idx = len(attrs)
+				attrs = append(attrs, *c.attr)
+			}
+			columns[i] = column{idx, c.element}
+		}
+	}
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "//") {
+			continue
+		}
+		fields := splitCSVLine(text)
+		if columns == nil {
+			if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+				setup(fields)
+				continue
+			}
+			names := []string{"x", "y", "z", "intensity"}
+			if len(fields) < 4 {
+				names = names[:3]
+			}
+			setup(names)
+		}
+		if len(fields) < len(columns) {
+			return nil, fmt.Errorf("line %d: expected %d columns, got %d", line, len(columns), len(fields))
+		}
+		var p [3]float64
+		row := make([]float64, 0, len(columns))
+		for i, c := range columns {
+			if c.attr < 0 {
+				row = append(row, 0)
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			if c.attr == 0 {
+				p[c.element] = v
+			}
+			row = append(row, v)
+		}
+		xyz = append(xyz, p)
+		values = append(values, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if columns == nil {
+		return nil, errors.New("csv file contains no points")
+	}
+
+	box := AABB{Min: [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, Max: [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}}
+	for _, p := range xyz {
+		for k := 0; k < 3; k++ {
+			box.Min[k] = math.Min(box.Min[k], p[k])
+			box.Max[k] = math.Max(box.Max[k], p[k])
+		}
+	}
+
+	meta := NewMetadata(attrs)
+	numPoints := int64(len(xyz))
+	offset := box.Min
+	meta.Points = &numPoints
+	meta.Scale = DefaultCSVScale
+	meta.Offset = &offset
+	meta.BoundingBox = box
+	meta.BytesPerPoint = meta.bytesPerPoint()
+
+	points := NewPoints(attrs, len(xyz))
+	for i, p := range xyz {
+		for k := 0; k < 3; k++ {
+			points[0].SetFloat64(i, k, math.Round((p[k]-offset[k])/meta.Scale[k]))
+		}
+		for j, c := range columns {
+			if c.attr > 0 {
+				points[c.attr].SetFloat64(i, c.element, values[i][j])
+			}
+		}
+	}
+	return &CSVReader{metadata: meta, points: points}, nil
+}
+
+func (r *CSVReader) GetMetadata() *Metadata {
+	return r.metadata
+}
+
+func (r *CSVReader) ReadPoints(max int) ([]Attribute, error) {
+	total := r.points[0].Len()
+	if r.next >= total {
+		return nil, io.EOF
+	}
+	end := r.next + max
+	if end > total {
+		end = total
+	}
+	indices := make([]int, end-r.next)
+	for i := range indices {
+		indices[i] = r.next + i
+	}
+	r.next = end
+
+	ret := make([]Attribute, len(r.points))
+	for i := range r.points {
+		ret[i] = r.points[i].Subset(indices)
+	}
+	return ret, nil
+}
+
+func (r *CSVReader) Close() error {
+	return nil
+}
+
+func attributeColumns(a *Attribute) []string {
+	if a.NumElements == 1 {
+		return []string{a.Name}
+	}
+	if a.Name == COLOR.Name {
+		return []string{"r", "g", "b"}
+	}
+	ret := make([]string, a.NumElements)
+	for i := range ret {
+		ret[i] = fmt.Sprintf("%s[%d]", a.Name, i)
+	}
+	return ret
+}
+
+// WriteCSV writes one line per point with world coordinates x, y, z
+// followed by every other attribute.
+func WriteCSV(w io.Writer, meta *Metadata, points []Attribute) error {
+	return writeCSV(w, meta, points, nil)
+}
+
+// WriteLocalCSV writes the points like WriteCSV but with positions in frame,
+// which is recorded in comment lines before the header.
+func WriteLocalCSV(w io.Writer, frame *LocalFrame, points []Attribute) error {
+	return writeCSV(w, frame.meta, points, frame)
+}
+
+func writeCSV(w io.Writer, meta *Metadata, points []Attribute, frame *LocalFrame) error {
+	position := FindAttribute(points, POSITION.Name)
+	if position == nil {
+		return errors.New("points have no position attribute")
+	}
+	bw := bufio.NewWriter(w)
+
+	if frame != nil {
+		for _, line := range frame.headerLines() {
+			fmt.Fprintf(bw, "# %s\n", line)
+		}
+	}
+	header := []string{"x", "y", "z"}
+	for i := range points {
+		if points[i].Name != POSITION.Name {
+			header = append(header, attributeColumns(&points[i])...)
+		}
+	}
+	fmt.Fprintln(bw, strings.Join(header, ","))
+
+	row := make([]string, 0, len(header))
+	for i := 0; i < position.Len(); i++ {
+		row = row[:0]
+		p := meta.WorldPosition(position, i)
+		if frame != nil {
+			local, err := frame.ToLocal(p)
+			if err != nil {
+				return err
+			}
+			for k := 0; k < 3; k++ {
+				row = append(row, strconv.FormatFloat(float64(float32(local[k])), 'g', -1, 32))
+			}
+		} else {
+			for k := 0; k < 3; k++ {
+				row = append(row, strconv.FormatFloat(p[k], 'f', -1, 64))
+			}
+		}
+		for j := range points {
+			if points[j].Name == POSITION.Name {
+				continue
+			}
+			for e := 0; e < points[j].NumElements; e++ {
+				row = append(row, strconv.FormatFloat(points[j].GetFloat64(i, e), 'g', -1, 64))
+			}
+		}
+		if _, err := fmt.Fprintln(bw, strings.Join(row, ",")); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,236 @@
+package potree
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Point gives access to a single point of a decoded node while editing.
+type Point struct {
+	Node  *Node
+	Index int
+	meta  *Metadata
+	attrs []Attribute
+}
+
+func (p *Point) Position() [3]float64 {
+	return p.meta.WorldPosition(FindAttribute(p.attrs, POSITION.Name), p.Index)
+}
+
+func (p *Point) Has(name string) bool {
+	return FindAttribute(p.attrs, name) != nil
+}
+
+func (p *Point) Get(name string, element int) float64 {
+	a := FindAttribute(p.attrs, name)
+	if a == nil {
+		return 0
+	}
+	return a.GetFloat64(p.Index, element)
+}
+
+func (p *Point) Set(name string, element int, v float64) {
+	if a := FindAttribute(p.attrs, name); a != nil {
+		a.SetFloat64(p.Index, element, v)
+	}
+}
+
+// editNodes decodes every node, calls edit on it and writes back the nodes
+// it reports as modified.
+func (b *PotreeArchive) editNodes(edit func(n *Node) (bool, error)) error {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return err
+		}
+	}
+	var modified []*Node
+	for _, n := range b.GetNodes() {
+		loaded := n.Attrs != nil
+		if !loaded {
+			if err := b.LoadNode(n); err != nil {
+				return err
+			}
+		}
+		changed, err := edit(n)
+		if err != nil {
+			return err
+		}
+		if changed {
+			modified = append(modified, n)
+		} else if !loaded {
+			n.Attrs, n.Buffer = nil, nil
+		}
+	}
+	if len(modified) == 0 {
+		return nil
+	}
+	if err := b.appendNodes(modified); err != nil {
+		return err
+	}
+	for _, n := range modified {
+		extendAttributeRanges(b.metadata, n.Attrs)
+	}
+	b.pruneEmptyLeaves()
+	return b.writeIndex()
+}
+
+func (b *PotreeArchive) pruneEmptyLeaves() {
+	for _, n := range b.GetNodes() {
+		for n != b.root && n.NumPoints == 0 && ChildMaskOf(n) == 0 {
+			parent := n.Parent
+			for i, c := range parent.Childs {
+				if c == n {
+					parent.Childs[i] = nil
+				}
+			}
+			delete(b.nodeMaps, n.Name)
+			if b.cache != nil {
+				b.cache.Remove(n.Name)
+			}
+			n = parent
+		}
+	}
+}
+
+// DeletePoints removes every point for which pred returns true and returns
+// the number of deleted points. Leaves left without points are removed from
+// the hierarchy.
+func (b *PotreeArchive) DeletePoints(pred func(p *Point) bool) (int, error) {
+	deleted := 0
+	err := b.editNodes(func(n *Node) (bool, error) {
+		attrs, err := b.schemaAttributes(n)
+		if err != nil {
+			return false, err
+		}
+		p := &Point{Node: n, meta: b.metadata, attrs: attrs}
+		var keep []int
+		for p.Index = 0; p.Index < int(n.NumPoints); p.Index++ {
+			if !pred(p) {
+				keep = append(keep, p.Index)
+			}
+		}
+		if len(keep) == int(n.NumPoints) {
+			return false, nil
+		}
+		deleted += int(n.NumPoints) - len(keep)
+		for i := range attrs {
+			attrs[i] = attrs[i].Subset(keep)
+		}
+		n.Attrs = attrs
+		n.NumPoints = uint32(len(keep))
+		return true, nil
+	})
+	return deleted, err
+}
+
+// UpdatePoints calls update for every point, update changes attribute
+// values with Point.Set and returns true if it did. Returns the number of
+// updated points.
+func (b *PotreeArchive) UpdatePoints(update func(p *Point) bool) (int, error) {
+	updated := 0
+	err := b.editNodes(func(n *Node) (bool, error) {
+		p := &Point{Node: n, meta: b.metadata, attrs: n.Attrs}
+		changed := false
+		for p.Index = 0; p.Index < int(n.NumPoints); p.Index++ {
+			if update(p) {
+				changed = true
+				updated++
+			}
+		}
+		return changed, nil
+	})
+	return updated, err
+}
+
+// SetAttribute sets element of the named attribute to value for every point
+// matching pred, e.g. to reclassify points.
+func (b *PotreeArchive) SetAttribute(name string, element int, value float64, pred func(p *Point) bool) (int, error) {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return 0, err
+		}
+	}
+	attr := b.metadata.Get(name)
+	if attr == nil {
+		return 0, fmt.Errorf("attribute %s not found", name)
+	}
+	if element < 0 || element >= attr.NumElements {
+		return 0, fmt.Errorf("attribute %s has no element %d", name, element)
+	}
+	return b.UpdatePoints(func(p *Point) bool {
+		if !pred(p) || p.Get(name, element) == value {
+			return false
+		}
+		p.Set(name, element, value)
+		return true
+	})
+}
+
+// Reencode rewrites every node in the given encoding, Brotli settings are
+// taken from the archive options.
+func (b *PotreeArchive) Reencode(encoding string) error {
+	if !validEncoding(encoding) {
+		return &ErrUnsupportedEncoding{Encoding: encoding}
+	}
+	update := func(meta *Metadata) { meta.Encoding = &encoding }
+	return b.rewriteNodes(update, func(n *Node) error { return nil })
+}
+
+// Compact rewrites octree.bin with only the payloads referenced by the
+// hierarchy, dropping the space left behind by replaced nodes, and returns
+// the number of bytes reclaimed.
+func (b *PotreeArchive) Compact() (int64, error) {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return 0, err
+		}
+	}
+	src, err := os.Open(b.getOctreePath())
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := b.getOctreePath() + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	offsets := make(map[*Node]int64)
+	offset := int64(0)
+	for _, n := range b.GetNodes() {
+		if n.ByteSize > 0 {
+			_, err = io.Copy(dst, io.NewSectionReader(src, n.ByteOffset, n.ByteSize))
+			if err != nil {
+				break
+			}
+		}
+		offsets[n] = offset
+		offset += n.ByteSize
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	src.Close()
+	for n, off := range offsets {
+		n.ByteOffset, offsets[n] = off, n.ByteOffset
+	}
+	if replaced, err := b.commitIndex(tmp); err != nil {
+		if !replaced {
+			for n, off := range offsets {
+				n.ByteOffset = off
+			}
+		}
+		return 0, err
+	}
+	return info.Size() - offset, nil
+}
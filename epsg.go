@@ -0,0 +1,93 @@
+package potree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The EPSG table is small and hard-coded, it holds no parameters fetched
+// from the EPSG registry. Supported are the codes of epsgDefinitions, the
+// UTM zones 32601-32660 and 32701-32760 (WGS 84), 25828-25838 (ETRS89) and
+// 26901-26923 (NAD83), and the vertical CRSs of epsgVertical.
+
+type epsgDefinition struct {
+	name string
+	proj string
+}
+
+var epsgDefinitions = map[int]epsgDefinition{
+	4326:  {"WGS 84", "+proj=longlat +datum=WGS84"},
+	4258:  {"ETRS89", "+proj=longlat +ellps=GRS80 +towgs84=0,0,0,0,0,0,0"},
+	4269:  {"NAD83", "+proj=longlat +datum=NAD83"},
+	4267:  {"NAD27", "+proj=longlat +datum=NAD27"},
+	4277:  {"OSGB36", "+proj=longlat +datum=OSGB36"},
+	4150:  {"CH1903+", "+proj=longlat +ellps=bessel +towgs84=674.374,15.056,405.346,0,0,0,0"},
+	4978:  {"WGS 84", "+proj=geocent +datum=WGS84 +units=m"},
+	4936:  {"ETRS89", "+proj=geocent +ellps=GRS80 +towgs84=0,0,0,0,0,0,0 +units=m"},
+	3857:  {"WGS 84 / Pseudo-Mercator", "+proj=webmerc +datum=WGS84"},
+	3395:  {"WGS 84 / World Mercator", "+proj=merc +lon_0=0 +k=1 +x_0=0 +y_0=0 +datum=WGS84 +units=m"},
+	2056:  {"CH1903+ / LV95", "+proj=somerc +lat_0=46.9524055555556 +lon_0=7.43958333333333 +k_0=1 +x_0=2600000 +y_0=1200000 +ellps=bessel +towgs84=674.374,15.056,405.346,0,0,0,0 +units=m"},
+	21781: {"CH1903 / LV03", "+proj=somerc +lat_0=46.9524055555556 +lon_0=7.43958333333333 +k_0=1 +x_0=600000 +y_0=200000 +ellps=bessel +towgs84=674.374,15.056,405.346,0,0,0,0 +units=m"},
+	27700: {"OSGB36 / British National Grid", "+proj=tmerc +lat_0=49 +lon_0=-2 +k=0.9996012717 +x_0=400000 +y_0=-100000 +datum=OSGB36 +units=m"},
+	31467: {"DHDN / 3-degree Gauss-Kruger zone 3", "+proj=tmerc +lat_0=0 +lon_0=9 +k=1 +x_0=3500000 +y_0=0 +datum=potsdam +units=m"},
+	31468: {"DHDN / 3-degree Gauss-Kruger zone 4", "+proj=tmerc +lat_0=0 +lon_0=12 +k=1 +x_0=4500000 +y_0=0 +datum=potsdam +units=m"},
+}
+
+var epsgVertical = map[int]VerticalCRS{
+	5703: {Name: "NAVD88 height", Datum: "North American Vertical Datum 1988", Unit: "metre", UnitFactor: UNIT_METRE},
+	6360: {Name: "NAVD88 height (ftUS)", Datum: "North American Vertical Datum 1988", Unit: "US survey foot", UnitFactor: UNIT_USFOOT},
+	5773: {Name: "EGM96 height", Datum: "EGM96 geoid", Unit: "metre", UnitFactor: UNIT_METRE},
+	3855: {Name: "EGM2008 height", Datum: "EGM2008 geoid", Unit: "metre", UnitFactor: UNIT_METRE},
+	5728: {Name: "LN02 height", Datum: "Landesnivellement 1902", Unit: "metre", UnitFactor: UNIT_METRE},
+	5729: {Name: "LHN95 height", Datum: "Landeshohennetz 1995", Unit: "metre", UnitFactor: UNIT_METRE},
+	5701: {Name: "ODN height", Datum: "Ordnance Datum Newlyn", Unit: "metre", UnitFactor: UNIT_METRE},
+	5783: {Name: "DHHN92 height", Datum: "Deutsches Haupthoehennetz 1992", Unit: "metre", UnitFactor: UNIT_METRE},
+	7837: {Name: "DHHN2016 height", Datum: "Deutsches Haupthoehennetz 2016", Unit: "metre", UnitFactor: UNIT_METRE},
+}
+
+func epsgDefinitionOf(code int) (epsgDefinition, bool) {
+	if def, ok := epsgDefinitions[code]; ok {
+		return def, true
+	}
+	switch {
+	case code >= 32601 && code <= 32660:
+		return epsgDefinition{fmt.Sprintf("WGS 84 / UTM zone %dN", code-32600), fmt.Sprintf("+proj=utm +zone=%d +datum=WGS84 +units=m", code-32600)}, true
+	case code >= 32701 && code <= 32760:
+		return epsgDefinition{fmt.Sprintf("WGS 84 / UTM zone %dS", code-32700), fmt.Sprintf("+proj=utm +zone=%d +south +datum=WGS84 +units=m", code-32700)}, true
+	case code >= 25828 && code <= 25838:
+		return epsgDefinition{fmt.Sprintf("ETRS89 / UTM zone %dN", code-25800), fmt.Sprintf("+proj=utm +zone=%d +ellps=GRS80 +towgs84=0,0,0,0,0,0,0 +units=m", code-25800)}, true
+	case code >= 26901 && code <= 26923:
+		return epsgDefinition{fmt.Sprintf("NAD83 / UTM zone %dN", code-26900), fmt.Sprintf("+proj=utm +zone=%d +datum=NAD83 +units=m", code-26900)}, true
+	}
+	return epsgDefinition{}, false
+}
+
+// NewCRSFromEPSG returns the CRS of a built-in EPSG code: geographic WGS 84
+// (4326), ETRS89 (4258), NAD83 (4269), NAD27 (4267), OSGB36 (4277) and
+// CH1903+ (4150), WGS 84 (4978) and ETRS89 (4936) geocentric, the WGS 84,
+// ETRS89 and NAD83 UTM zones, Web (3857) and World Mercator (3395), the
+// Swiss LV95 (2056) and LV03 (21781) grids, the British National Grid
+// (27700) and German Gauss-Kruger zones 3 and 4 (31467, 31468). Other codes
+// fail with ErrUnsupportedCRS.
+func NewCRSFromEPSG(code int) (*CRS, error) {
+	if _, ok := epsgVertical[code]; ok {
+		return nil, fmt.Errorf("EPSG:%d is a vertical CRS", code)
+	}
+	def, ok := epsgDefinitionOf(code)
+	if !ok {
+		return nil, &ErrUnsupportedCRS{EPSG: code}
+	}
+	ret, err := crsFromPROJ(def.proj)
+	if err != nil {
+		return nil, err
+	}
+	ret.Name = def.name
+	ret.EPSG = code
+	if ret.Datum.Name == ret.Datum.Ellipsoid.Name {
+		ret.Datum.Name = strings.Split(def.name, " / ")[0]
+	}
+	if ret.Type == CRS_GEOGRAPHIC {
+		ret.Axes = []string{AXIS_NORTH, AXIS_EAST}
+	}
+	return ret, nil
+}
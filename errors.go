@@ -0,0 +1,101 @@
+package potree
+
+import (
+	"fmt"
+	"os"
+)
+
+// ErrCorruptNode reports a node whose payload can't be read or decoded.
+type ErrCorruptNode struct {
+	Name   string
+	Offset int64
+	Err    error
+}
+
+func (e *ErrCorruptNode) Error() string {
+	return fmt.Sprintf("node %s at offset %d is corrupt: %v", e.Name, e.Offset, e.Err)
+}
+
+func (e *ErrCorruptNode) Unwrap() error {
+	return e.Err
+}
+
+// ErrMissingFile reports an archive file that doesn't exist.
+type ErrMissingFile struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrMissingFile) Error() string {
+	return fmt.Sprintf("%s not found", e.Path)
+}
+
+func (e *ErrMissingFile) Unwrap() error {
+	return e.Err
+}
+
+// ErrCorruptHierarchy reports a hierarchy that can't be parsed, Node names
+// the node whose chunk at Offset in hierarchy.bin is broken.
+type ErrCorruptHierarchy struct {
+	Node   string
+	Offset int64
+	Err    error
+}
+
+func (e *ErrCorruptHierarchy) Error() string {
+	if e.Node == "" {
+		return fmt.Sprintf("hierarchy is corrupt: %v", e.Err)
+	}
+	return fmt.Sprintf("hierarchy of node %s at offset %d is corrupt: %v", e.Node, e.Offset, e.Err)
+}
+
+func (e *ErrCorruptHierarchy) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnsupportedEncoding reports an encoding no codec is registered for.
+type ErrUnsupportedEncoding struct {
+	Encoding string
+}
+
+func (e *ErrUnsupportedEncoding) Error() string {
+	return fmt.Sprintf("unsupported encoding %s", e.Encoding)
+}
+
+// ErrUnsupportedCRS reports an EPSG code missing from the built-in table.
+// Such CRSs can still be given as WKT or PROJ string.
+type ErrUnsupportedCRS struct {
+	EPSG     int
+	Vertical bool
+}
+
+func (e *ErrUnsupportedCRS) Error() string {
+	kind := "CRS"
+	if e.Vertical {
+		kind = "vertical CRS"
+	}
+	return fmt.Sprintf("unsupported %s EPSG:%d, give it as WKT or PROJ string", kind, e.EPSG)
+}
+
+// ErrSchemaMismatch reports points whose attributes don't match the schema
+// they are written or decoded with.
+type ErrSchemaMismatch struct {
+	Node      string
+	Attribute string
+	Message   string
+}
+
+func (e *ErrSchemaMismatch) Error() string {
+	if e.Node != "" {
+		return fmt.Sprintf("node %s: attribute %s: %s", e.Node, e.Attribute, e.Message)
+	}
+	return fmt.Sprintf("attribute %s: %s", e.Attribute, e.Message)
+}
+
+func openArchiveFile(p string) (*os.File, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, &ErrMissingFile{Path: p, Err: err}
+	}
+	return f, err
+}
@@ -5,4 +5,6 @@ go 1.13
 require (
 	github.com/andybalholm/brotli v1.0.3
 	github.com/flywave/go3d v0.0.0-20210529142521-14eb5aca1290
+	github.com/klauspost/compress v1.13.6
+	github.com/pierrec/lz4/v4 v4.1.8
 )
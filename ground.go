@@ -0,0 +1,278 @@
+package potree
+
+import (
+	"math"
+)
+
+const (
+	LAS_CLASS_UNCLASSIFIED = 1
+	LAS_CLASS_GROUND       = 2
+)
+
+// GroundOptions configures the progressive morphological filter of
+// ClassifyGround, zero values use the defaults in brackets.
+type GroundOptions struct {
+	// CellSize of the minimum height grid in world units [1].
+	CellSize float64
+	// MaxWindow is the largest opening window in world units, a little
+	// larger than the largest building to remove [33].
+	MaxWindow float64
+	// Slope of the terrain as height per horizontal distance [0.7].
+	Slope float64
+	// InitialDistance and MaxDistance bound the height above the opened
+	// surface that is still ground [0.15, 2.5].
+	InitialDistance float64
+	MaxDistance     float64
+	// TileSize is the horizontal extent filtered at once, tiles read
+	// MaxWindow of overlap from their neighbours [8 MaxWindow].
+	TileSize float64
+}
+
+func (o GroundOptions) withDefaults() GroundOptions {
+	if o.CellSize <= 0 {
+		o.CellSize = 1
+	}
+	if o.MaxWindow <= 0 {
+		o.MaxWindow = 33
+	}
+	if o.Slope <= 0 {
+		o.Slope = 0.7
+	}
+	if o.InitialDistance <= 0 {
+		o.InitialDistance = 0.15
+	}
+	if o.MaxDistance <= 0 {
+		o.MaxDistance = 2.5
+	}
+	if o.TileSize <= 0 {
+		o.TileSize = 8 * o.MaxWindow
+	}
+	return o
+}
+
+// minFilter replaces every cell by the minimum, or maximum with max, of the
+// square window of w cells around it, rows first and then columns.
+func minFilter(grid []float64, nx, ny, w int, max bool) []float64 {
+	better := func(a, b float64) bool { return a < b }
+	if max {
+		better = func(a, b float64) bool { return a > b }
+	}
+	half := w / 2
+	rows := make([]float64, len(grid))
+	for y := 0; y < ny; y++ {
+		for x := 0; x < nx; x++ {
+			v := grid[y*nx+x]
+			for i := x - half; i <= x+half; i++ {
+				if i >= 0 && i < nx && better(grid[y*nx+i], v) {
+					v = grid[y*nx+i]
+				}
+			}
+			rows[y*nx+x] = v
+		}
+	}
+	ret := make([]float64, len(grid))
+	for y := 0; y < ny; y++ {
+		for x := 0; x < nx; x++ {
+			v := rows[y*nx+x]
+			for j := y - half; j <= y+half; j++ {
+				if j >= 0 && j < ny && better(rows[j*nx+x], v) {
+					v = rows[j*nx+x]
+				}
+			}
+			ret[y*nx+x] = v
+		}
+	}
+	return ret
+}
+
+// fillGrid gives empty cells the lowest height of their filled neighbours,
+// growing the filled area until no empty cell is left.
+func fillGrid(grid []float64, nx, ny int) {
+	for {
+		var empty []int
+		for i, v := range grid {
+			if math.IsInf(v, 1) {
+				empty = append(empty, i)
+			}
+		}
+		if len(empty) == 0 || len(empty) == len(grid) {
+			return
+		}
+		values := make([]float64, len(empty))
+		for k, i := range empty {
+			x, y := i%nx, i/nx
+			v := math.Inf(1)
+			for _, d := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				if x+d[0] >= 0 && x+d[0] < nx && y+d[1] >= 0 && y+d[1] < ny {
+					v = math.Min(v, grid[(y+d[1])*nx+x+d[0]])
+				}
+			}
+			values[k] = v
+		}
+		for k, i := range empty {
+			grid[i] = values[k]
+		}
+	}
+}
+
+// groundFilter returns for every point whether the progressive
+// morphological filter of Zhang et al. (2003) keeps it as ground. The
+// minimum heights of a grid are opened with windows of 3, 5, 9, 17... cells
+// and points rising above the opened surface by more than the height
+// threshold of the window are removed.
+func groundFilter(points [][3]float64, box AABB, o GroundOptions) []bool {
+	nx := int(math.Ceil((box.Max[0]-box.Min[0])/o.CellSize)) + 1
+	ny := int(math.Ceil((box.Max[1]-box.Min[1])/o.CellSize)) + 1
+	cells := make([]int, len(points))
+	surface := make([]float64, nx*ny)
+	for i := range surface {
+		surface[i] = math.Inf(1)
+	}
+	for i, p := range points {
+		x := int((p[0] - box.Min[0]) / o.CellSize)
+		y := int((p[1] - box.Min[1]) / o.CellSize)
+		cells[i] = y*nx + x
+		surface[cells[i]] = math.Min(surface[cells[i]], p[2])
+	}
+	fillGrid(surface, nx, ny)
+
+	ground := make([]bool, len(points))
+	for i := range ground {
+		ground[i] = true
+	}
+	last := 1
+	for w := 3; float64(w)*o.CellSize <= o.MaxWindow || w == 3; w = 2*w - 1 {
+		threshold := o.InitialDistance
+		if last > 1 {
+			threshold = math.Min(o.Slope*float64(w-last)*o.CellSize+o.InitialDistance, o.MaxDistance)
+		}
+		surface = minFilter(minFilter(surface, nx, ny, w, false), nx, ny, w, true)
+		for i, p := range points {
+			if ground[i] && p[2]-surface[cells[i]] > threshold {
+				ground[i] = false
+			}
+		}
+		last = w
+	}
+	return ground
+}
+
+type groundPoint struct {
+	node  *Node
+	index int
+}
+
+// ClassifyGround sets the classification of every point to ground (2) or
+// unclassified (1) with a progressive morphological filter, adding the
+// classification attribute if the archive has none. The horizontal extent
+// of the octree is filtered tile by tile, each tile reading the points of
+// all nodes within MaxWindow around it, and only nodes with changed
+// classes are written back. Returns the number of ground points.
+func (b *PotreeArchive) ClassifyGround(opts GroundOptions) (int, error) {
+	o := opts.withDefaults()
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return 0, err
+		}
+	}
+	release, err := b.keepOpen()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	cache := NewNodeCache(neighbourCacheSize)
+	classes := make(map[string][]uint8)
+	extent := b.root.Box
+	tiles := [2]int{
+		int((extent.Max[0]-extent.Min[0])/o.TileSize) + 1,
+		int((extent.Max[1]-extent.Min[1])/o.TileSize) + 1,
+	}
+	tileOf := func(p [3]float64) (int, int) {
+		return int((p[0] - extent.Min[0]) / o.TileSize), int((p[1] - extent.Min[1]) / o.TileSize)
+	}
+	var (
+		points [][3]float64
+		refs   []groundPoint
+	)
+	numGround := 0
+	for ty := 0; ty < tiles[1]; ty++ {
+		for tx := 0; tx < tiles[0]; tx++ {
+			search := AABB{Min: extent.Min, Max: extent.Max}
+			search.Min[0] = extent.Min[0] + float64(tx)*o.TileSize - o.MaxWindow
+			search.Min[1] = extent.Min[1] + float64(ty)*o.TileSize - o.MaxWindow
+			search.Max[0] = search.Min[0] + o.TileSize + 2*o.MaxWindow
+			search.Max[1] = search.Min[1] + o.TileSize + 2*o.MaxWindow
+
+			points, refs = points[:0], refs[:0]
+			var nodes []*Node
+			b.root.Traverse(func(n *Node) bool {
+				if n.NumPoints > 0 && search.Intersects(n.Box) {
+					nodes = append(nodes, n)
+				}
+				return true
+			})
+			for _, n := range nodes {
+				position, err := b.cachedPosition(cache, n)
+				if err != nil {
+					return 0, err
+				}
+				for i := 0; i < position.Len(); i++ {
+					p := b.metadata.WorldPosition(position, i)
+					if p[0] >= search.Min[0] && p[0] < search.Max[0] && p[1] >= search.Min[1] && p[1] < search.Max[1] {
+						points = append(points, p)
+						refs = append(refs, groundPoint{n, i})
+					}
+				}
+			}
+			if len(points) == 0 {
+				continue
+			}
+			ground := groundFilter(points, search, o)
+			for i, p := range points {
+				if x, y := tileOf(p); x != tx || y != ty {
+					continue
+				}
+				n := refs[i].node
+				values := classes[n.Name]
+				if values == nil {
+					values = make([]uint8, n.NumPoints)
+					classes[n.Name] = values
+				}
+				values[refs[i].index] = LAS_CLASS_UNCLASSIFIED
+				if ground[i] {
+					values[refs[i].index] = LAS_CLASS_GROUND
+					numGround++
+				}
+			}
+		}
+	}
+	release()
+	cache.Purge()
+
+	classOf := func(n *Node, i int) uint8 {
+		if values := classes[n.Name]; values != nil && values[i] != 0 {
+			return values[i]
+		}
+		return LAS_CLASS_UNCLASSIFIED
+	}
+	if b.metadata.Get(CLASSIFICATION.Name) == nil {
+		err := b.AddAttribute(CLASSIFICATION, func(n *Node, points []Attribute) (interface{}, error) {
+			values := make([]uint8, n.NumPoints)
+			for i := range values {
+				values[i] = classOf(n, i)
+			}
+			return values, nil
+		})
+		return numGround, err
+	}
+	_, err = b.UpdatePoints(func(p *Point) bool {
+		class := float64(classOf(p.Node, p.Index))
+		if p.Get(CLASSIFICATION.Name, 0) == class {
+			return false
+		}
+		p.Set(CLASSIFICATION.Name, 0, class)
+		return true
+	})
+	return numGround, err
+}
@@ -1,7 +1,94 @@
 package potree
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
 type Hierarchy struct {
 	StepSize       int64 `json:"stepSize"`
 	FirstChunkSize int64 `json:"firstChunkSize"`
 	Depth          *int  `json:"depth,omitempty"`
 }
+
+type hierarchyParser struct {
+	data    []byte
+	nodes   map[string]*Node
+	visited map[int64]bool
+	errs    []error
+}
+
+func (p *hierarchyParser) fail(n *Node, offset int64, err error) {
+	p.errs = append(p.errs, &ErrCorruptHierarchy{Node: n.Name, Offset: offset, Err: err})
+}
+
+func newHierarchyParser(data []byte, nodes map[string]*Node) *hierarchyParser {
+	return &hierarchyParser{data: data, nodes: nodes, visited: make(map[int64]bool)}
+}
+
+func (p *hierarchyParser) parse(start *Node, offset, size int64) {
+	if size <= 0 || size%BytesPerNode != 0 || offset < 0 || offset+size > int64(len(p.data)) {
+		p.fail(start, offset, fmt.Errorf("chunk [%d, %d) is out of range of %d bytes", offset, offset+size, len(p.data)))
+		return
+	}
+	if p.visited[offset] {
+		p.fail(start, offset, errors.New("chunk is referenced twice"))
+		return
+	}
+	p.visited[offset] = true
+
+	chunk := p.data[offset : offset+size]
+	numNodes := int(size / BytesPerNode)
+	nodes := make([]*Node, numNodes)
+	nodes[0] = start
+	pos := 1
+
+	for i := 0; i < numNodes; i++ {
+		current := nodes[i]
+		if current == nil {
+			p.fail(start, offset, fmt.Errorf("chunk has %d records but only %d nodes", numNodes, pos))
+			return
+		}
+
+		rec := node{}
+		if err := rec.readNode(bytes.NewReader(chunk[i*BytesPerNode : (i+1)*BytesPerNode])); err != nil {
+			p.fail(current, offset, err)
+			return
+		}
+
+		if rec.Type == NT_PROXY {
+			if i == 0 {
+				p.fail(start, offset, errors.New("chunk starts with a proxy"))
+				return
+			}
+			current.NumPoints = rec.NumPoints
+			p.parse(current, rec.ByteOffset, rec.ByteSize)
+			continue
+		}
+
+		current.node = rec
+
+		for child_index := 0; child_index < 8; child_index++ {
+			child_exists := ((1 << uint32(child_index)) & uint32(current.ChildMask)) != 0
+			if !child_exists {
+				continue
+			}
+			if pos >= numNodes {
+				p.fail(current, offset, errors.New("child mask references more nodes than the chunk holds"))
+				return
+			}
+
+			child := &Node{}
+			child.Name = current.Name + strconv.Itoa(child_index)
+			child.Box = current.Box.Child(child_index)
+			child.Parent = current
+			current.Childs[child_index] = child
+
+			nodes[pos] = child
+			pos++
+			p.nodes[child.Name] = child
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package potree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	LAS_HEADER_SIZE_12   = 227
+	LAS_HEADER_SIZE_14   = 375
+	LAS_VLR_HEADER_SIZE  = 54
+	LAS_EVLR_HEADER_SIZE = 60
+)
+
+const (
+	LAS_PROJECTION_USER_ID  = "LASF_Projection"
+	LAS_WKT_RECORD_ID       = 2112
+	LAS_GEOKEYS_RECORD_ID   = 34735
+	LAS_GLOBAL_ENCODING_WKT = 1 << 4
+)
+
+type lasHeader struct {
+	VersionMajor     uint8
+	VersionMinor     uint8
+	HeaderSize       uint16
+	OffsetToPoints   uint32
+	NumVLRs          uint32
+	PointFormat      uint8
+	PointRecordSize  uint16
+	NumPoints        uint64
+	Scale            [3]float64
+	Offset           [3]float64
+	Min              [3]float64
+	Max              [3]float64
+	GlobalEncoding   uint16
+	PointsByReturn   [5]uint32
+	SystemIdentifier [32]byte
+	GeneratingSW     [32]byte
+	StartOfEVLRs     uint64
+	NumEVLRs         uint32
+}
+
+func (h *lasHeader) read(rd io.ReaderAt) error {
+	buf := make([]byte, LAS_HEADER_SIZE_14)
+	n, err := rd.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n < LAS_HEADER_SIZE_12 || string(buf[0:4]) != "LASF" {
+		return errors.New("not a LAS file")
+	}
+	le := binary.LittleEndian
+	h.GlobalEncoding = le.Uint16(buf[6:])
+	h.VersionMajor = buf[24]
+	h.VersionMinor = buf[25]
+	copy(h.SystemIdentifier[:], buf[26:58])
+	copy(h.GeneratingSW[:], buf[58:90])
+	h.HeaderSize = le.Uint16(buf[94:])
+	h.OffsetToPoints = le.Uint32(buf[96:])
+	h.NumVLRs = le.Uint32(buf[100:])
+	h.PointFormat = buf[104] & 0x3f
+	h.PointRecordSize = le.Uint16(buf[105:])
+	h.NumPoints = uint64(le.Uint32(buf[107:]))
+	for i := 0; i < 3; i++ {
+		h.Scale[i] = math.Float64frombits(le.Uint64(buf[131+i*8:]))
+		h.Offset[i] = math.Float64frombits(le.Uint64(buf[155+i*8:]))
+		h.Max[i] = math.Float64frombits(le.Uint64(buf[179+i*16:]))
+		h.Min[i] = math.Float64frombits(le.Uint64(buf[187+i*16:]))
+	}
+	if h.VersionMinor >= 4 && n >= LAS_HEADER_SIZE_14 {
+		h.StartOfEVLRs = le.Uint64(buf[235:])
+		h.NumEVLRs = le.Uint32(buf[243:])
+		if h.NumPoints == 0 {
+			h.NumPoints = le.Uint64(buf[247:])
+		}
+	}
+	return nil
+}
+
+func (h *lasHeader) write(w io.Writer) error {
+	buf := make([]byte, h.HeaderSize)
+	le := binary.LittleEndian
+	copy(buf[0:4], "LASF")
+	le.PutUint16(buf[6:], h.GlobalEncoding)
+	buf[24] = h.VersionMajor
+	buf[25] = h.VersionMinor
+	copy(buf[26:58], h.SystemIdentifier[:])
+	copy(buf[58:90], h.GeneratingSW[:])
+	le.PutUint16(buf[94:], h.HeaderSize)
+	le.PutUint32(buf[96:], h.OffsetToPoints)
+	le.PutUint32(buf[100:], h.NumVLRs)
+	buf[104] = h.PointFormat
+	le.PutUint16(buf[105:], h.PointRecordSize)
+	if h.NumPoints <= math.MaxUint32 && h.PointFormat < 6 {
+		le.PutUint32(buf[107:], uint32(h.NumPoints))
+		for i, c := range h.PointsByReturn {
+			le.PutUint32(buf[111+i*4:], c)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		le.PutUint64(buf[131+i*8:], math.Float64bits(h.Scale[i]))
+		le.PutUint64(buf[155+i*8:], math.Float64bits(h.Offset[i]))
+		le.PutUint64(buf[179+i*16:], math.Float64bits(h.Max[i]))
+		le.PutUint64(buf[187+i*16:], math.Float64bits(h.Min[i]))
+	}
+	if h.HeaderSize >= LAS_HEADER_SIZE_14 {
+		le.PutUint64(buf[235:], h.StartOfEVLRs)
+		le.PutUint32(buf[243:], h.NumEVLRs)
+		le.PutUint64(buf[247:], h.NumPoints)
+		for i, c := range h.PointsByReturn {
+			le.PutUint64(buf[255+i*8:], uint64(c))
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+type lasVLR struct {
+	UserID      string
+	RecordID    uint16
+	Description string
+	Data        []byte
+}
+
+func lasString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
+
+// readVLRs reads the variable length records and, for LAS 1.4, the extended
+// ones. Only the data of projection records is loaded.
+func (h *lasHeader) readVLRs(rd io.ReaderAt) ([]lasVLR, error) {
+	var ret []lasVLR
+	le := binary.LittleEndian
+	read := func(offset int64, headerSize int) (int64, error) {
+		buf := make([]byte, headerSize)
+		if _, err := rd.ReadAt(buf, offset); err != nil {
+			return 0, fmt.Errorf("LAS record at %d: %v", offset, err)
+		}
+		vlr := lasVLR{UserID: lasString(buf[2:18]), RecordID: le.Uint16(buf[18:])}
+		var length int64
+		if headerSize == LAS_EVLR_HEADER_SIZE {
+			length = int64(le.Uint64(buf[20:]))
+			vlr.Description = lasString(buf[28:60])
+		} else {
+			length = int64(le.Uint16(buf[20:]))
+			vlr.Description = lasString(buf[22:54])
+		}
+		if vlr.UserID == LAS_PROJECTION_USER_ID && length < 1<<24 {
+			vlr.Data = make([]byte, length)
+			if _, err := rd.ReadAt(vlr.Data, offset+int64(headerSize)); err != nil {
+				return 0, fmt.Errorf("LAS record at %d: %v", offset, err)
+			}
+		}
+		ret = append(ret, vlr)
+		return offset + int64(headerSize) + length, nil
+	}
+	offset := int64(h.HeaderSize)
+	for i := uint32(0); i < h.NumVLRs; i++ {
+		var err error
+		if offset, err = read(offset, LAS_VLR_HEADER_SIZE); err != nil {
+			return nil, err
+		}
+	}
+	offset = int64(h.StartOfEVLRs)
+	for i := uint32(0); i < h.NumEVLRs && offset > 0; i++ {
+		var err error
+		if offset, err = read(offset, LAS_EVLR_HEADER_SIZE); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (v *lasVLR) write(w io.Writer) error {
+	if len(v.Data) > math.MaxUint16 {
+		return fmt.Errorf("LAS record %s %d is too large", v.UserID, v.RecordID)
+	}
+	buf := make([]byte, LAS_VLR_HEADER_SIZE)
+	le := binary.LittleEndian
+	copy(buf[2:18], v.UserID)
+	le.PutUint16(buf[18:], v.RecordID)
+	le.PutUint16(buf[20:], uint16(len(v.Data)))
+	copy(buf[22:54], v.Description)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	_, err := w.Write(v.Data)
+	return err
+}
+
+// lasProjection returns the CRS of the projection records, OGC WKT is
+// preferred over GeoTIFF keys.
+func lasProjection(vlrs []lasVLR) string {
+	var codes [3]int
+	for _, v := range vlrs {
+		if v.UserID != LAS_PROJECTION_USER_ID {
+			continue
+		}
+		switch v.RecordID {
+		case LAS_WKT_RECORD_ID:
+			if wkt := strings.TrimSpace(lasString(v.Data)); wkt != "" {
+				return wkt
+			}
+		case LAS_GEOKEYS_RECORD_ID:
+			le := binary.LittleEndian
+			for i := 8; i+8 <= len(v.Data); i += 8 {
+				id, location, value := le.Uint16(v.Data[i:]), le.Uint16(v.Data[i+2:]), le.Uint16(v.Data[i+6:])
+				if location != 0 || value == 0 || value == 32767 {
+					continue
+				}
+				switch id {
+				case 2048:
+					codes[0] = int(value)
+				case 3072:
+					codes[1] = int(value)
+				case 4096:
+					codes[2] = int(value)
+				}
+			}
+		}
+	}
+	code := codes[1]
+	if code == 0 {
+		code = codes[0]
+	}
+	if code == 0 {
+		return ""
+	}
+	crs, err := NewCRSFromEPSG(code)
+	if err != nil {
+		return fmt.Sprintf("EPSG:%d", code)
+	}
+	if v, ok := epsgVertical[codes[2]]; ok {
+		v.EPSG = codes[2]
+		crs.Vertical = &v
+	}
+	return crs.WKT()
+}
+
+func lasPointAttributes(format uint8) ([]Attribute, error) {
+	var ret []Attribute
+	switch format {
+	case 0, 1, 2, 3:
+		ret = []Attribute{POSITION, INTENSITY, RETURN_NUMBER, NUMBER_OF_RETURNS, CLASSIFICATION, SCAN_ANGLE_RANK, USER_DATA, POINT_SOURCE_ID}
+	case 6, 7, 8:
+		ret = []Attribute{POSITION, INTENSITY, RETURN_NUMBER, NUMBER_OF_RETURNS, CLASSIFICATION_FLAGS, CLASSIFICATION, USER_DATA, SCAN_ANGLE, POINT_SOURCE_ID}
+	default:
+		return nil, fmt.Errorf("unsupported LAS point format %d", format)
+	}
+	switch format {
+	case 1, 6:
+		ret = append(ret, GPS_TIME)
+	case 2:
+		ret = append(ret, COLOR)
+	case 3, 7, 8:
+		ret = append(ret, GPS_TIME, COLOR)
+	}
+	return ret, nil
+}
+
+func lasPointSize(format uint8) int {
+	switch format {
+	case 0:
+		return 20
+	case 1:
+		return 28
+	case 2:
+		return 26
+	case 3:
+		return 34
+	case 6:
+		return 30
+	case 7:
+		return 36
+	case 8:
+		return 38
+	}
+	return 0
+}
+
+type LASReader struct {
+	file     *os.File
+	header   lasHeader
+	metadata *Metadata
+	next     uint64
+}
+
+func OpenLASReader(p string) (*LASReader, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	rd := &LASReader{file: f}
+	if err := rd.header.read(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	attrs, err := lasPointAttributes(rd.header.PointFormat)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if int(rd.header.PointRecordSize) < lasPointSize(rd.header.PointFormat) {
+		f.Close()
+		return nil, fmt.Errorf("LAS point record size %d is too small for format %d", rd.header.PointRecordSize, rd.header.PointFormat)
+	}
+
+	vlrs, err := rd.header.readVLRs(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	meta := NewMetadata(attrs)
+	if projection := lasProjection(vlrs); projection != "" {
+		meta.Projection = &projection
+	}
+	numPoints := int64(rd.header.NumPoints)
+	offset := rd.header.Offset
+	meta.Points = &numPoints
+	meta.Scale = rd.header.Scale
+	meta.Offset = &offset
+	meta.BoundingBox = AABB{Min: rd.header.Min, Max: rd.header.Max}
+	meta.BytesPerPoint = meta.bytesPerPoint()
+	rd.metadata = meta
+	return rd, nil
+}
+
+func (r *LASReader) GetMetadata() *Metadata {
+	return r.metadata
+}
+
+func (r *LASReader) ReadPoints(max int) ([]Attribute, error) {
+	if r.next >= r.header.NumPoints {
+		return nil, io.EOF
+	}
+	count := r.header.NumPoints - r.next
+	if count > uint64(max) {
+		count = uint64(max)
+	}
+	recordSize := int64(r.header.PointRecordSize)
+	buf := make([]byte, int64(count)*recordSize)
+	if _, err := r.file.ReadAt(buf, int64(r.header.OffsetToPoints)+int64(r.next)*recordSize); err != nil {
+		return nil, err
+	}
+	r.next += count
+
+	format := r.header.PointFormat
+	ret := NewPoints(r.metadata.Attrs, int(count))
+	le := binary.LittleEndian
+	position := ret[0].Data.([]int32)
+	for i := 0; i < int(count); i++ {
+		rec := buf[int64(i)*recordSize:]
+		position[i*3] = int32(le.Uint32(rec[0:]))
+		position[i*3+1] = int32(le.Uint32(rec[4:]))
+		position[i*3+2] = int32(le.Uint32(rec[8:]))
+		ret[1].Data.([]uint16)[i] = le.Uint16(rec[12:])
+
+		next := 0
+		if format < 6 {
+			ret[2].Data.([]uint8)[i] = rec[14] & 0x07
+			ret[3].Data.([]uint8)[i] = (rec[14] >> 3) & 0x07
+			ret[4].Data.([]uint8)[i] = rec[15]
+			ret[5].Data.([]uint8)[i] = uint8(rec[16])
+			ret[6].Data.([]uint8)[i] = rec[17]
+			ret[7].Data.([]uint16)[i] = le.Uint16(rec[18:])
+			next = 20
+		} else {
+			ret[2].Data.([]uint8)[i] = rec[14] & 0x0f
+			ret[3].Data.([]uint8)[i] = rec[14] >> 4
+			ret[4].Data.([]uint8)[i] = rec[15] & 0x0f
+			ret[5].Data.([]uint8)[i] = rec[16]
+			ret[6].Data.([]uint8)[i] = rec[17]
+			ret[7].Data.([]int16)[i] = int16(le.Uint16(rec[18:]))
+			ret[8].Data.([]uint16)[i] = le.Uint16(rec[20:])
+			next = 22
+		}
+		attr := len(ret) - 1
+		switch format {
+		case 1, 3, 6, 7, 8:
+			gps := FindAttribute(ret, GPS_TIME.Name)
+			gps.Data.([]float64)[i] = math.Float64frombits(le.Uint64(rec[next:]))
+			next += 8
+		}
+		switch format {
+		case 2, 3, 7, 8:
+			rgb := ret[attr].Data.([]uint16)
+			rgb[i*3] = le.Uint16(rec[next:])
+			rgb[i*3+1] = le.Uint16(rec[next+2:])
+			rgb[i*3+2] = le.Uint16(rec[next+4:])
+		}
+	}
+	return ret, nil
+}
+
+func (r *LASReader) Close() error {
+	return r.file.Close()
+}
+
+// WriteLAS writes the points as a LAS 1.4 file using point format 0 to 3,
+// depending on whether gps-time and rgb are present. Positions keep the
+// quantization of the metadata, the CRS is written as an OGC WKT record.
+func WriteLAS(w io.Writer, meta *Metadata, points []Attribute) error {
+	position := FindAttribute(points, POSITION.Name)
+	if position == nil {
+		return errors.New("points have no position attribute")
+	}
+	numPoints := position.Len()
+	gps := FindAttribute(points, GPS_TIME.Name)
+	rgb := FindAttribute(points, COLOR.Name)
+
+	format := uint8(0)
+	if gps != nil {
+		format |= 1
+	}
+	if rgb != nil {
+		format |= 2
+	}
+
+	header := &lasHeader{VersionMajor: 1, VersionMinor: 4, HeaderSize: LAS_HEADER_SIZE_14, PointFormat: format}
+	var vlrs []lasVLR
+	if wkt := lasWKT(meta); wkt != "" {
+		vlrs = append(vlrs, lasVLR{UserID: LAS_PROJECTION_USER_ID, RecordID: LAS_WKT_RECORD_ID, Description: "OGC WKT", Data: append([]byte(wkt), 0)})
+		header.GlobalEncoding |= LAS_GLOBAL_ENCODING_WKT
+	}
+	header.NumVLRs = uint32(len(vlrs))
+	header.OffsetToPoints = uint32(header.HeaderSize)
+	for _, v := range vlrs {
+		header.OffsetToPoints += uint32(LAS_VLR_HEADER_SIZE + len(v.Data))
+	}
+	header.PointRecordSize = uint16(lasPointSize(format))
+	header.NumPoints = uint64(numPoints)
+	header.Scale = meta.Scale
+	header.Offset = meta.offsetVector()
+	copy(header.GeneratingSW[:], "go-potree")
+	for k := 0; k < 3; k++ {
+		header.Min[k] = math.Inf(1)
+		header.Max[k] = math.Inf(-1)
+	}
+	returnNumber := FindAttribute(points, RETURN_NUMBER.Name)
+	for i := 0; i < numPoints; i++ {
+		p := meta.WorldPosition(position, i)
+		for k := 0; k < 3; k++ {
+			header.Min[k] = math.Min(header.Min[k], p[k])
+			header.Max[k] = math.Max(header.Max[k], p[k])
+		}
+		r := 1
+		if returnNumber != nil {
+			r = int(returnNumber.GetFloat64(i, 0))
+		}
+		if r >= 1 && r <= 5 {
+			header.PointsByReturn[r-1]++
+		}
+	}
+	if numPoints == 0 {
+		header.Min, header.Max = [3]float64{}, [3]float64{}
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := header.write(bw); err != nil {
+		return err
+	}
+	for i := range vlrs {
+		if err := vlrs[i].write(bw); err != nil {
+			return err
+		}
+	}
+
+	value := func(name string, i int) float64 {
+		if a := FindAttribute(points, name); a != nil {
+			return a.GetFloat64(i, 0)
+		}
+		return 0
+	}
+	scanAngle := func(i int) int8 {
+		if a := FindAttribute(points, SCAN_ANGLE_RANK.Name); a != nil {
+			return int8(a.GetFloat64(i, 0))
+		}
+		if a := FindAttribute(points, SCAN_ANGLE.Name); a != nil {
+			return int8(math.Round(a.GetFloat64(i, 0) * 0.006))
+		}
+		return 0
+	}
+
+	le := binary.LittleEndian
+	rec := make([]byte, header.PointRecordSize)
+	for i := 0; i < numPoints; i++ {
+		for k := range rec {
+			rec[k] = 0
+		}
+		for k := 0; k < 3; k++ {
+			le.PutUint32(rec[k*4:], uint32(int32(position.GetFloat64(i, k))))
+		}
+		le.PutUint16(rec[12:], uint16(value(INTENSITY.Name, i)))
+		rec[14] = uint8(value(RETURN_NUMBER.Name, i))&0x07 | (uint8(value(NUMBER_OF_RETURNS.Name, i))&0x07)<<3
+		rec[15] = uint8(value(CLASSIFICATION.Name, i))
+		rec[16] = uint8(scanAngle(i))
+		rec[17] = uint8(value(USER_DATA.Name, i))
+		le.PutUint16(rec[18:], uint16(value(POINT_SOURCE_ID.Name, i)))
+		next := 20
+		if gps != nil {
+			le.PutUint64(rec[next:], math.Float64bits(gps.GetFloat64(i, 0)))
+			next += 8
+		}
+		if rgb != nil {
+			for k := 0; k < 3; k++ {
+				le.PutUint16(rec[next+k*2:], uint16(rgb.GetFloat64(i, k)))
+			}
+		}
+		if _, err := bw.Write(rec); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// lasWKT returns the CRS of meta as WKT, a projection that can't be parsed
+// is passed on if it looks like WKT.
+func lasWKT(meta *Metadata) string {
+	crs, err := meta.GetCRS()
+	if err == nil && crs != nil {
+		return crs.WKT()
+	}
+	if err != nil && strings.Contains(*meta.Projection, "[") {
+		return strings.TrimSpace(*meta.Projection)
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LocalFrame is an east-north-up frame tangent to the WGS 84 ellipsoid at an
+// origin, so renderers can place points with float32 coordinates. Archives
+// without CRS get a frame that only moves the origin.
+type LocalFrame struct {
+	meta   *Metadata
+	toECEF *Transform
+	origin [3]float64
+	ecef   [3]float64
+	rot    [3][3]float64
+}
+
+// NewLocalFrame returns the frame at origin, given in world coordinates of
+// the archive described by meta.
+func NewLocalFrame(meta *Metadata, origin [3]float64) (*LocalFrame, error) {
+	f := &LocalFrame{meta: meta, origin: origin, rot: [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}
+	crs, err := meta.GetCRS()
+	if err != nil {
+		return nil, err
+	}
+	if crs == nil {
+		return f, nil
+	}
+	ecef, err := NewCRSFromEPSG(4978)
+	if err != nil {
+		return nil, err
+	}
+	if f.toECEF, err = NewTransform(crs, ecef); err != nil {
+		return nil, err
+	}
+	if f.ecef, err = f.toECEF.Apply(origin); err != nil {
+		return nil, err
+	}
+	lon, lat, _ := WGS84_ELLIPSOID.GeocentricToGeodetic(f.ecef)
+	sinLon, cosLon := math.Sincos(lon)
+	sinLat, cosLat := math.Sincos(lat)
+	f.rot = [3][3]float64{
+		{-sinLon, cosLon, 0},
+		{-sinLat * cosLon, -sinLat * sinLon, cosLat},
+		{cosLat * cosLon, cosLat * sinLon, sinLat},
+	}
+	return f, nil
+}
+
+// NewCenteredFrame returns the frame at the center of the bounding box of
+// meta.
+func NewCenteredFrame(meta *Metadata) (*LocalFrame, error) {
+	return NewLocalFrame(meta, meta.BoundingBox.Center())
+}
+
+// IsGeoreferenced reports whether the frame is tied to ECEF, which needs a
+// CRS in the metadata.
+func (f *LocalFrame) IsGeoreferenced() bool {
+	return f.toECEF != nil
+}
+
+// GetOrigin returns the origin in world coordinates of the archive.
+func (f *LocalFrame) GetOrigin() [3]float64 {
+	return f.origin
+}
+
+// GetOriginECEF returns the origin in WGS 84 geocentric coordinates.
+func (f *LocalFrame) GetOriginECEF() [3]float64 {
+	return f.ecef
+}
+
+// GetMatrix returns the column-major 4x4 matrix from the frame to ECEF, as
+// used by glTF nodes and 3D Tiles, or to world coordinates of the archive if
+// the frame isn't georeferenced.
+func (f *LocalFrame) GetMatrix() [16]float64 {
+	t := f.origin
+	if f.IsGeoreferenced() {
+		t = f.ecef
+	}
+	var m [16]float64
+	for c := 0; c < 3; c++ {
+		for r := 0; r < 3; r++ {
+			m[c*4+r] = f.rot[c][r]
+		}
+		m[12+c] = t[c]
+	}
+	m[15] = 1
+	return m
+}
+
+// ToECEF converts world coordinates of the archive to WGS 84 geocentric
+// coordinates.
+func (f *LocalFrame) ToECEF(p [3]float64) ([3]float64, error) {
+	if !f.IsGeoreferenced() {
+		return [3]float64{}, errors.New("archive has no CRS")
+	}
+	return f.toECEF.Apply(p)
+}
+
+// ToLocal converts world coordinates of the archive to the frame.
+func (f *LocalFrame) ToLocal(p [3]float64) ([3]float64, error) {
+	if !f.IsGeoreferenced() {
+		return [3]float64{p[0] - f.origin[0], p[1] - f.origin[1], p[2] - f.origin[2]}, nil
+	}
+	ecef, err := f.toECEF.Apply(p)
+	if err != nil {
+		return [3]float64{}, err
+	}
+	d := [3]float64{ecef[0] - f.ecef[0], ecef[1] - f.ecef[1], ecef[2] - f.ecef[2]}
+	var ret [3]float64
+	for r := 0; r < 3; r++ {
+		ret[r] = f.rot[r][0]*d[0] + f.rot[r][1]*d[1] + f.rot[r][2]*d[2]
+	}
+	return ret, nil
+}
+
+// NodeCenterECEF returns the center of the node box in ECEF.
+func (f *LocalFrame) NodeCenterECEF(n *Node) ([3]float64, error) {
+	return f.ToECEF(n.Box.Center())
+}
+
+// NodeCenterLocal returns the center of the node box in the frame.
+func (f *LocalFrame) NodeCenterLocal(n *Node) ([3]float64, error) {
+	return f.ToLocal(n.Box.Center())
+}
+
+// LocalPositions returns the positions of a decoded position attribute in
+// the frame as float32.
+func (f *LocalFrame) LocalPositions(position *Attribute) ([]float32, error) {
+	ret := make([]float32, 0, position.Len()*3)
+	for i := 0; i < position.Len(); i++ {
+		p, err := f.ToLocal(f.meta.WorldPosition(position, i))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, float32(p[0]), float32(p[1]), float32(p[2]))
+	}
+	return ret, nil
+}
+
+// headerLines records the frame in exported files.
+func (f *LocalFrame) headerLines() []string {
+	format := func(values []float64) string {
+		s := make([]string, len(values))
+		for i, v := range values {
+			s[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return strings.Join(s, " ")
+	}
+	ret := []string{"local_origin " + format(f.origin[:])}
+	if crs, err := f.meta.GetCRS(); err == nil && crs != nil {
+		ret = append(ret, fmt.Sprintf("local_origin_crs %s", crs))
+	}
+	m := f.GetMatrix()
+	if f.IsGeoreferenced() {
+		ret = append(ret, "local_to_ecef "+format(m[:]))
+	} else {
+		ret = append(ret, "local_to_world "+format(m[:]))
+	}
+	return ret
+}
@@ -0,0 +1,76 @@
+package potree
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLocalFrame(t *testing.T) {
+	meta := NewMetadata([]Attribute{POSITION, INTENSITY})
+	meta.Scale = [3]float64{0.001, 0.001, 0.001}
+	meta.Offset = &[3]float64{500000, 4982950, 0}
+	utm, _ := NewCRSFromEPSG(32632)
+	meta.SetCRS(utm)
+
+	origin := [3]float64{500000, 4982950.4, 100}
+	frame, err := NewLocalFrame(meta, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	east, err := frame.ToLocal([3]float64{origin[0] + 99.96, origin[1], origin[2] + 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(east[0]-100) > 0.01 || math.Abs(east[1]) > 0.01 || math.Abs(east[2]-10) > 0.01 {
+		t.Errorf("east of the origin at %v", east)
+	}
+
+	m := frame.GetMatrix()
+	ecef, err := frame.ToECEF([3]float64{origin[0] + 99.96, origin[1], origin[2] + 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := 0; r < 3; r++ {
+		v := m[r]*east[0] + m[4+r]*east[1] + m[8+r]*east[2] + m[12+r]
+		if math.Abs(v-ecef[r]) > 1e-6 {
+			t.Fatalf("matrix maps %v to %v, not %v", east, v, ecef)
+		}
+	}
+
+	position := POSITION
+	position.Data = []int32{0, 400, 100000, 99960, 400, 110000}
+	points := []Attribute{position, INTENSITY}
+	points[1].Data = make([]uint16, 2)
+	buf := &bytes.Buffer{}
+	if err := WriteLocalCSV(buf, frame, points); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "# local_to_ecef ") {
+		t.Fatalf("frame not recorded in\n%s", buf)
+	}
+	rd, err := ReadCSV(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := rd.ReadPoints(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := rd.GetMetadata().WorldPosition(FindAttribute(read, POSITION.Name), 1)
+	if math.Abs(p[0]-east[0]) > 0.01 || math.Abs(p[2]-east[2]) > 0.01 {
+		t.Errorf("local csv position %v, want %v", p, east)
+	}
+
+	plain, err := NewCenteredFrame(NewMetadata([]Attribute{POSITION}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.ToECEF(origin); err == nil || plain.IsGeoreferenced() {
+		t.Error("frame without CRS converted to ECEF")
+	}
+	if local, _ := plain.ToLocal(origin); local != origin {
+		t.Errorf("frame without CRS moved %v to %v", origin, local)
+	}
+}
@@ -0,0 +1,46 @@
+package potree
+
+import (
+	"bytes"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+type LZ4 struct{}
+
+// EncodeLevel compresses src as an LZ4 frame, level 0 is the fast
+// compressor and levels 1 to 9 use the high compression one.
+func (LZ4) EncodeLevel(dst, src []byte, level int) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	w := lz4.NewWriter(buf)
+	compression := lz4.Fast
+	if level > 0 {
+		if level > 9 {
+			level = 9
+		}
+		compression = lz4.Level1 << uint(level-1)
+	}
+	if err := w.Apply(lz4.CompressionLevelOption(compression)); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (l LZ4) Encode(dst, src []byte) ([]byte, error) {
+	return l.EncodeLevel(dst, src, 0)
+}
+
+func (LZ4) Decode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	_, err := buf.ReadFrom(lz4.NewReader(bytes.NewReader(src)))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	vec3d "github.com/flywave/go3d/float64/vec3"
+)
+
+func compatibleAttributes(a, b []Attribute) error {
+	if len(a) != len(b) {
+		return fmt.Errorf("attribute count differs: %d and %d", len(a), len(b))
+	}
+	for _, attr := range a {
+		other := FindAttribute(b, attr.Name)
+		if other == nil {
+			return fmt.Errorf("attribute %s is missing", attr.Name)
+		}
+		if other.Type != attr.Type || other.NumElements != attr.NumElements {
+			return fmt.Errorf("attribute %s is %s[%d] and %s[%d]", attr.Name, attr.Type, attr.NumElements, other.Type, other.NumElements)
+		}
+	}
+	return nil
+}
+
+func (l *Metadata) tightBounds() AABB {
+	if position := l.Get(POSITION.Name); position != nil && len(position.Min) == 3 && len(position.Max) == 3 {
+		return AABB{Min: [3]float64{position.Min[0], position.Min[1], position.Min[2]}, Max: [3]float64{position.Max[0], position.Max[1], position.Max[2]}}
+	}
+	return l.BoundingBox
+}
+
+// Merge combines archives with the same attribute set into a new archive in
+// opts.Outdir. Positions are re-quantized to the finest scale of the inputs,
+// or the one of opts.Precision, relative to the minimum of the combined
+// bounds and the octree is rebuilt
+// from all points. With opts.CRS the positions are reprojected, merging a
+// single archive rebuilds it in another CRS.
+func Merge(archives []*PotreeArchive, opts *Options) (*PotreeArchive, error) {
+	if len(archives) == 0 {
+		return nil, errors.New("no archives to merge")
+	}
+	for _, arch := range archives {
+		if arch.root == nil {
+			if err := arch.LoadHierarchy(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	first := archives[0].metadata
+	attrs := make([]Attribute, len(first.Attrs))
+	copy(attrs, first.Attrs)
+	meta := NewMetadata(attrs)
+	meta.Name = opts.Name
+	meta.Projection = first.Projection
+	if opts.CRS != "" {
+		crs, err := ParseCRS(opts.CRS)
+		if err != nil {
+			return nil, err
+		}
+		meta.SetCRS(crs)
+	}
+	meta.Encoding = first.Encoding
+	if opts.Encoding != "" {
+		encoding := opts.Encoding
+		meta.Encoding = &encoding
+	}
+	box, scale, err := reprojectBounds(first.tightBounds(), first.Scale, first, meta)
+	if err != nil {
+		return nil, err
+	}
+	meta.Scale = scale
+	for i, arch := range archives[1:] {
+		other := arch.metadata
+		if err := compatibleAttributes(first.Attrs, other.Attrs); err != nil {
+			return nil, fmt.Errorf("archive %d: %v", i+1, err)
+		}
+		if opts.CRS == "" && !sameProjection(first, other) {
+			return nil, fmt.Errorf("archive %d: projection differs", i+1)
+		}
+		otherBox, otherScale, err := reprojectBounds(other.tightBounds(), other.Scale, other, meta)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %v", i+1, err)
+		}
+		for k := 0; k < 3; k++ {
+			meta.Scale[k] = math.Min(meta.Scale[k], otherScale[k])
+		}
+		box = box.Union(otherBox)
+	}
+	if opts.Precision > 0 {
+		step := 2 * opts.Precision
+		meta.Scale = [3]float64{step, step, step}
+	}
+	so := ComputeScaleOffset(vec3d.T(box.Min), vec3d.T(box.Max), vec3d.T(meta.Scale))
+	offset := so.offset
+	meta.Scale = so.scale
+	meta.Offset = &offset
+
+	builder := NewBuilder(meta)
+	for _, arch := range archives {
+		for _, n := range arch.GetNodes() {
+			if n.NumPoints == 0 {
+				continue
+			}
+			loaded := n.Attrs != nil
+			if err := arch.LoadNode(n); err != nil {
+				return nil, err
+			}
+			if err := builder.Add(arch.metadata, n.Attrs); err != nil {
+				return nil, err
+			}
+			if !loaded {
+				n.Attrs, n.Buffer = nil, nil
+			}
+		}
+	}
+
+	root, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	ret := NewArchive(opts.Outdir)
+	ret.SetOptions(opts)
+	ret.SetMetadata(builder.GetMetadata())
+	ret.SetRoot(root)
+	inputs := make([]*Metadata, len(archives))
+	for i, arch := range archives {
+		inputs[i] = arch.metadata
+	}
+	q := inputQuantization(ret.metadata, inputs)
+	ret.quantization = &q
+	if err := ret.Save(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// sameProjection compares the CRS of both archives, projections that can't
+// be parsed are compared as strings. A missing projection matches any.
+func sameProjection(a, b *Metadata) bool {
+	if a.Projection == nil || b.Projection == nil || *a.Projection == *b.Projection {
+		return true
+	}
+	ca, erra := a.GetCRS()
+	cb, errb := b.GetCRS()
+	return erra == nil && errb == nil && ca.Equal(cb)
+}
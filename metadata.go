@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"math"
+	"strings"
 )
 
 const (
@@ -13,6 +15,8 @@ const (
 
 type Metadata struct {
 	Version         string      `json:"version"`
+	Name            string      `json:"name,omitempty"`
+	Description     string      `json:"description,omitempty"`
 	Points          *int64      `json:"points,omitempty"`
 	PointsProcessed *int64      `json:"pointsProcessed,omitempty"`
 	NodesProcessed  *int64      `json:"nodesProcessed,omitempty"`
@@ -34,16 +38,30 @@ func NewMetadata(attributes []Attribute) *Metadata {
 	return ret
 }
 
-func (l *Metadata) readMetadata(data io.ReadSeeker) error {
+// GetCRS parses Projection, the CRS is nil if the archive has none.
+func (l *Metadata) GetCRS() (*CRS, error) {
+	if l.Projection == nil || strings.TrimSpace(*l.Projection) == "" {
+		return nil, nil
+	}
+	return ParseCRS(*l.Projection)
+}
+
+// SetCRS stores crs as WKT in Projection, nil removes it.
+func (l *Metadata) SetCRS(crs *CRS) {
+	if crs == nil {
+		l.Projection = nil
+		return
+	}
+	wkt := crs.WKT()
+	l.Projection = &wkt
+}
+
+func (l *Metadata) readMetadata(data io.Reader) error {
 	jdata, err := ioutil.ReadAll(data)
-	dec := json.NewDecoder(bytes.NewBuffer(jdata))
 	if err != nil {
-		return nil
-	}
-	if err := dec.Decode(l); err != nil {
 		return err
 	}
-	return nil
+	return json.NewDecoder(bytes.NewBuffer(jdata)).Decode(l)
 }
 
 func (l *Metadata) writeMetadata(wr io.Writer) (int, error) {
@@ -88,9 +106,81 @@ func (l *Metadata) Get(name string) *Attribute {
 	return nil
 }
 
+// GetEncoding returns the node encoding, DEFAULT if none is set.
+func (l *Metadata) GetEncoding() string {
+	if l.Encoding != nil && *l.Encoding != "" {
+		return *l.Encoding
+	}
+	return ENCODING_DEFAULT
+}
+
 func (l *Metadata) IsBrotliEncoded() bool {
 	if l.Encoding != nil {
 		return *l.Encoding == "BROTLI"
 	}
 	return false
 }
+
+func (l *Metadata) bytesPerPoint() int {
+	size := 0
+	for _, attribute := range l.Attrs {
+		size += attribute.Size
+	}
+	return size
+}
+
+func (l *Metadata) offsetVector() [3]float64 {
+	if l.Offset != nil {
+		return *l.Offset
+	}
+	return [3]float64{}
+}
+
+// WorldPosition returns the coordinates of point i of a decoded position
+// attribute, positions decode to the same integers for every encoding.
+func (l *Metadata) WorldPosition(position *Attribute, i int) [3]float64 {
+	offset := l.offsetVector()
+	return [3]float64{
+		position.GetFloat64(i, 0)*l.Scale[0] + offset[0],
+		position.GetFloat64(i, 1)*l.Scale[1] + offset[1],
+		position.GetFloat64(i, 2)*l.Scale[2] + offset[2],
+	}
+}
+
+// WorldPositions returns the coordinates of all points of a decoded position
+// attribute as x, y, z triples.
+func (l *Metadata) WorldPositions(position *Attribute) []float64 {
+	ret := make([]float64, 0, position.Len()*3)
+	for i := 0; i < position.Len(); i++ {
+		p := l.WorldPosition(position, i)
+		ret = append(ret, p[0], p[1], p[2])
+	}
+	return ret
+}
+
+// NodePositions returns the positions relative to the minimum of the node
+// box as float32, like the Potree viewer decodes them for rendering.
+func (l *Metadata) NodePositions(n *Node, position *Attribute) []float32 {
+	ret := make([]float32, 0, position.Len()*3)
+	for i := 0; i < position.Len(); i++ {
+		p := l.WorldPosition(position, i)
+		for k := 0; k < 3; k++ {
+			ret = append(ret, float32(p[k]-n.Box.Min[k]))
+		}
+	}
+	return ret
+}
+
+// IntegerPosition quantizes world coordinates with Scale and Offset, an
+// error if they don't fit into int32.
+func (l *Metadata) IntegerPosition(p [3]float64) ([3]int32, error) {
+	var ret [3]int32
+	if err := l.checkPosition(p); err != nil {
+		return ret, err
+	}
+	offset := l.offsetVector()
+	for k := 0; k < 3; k++ {
+		ret[k] = int32(math.Round((p[k] - offset[k]) / l.Scale[k]))
+	}
+	return ret, nil
+}
@@ -3,6 +3,7 @@ package potree
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"strconv"
 )
@@ -25,8 +26,6 @@ type node struct {
 	NumPoints  uint32
 	ByteOffset int64
 	ByteSize   int64
-	AttrStart  int
-	AttrEnd    int
 }
 
 func (n *node) readNode(reader io.Reader) error {
@@ -41,14 +40,36 @@ func (n *node) size() int64 {
 	return n.ByteSize
 }
 
-func (n *node) read(reader io.ReadSeeker) ([]byte, error) {
-	_, err := reader.Seek(n.ByteOffset, io.SeekStart)
-	if err != nil {
-		return nil, err
+// readChunk bounds the memory read allocates ahead of the bytes actually
+// read, so a corrupt size fails with a short read instead of a huge buffer.
+const readChunk = 16 << 20
+
+func (n *node) read(reader io.ReaderAt) ([]byte, error) {
+	if n.ByteOffset < 0 || n.ByteSize < 0 {
+		return nil, fmt.Errorf("invalid range of %d bytes at %d", n.ByteSize, n.ByteOffset)
 	}
-	ret := make([]byte, n.ByteSize)
-	_, err = reader.Read(ret)
-	return ret, err
+	size := n.ByteSize
+	if size > readChunk {
+		size = readChunk
+	}
+	ret := make([]byte, 0, size)
+	for int64(len(ret)) < n.ByteSize {
+		if len(ret) == cap(ret) {
+			size = 2 * int64(cap(ret))
+			if size > n.ByteSize {
+				size = n.ByteSize
+			}
+			ret = append(make([]byte, 0, size), ret...)
+		}
+		k, err := reader.ReadAt(ret[len(ret):cap(ret)], n.ByteOffset+int64(len(ret)))
+		ret = ret[:len(ret)+k]
+		if err == io.EOF && int64(len(ret)) < n.ByteSize {
+			return ret, io.ErrUnexpectedEOF
+		} else if err != nil && err != io.EOF {
+			return ret, err
+		}
+	}
+	return ret, nil
 }
 
 func (n *node) write(off int64, buf []byte, writer io.Writer) error {
@@ -63,40 +84,108 @@ func (n *node) write(off int64, buf []byte, writer io.Writer) error {
 
 func (n *node) compact(attributes []Attribute, isBrotliEncoded bool) []byte {
 	buf := &bytes.Buffer{}
+	packed := make([]Attribute, len(attributes))
+	var order []int
+	if position := FindAttribute(attributes, POSITION.Name); position != nil && isBrotliEncoded {
+		order = mortonOrder(position)
+	}
 	for i := range attributes {
-		attributes[i].pack(isBrotliEncoded)
-		buf.Write(attributes[i].Buffer)
+		packed[i] = attributes[i]
+		if order != nil {
+			packed[i] = attributes[i].Subset(order)
+		}
+		packed[i].pack(isBrotliEncoded)
+	}
+	if isBrotliEncoded {
+		for i := range packed {
+			buf.Write(packed[i].Buffer)
+		}
+		return buf.Bytes()
+	}
+
+	bytesPerPoint := 0
+	for i := range packed {
+		bytesPerPoint += packed[i].Size
+	}
+	numPoints := int(n.NumPoints)
+	buf.Grow(numPoints * bytesPerPoint)
+	for p := 0; p < numPoints; p++ {
+		for i := range packed {
+			size := packed[i].Size
+			buf.Write(packed[i].Buffer[p*size : (p+1)*size])
+		}
 	}
 	return buf.Bytes()
 }
 
-func (n *node) uncompact(data []byte, attributes []Attribute, isBrotliEncoded bool) {
+func (n *node) uncompact(data []byte, attributes []Attribute, isBrotliEncoded bool) ([]Attribute, error) {
+	numPoints := int(n.NumPoints)
+	ret := make([]Attribute, len(attributes))
+	for i := range attributes {
+		a := &attributes[i]
+		if a.GetType() == ATTR_UNDEFINED || a.NumElements <= 0 || a.ElementSize != AttributeTypeSize[a.GetType()] || a.Size != a.NumElements*a.ElementSize {
+			return nil, &ErrSchemaMismatch{Attribute: a.Name, Message: "invalid layout"}
+		}
+	}
+
+	if isBrotliEncoded {
+		offset := 0
+		for i := range attributes {
+			enc := *encodedAttribute(&attributes[i], isBrotliEncoded)
+			size := numPoints * enc.Size
+			if offset+size > len(data) {
+				return nil, fmt.Errorf("attribute %s exceeds decoded buffer of %d bytes", attributes[i].Name, len(data))
+			}
+			enc.Buffer = data[offset : offset+size]
+			enc.unpack(isBrotliEncoded)
+			ret[i] = attributes[i]
+			ret[i].Data = enc.Data
+			if enc.Name == attributes[i].Name {
+				ret[i].Buffer = enc.Buffer
+			}
+			offset += size
+		}
+		if offset != len(data) {
+			return nil, fmt.Errorf("decoded %d bytes, attributes use %d", len(data), offset)
+		}
+		return ret, nil
+	}
+
+	bytesPerPoint := 0
+	for i := range attributes {
+		bytesPerPoint += attributes[i].Size
+	}
+	if numPoints*bytesPerPoint != len(data) {
+		return nil, fmt.Errorf("%d points of %d bytes don't fit buffer of %d bytes", numPoints, bytesPerPoint, len(data))
+	}
 	offset := 0
-	attrOff := n.AttrStart
-	for {
-		size := int(n.NumPoints) * attributes[attrOff].Size
-		attributes[attrOff].Buffer = data[offset : offset+size]
-		attributes[attrOff].unpack(isBrotliEncoded)
-		offset += size
-		attrOff++
-		if offset == len(data) {
-			n.AttrEnd = attrOff
-			return
+	for i := range attributes {
+		ret[i] = attributes[i]
+		size := attributes[i].Size
+		ret[i].Buffer = make([]byte, numPoints*size)
+		for p := 0; p < numPoints; p++ {
+			copy(ret[i].Buffer[p*size:(p+1)*size], data[p*bytesPerPoint+offset:])
 		}
+		ret[i].unpack(isBrotliEncoded)
+		offset += size
 	}
+	return ret, nil
 }
 
-func (n *node) compress(attributes []Attribute) []byte {
+func (n *node) compress(attributes []Attribute, codec nodeCodec, opts *Options) ([]byte, error) {
 	uncomress := n.compact(attributes, true)
-	ctx := &Brotli{}
-	ret := ctx.Encode(nil, uncomress)
-	return ret
+	return codec.encode(uncomress, opts)
 }
 
-func (n *node) uncompress(data []byte, attributes []Attribute) {
-	ctx := &Brotli{}
-	uncomress := ctx.Decode(nil, data)
-	n.uncompact(uncomress, attributes, true)
+func (n *node) uncompress(data []byte, attributes []Attribute, codec nodeCodec) ([]Attribute, error) {
+	if len(data) == 0 {
+		return n.uncompact(nil, attributes, true)
+	}
+	uncomress, err := codec.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return n.uncompact(uncomress, attributes, true)
 }
 
 type Node struct {
@@ -106,6 +195,7 @@ type Node struct {
 	Parent   *Node
 	Childs   [8]*Node
 	Buffer   []byte
+	Attrs    []Attribute
 	genProxy bool
 }
 
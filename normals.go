@@ -0,0 +1,365 @@
+package potree
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// neighbourCacheSize is the decoded size of the nodes EstimateNormals and
+// ClassifyGround keep while moving between neighbouring regions.
+const neighbourCacheSize = 256 << 20
+
+// kdTree answers k nearest neighbour queries over a fixed set of points.
+type kdTree struct {
+	points [][3]float64
+	index  []int
+}
+
+func newKDTree(points [][3]float64) *kdTree {
+	t := &kdTree{points: points, index: make([]int, len(points))}
+	for i := range t.index {
+		t.index[i] = i
+	}
+	t.build(0, len(points), 0)
+	return t
+}
+
+func (t *kdTree) build(lo, hi, axis int) {
+	if hi-lo < 2 {
+		return
+	}
+	sub := t.index[lo:hi]
+	sort.Slice(sub, func(i, j int) bool { return t.points[sub[i]][axis] < t.points[sub[j]][axis] })
+	m := (lo + hi) / 2
+	t.build(lo, m, (axis+1)%3)
+	t.build(m+1, hi, (axis+1)%3)
+}
+
+type neighbour struct {
+	index int
+	dist  float64
+}
+
+// nearest returns the k points closest to p, nearest first.
+func (t *kdTree) nearest(p [3]float64, k int, ret []neighbour) []neighbour {
+	ret = ret[:0]
+	var search func(lo, hi, axis int)
+	search = func(lo, hi, axis int) {
+		if lo >= hi {
+			return
+		}
+		m := (lo + hi) / 2
+		q := t.points[t.index[m]]
+		dist := 0.0
+		for a := 0; a < 3; a++ {
+			dist += (p[a] - q[a]) * (p[a] - q[a])
+		}
+		if len(ret) < k || dist < ret[len(ret)-1].dist {
+			if len(ret) < k {
+				ret = append(ret, neighbour{})
+			}
+			i := len(ret) - 1
+			for ; i > 0 && ret[i-1].dist > dist; i-- {
+				ret[i] = ret[i-1]
+			}
+			ret[i] = neighbour{t.index[m], dist}
+		}
+		d := p[axis] - q[axis]
+		near, far := [2]int{lo, m}, [2]int{m + 1, hi}
+		if d > 0 {
+			near, far = far, near
+		}
+		search(near[0], near[1], (axis+1)%3)
+		if len(ret) < k || d*d < ret[len(ret)-1].dist {
+			search(far[0], far[1], (axis+1)%3)
+		}
+	}
+	search(0, len(t.index), 0)
+	return ret
+}
+
+// smallestEigenvector returns the unit eigenvector of the symmetric matrix a
+// with the smallest eigenvalue, using Jacobi rotations.
+func smallestEigenvector(a [3][3]float64) [3]float64 {
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		diag := a[0][0]*a[0][0] + a[1][1]*a[1][1] + a[2][2]*a[2][2]
+		if off <= 1e-24*diag || off == 0 {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p], a[k][q] = c*akp-s*akq, s*akp+c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k], a[q][k] = c*apk-s*aqk, s*apk+c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p], v[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+	min := 0
+	for i := 1; i < 3; i++ {
+		if a[i][i] < a[min][min] {
+			min = i
+		}
+	}
+	return [3]float64{v[0][min], v[1][min], v[2][min]}
+}
+
+// estimateNormal fits a plane through the neighbours of p and orients its
+// normal towards viewpoint, or upwards without one.
+func estimateNormal(p [3]float64, points [][3]float64, neighbours []neighbour, viewpoint *[3]float64) [3]float64 {
+	if len(neighbours) < 3 {
+		return [3]float64{}
+	}
+	var mean [3]float64
+	for _, nb := range neighbours {
+		for a := 0; a < 3; a++ {
+			mean[a] += points[nb.index][a]
+		}
+	}
+	for a := 0; a < 3; a++ {
+		mean[a] /= float64(len(neighbours))
+	}
+	var cov [3][3]float64
+	for _, nb := range neighbours {
+		q := points[nb.index]
+		for r := 0; r < 3; r++ {
+			for c := r; c < 3; c++ {
+				cov[r][c] += (q[r] - mean[r]) * (q[c] - mean[c])
+			}
+		}
+	}
+	cov[1][0], cov[2][0], cov[2][1] = cov[0][1], cov[0][2], cov[1][2]
+	n := smallestEigenvector(cov)
+
+	up := [3]float64{0, 0, 1}
+	if viewpoint != nil {
+		up = [3]float64{viewpoint[0] - p[0], viewpoint[1] - p[1], viewpoint[2] - p[2]}
+	}
+	if n[0]*up[0]+n[1]*up[1]+n[2]*up[2] < 0 {
+		n = [3]float64{-n[0], -n[1], -n[2]}
+	}
+	return n
+}
+
+// normalRegion is a part of the octree volume whose points belong to owner
+// or its ancestors: a leaf box or an octant of an inner node without child.
+type normalRegion struct {
+	box   AABB
+	owner *Node
+}
+
+func normalRegions(root *Node) []normalRegion {
+	var ret []normalRegion
+	root.Traverse(func(n *Node) bool {
+		if ChildMaskOf(n) == 0 {
+			ret = append(ret, normalRegion{n.Box, n})
+			return true
+		}
+		for idx, child := range n.Childs {
+			if child == nil {
+				ret = append(ret, normalRegion{n.Box.Child(idx), n})
+			}
+		}
+		return true
+	})
+	return ret
+}
+
+// EstimateNormals adds the NORMAL attribute from a principal component
+// analysis of the k nearest neighbours of every point. An existing normal
+// attribute, or a complete NORMAL_X, NORMAL_Y and NORMAL_Z set, is
+// overwritten in place instead. The volume is processed in regions, every leaf box and
+// every octant of an inner node without child, and the neighbours of the
+// points in a region are searched among the points of all nodes within a
+// quarter of the region size, so neighbourhoods span node boundaries.
+// Normals are oriented towards viewpoint if given, otherwise upwards along
+// the third axis. Points with fewer than three neighbours get a zero normal.
+func (b *PotreeArchive) EstimateNormals(k int, viewpoint *[3]float64) error {
+	if k < 3 {
+		return fmt.Errorf("at least 3 neighbours are needed, got %d", k)
+	}
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return err
+		}
+	}
+	axes, err := normalAxes(b.metadata)
+	if err != nil {
+		return err
+	}
+	release, err := b.keepOpen()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	cache := NewNodeCache(neighbourCacheSize)
+
+	normals := make(map[string][]float32)
+	var (
+		points     [][3]float64
+		found      []neighbour
+		tolerance  = b.metadata.Scale
+		neighbours []*Node
+	)
+	for _, region := range normalRegions(b.root) {
+		size := region.box.Size()
+		search := region.box
+		for a := 0; a < 3; a++ {
+			search.Min[a] -= size[a] / 4
+			search.Max[a] += size[a] / 4
+		}
+		center := region.box.Center()
+		var view *[3]float64
+		if viewpoint != nil {
+			view = &[3]float64{viewpoint[0] - center[0], viewpoint[1] - center[1], viewpoint[2] - center[2]}
+		}
+
+		points, neighbours = points[:0], neighbours[:0]
+		b.root.Traverse(func(n *Node) bool {
+			if !search.Intersects(n.Box) {
+				return true
+			}
+			if n.NumPoints > 0 {
+				neighbours = append(neighbours, n)
+			}
+			return true
+		})
+		for _, n := range neighbours {
+			position, err := b.cachedPosition(cache, n)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < position.Len(); i++ {
+				p := b.metadata.WorldPosition(position, i)
+				if search.Contains(p, tolerance) {
+					points = append(points, [3]float64{p[0] - center[0], p[1] - center[1], p[2] - center[2]})
+				}
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		tree := newKDTree(points)
+
+		for n := region.owner; n != nil; n = n.Parent {
+			if n.NumPoints == 0 {
+				continue
+			}
+			position, err := b.cachedPosition(cache, n)
+			if err != nil {
+				return err
+			}
+			values := normals[n.Name]
+			for i := 0; i < position.Len(); i++ {
+				p := b.metadata.WorldPosition(position, i)
+				if !region.box.Contains(p, tolerance) {
+					continue
+				}
+				if values == nil {
+					values = make([]float32, int(n.NumPoints)*3)
+					normals[n.Name] = values
+				}
+				local := [3]float64{p[0] - center[0], p[1] - center[1], p[2] - center[2]}
+				found = tree.nearest(local, k, found)
+				normal := estimateNormal(local, points, found, view)
+				for a := 0; a < 3; a++ {
+					values[i*3+a] = float32(normal[a])
+				}
+			}
+		}
+	}
+	release()
+	cache.Purge()
+
+	schema := append([]Attribute(nil), b.metadata.Attrs...)
+	if len(axes) == 0 {
+		schema = append(schema, NORMAL)
+		axes[NORMAL.Name] = -1
+	}
+	for i := range schema {
+		if _, ok := axes[schema[i].Name]; ok {
+			schema[i].Buffer, schema[i].Data, schema[i].Min, schema[i].Max = nil, nil, nil, nil
+		}
+	}
+	update := func(meta *Metadata) { meta.Attrs = schema }
+	return b.rewriteNodes(update, func(n *Node) error {
+		values, ok := normals[n.Name]
+		if !ok {
+			values = make([]float32, int(n.NumPoints)*3)
+		}
+		attrs := make([]Attribute, len(schema))
+		for i := range schema {
+			axis, ok := axes[schema[i].Name]
+			if !ok {
+				a := FindAttribute(n.Attrs, schema[i].Name)
+				if a == nil {
+					return &ErrSchemaMismatch{Node: n.Name, Attribute: schema[i].Name, Message: "missing from node"}
+				}
+				attrs[i] = *a
+				continue
+			}
+			attrs[i] = NewPoints(schema[i:i+1], int(n.NumPoints))[0]
+			for p := 0; p < int(n.NumPoints); p++ {
+				for e := 0; e < 3; e++ {
+					if axis < 0 {
+						attrs[i].SetFloat64(p, e, float64(values[p*3+e]))
+					} else if axis == e {
+						attrs[i].SetFloat64(p, 0, float64(values[p*3+e]))
+					}
+				}
+			}
+			extendAttributeRanges(b.metadata, attrs[i:i+1])
+		}
+		n.Attrs = attrs
+		return nil
+	})
+}
+
+// normalAxes maps the normal attributes of meta to the axis they hold, -1
+// for all three. Incomplete NORMAL_X, NORMAL_Y and NORMAL_Z sets are
+// rejected rather than completed.
+func normalAxes(meta *Metadata) (map[string]int, error) {
+	axes := make(map[string]int)
+	if a := meta.Get(NORMAL.Name); a != nil {
+		if a.NumElements != 3 {
+			return nil, &ErrSchemaMismatch{Attribute: a.Name, Message: fmt.Sprintf("%d elements, normals need 3", a.NumElements)}
+		}
+		axes[a.Name] = -1
+	}
+	var missing []string
+	for e, attr := range []Attribute{NORMAL_X, NORMAL_Y, NORMAL_Z} {
+		if a := meta.Get(attr.Name); a == nil {
+			missing = append(missing, attr.Name)
+		} else if a.NumElements != 1 {
+			return nil, &ErrSchemaMismatch{Attribute: a.Name, Message: fmt.Sprintf("%d elements, want 1", a.NumElements)}
+		} else {
+			axes[a.Name] = e
+		}
+	}
+	if len(missing) == 1 || len(missing) == 2 {
+		return nil, &ErrSchemaMismatch{Attribute: missing[0], Message: "incomplete normal components"}
+	}
+	return axes, nil
+}
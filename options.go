@@ -4,10 +4,52 @@ const (
 	ENCODING_BROTLI       = "BROTLI"
 	ENCODING_DEFAULT      = "DEFAULT"
 	ENCODING_UNCOMPRESSED = "UNCOMPRESSED"
+	// ZSTD and LZ4 are not understood by the Potree viewer.
+	ENCODING_ZSTD = "ZSTD"
+	ENCODING_LZ4  = "LZ4"
 )
 
+func validEncoding(encoding string) bool {
+	switch encoding {
+	case ENCODING_DEFAULT, ENCODING_UNCOMPRESSED:
+		return true
+	}
+	_, ok := nodeCodecs[encoding]
+	return ok
+}
+
 type Options struct {
+	// Encoding of the written nodes, the encoding of the metadata is kept
+	// if empty. UNCOMPRESSED has the same layout as DEFAULT.
 	Encoding string
+	// BrotliQuality from 1 to 11, zero uses DefaultCompression.
+	BrotliQuality int
+	// BrotliWindow is the base 2 logarithm of the window size from 10 to 24,
+	// zero chooses it from the quality.
+	BrotliWindow int
+	// ZstdLevel from 1 to 22, zero uses the default speed.
+	ZstdLevel int
+	// LZ4Level from 1 to 9 uses high compression, zero the fast compressor.
+	LZ4Level int
 	Outdir   string
 	Name     string
+	// Concurrency is the number of goroutines encoding and decoding nodes,
+	// zero or less uses one per CPU.
+	Concurrency int
+	// MemoryBudget in bytes enables out of core conversion, points are
+	// spilled to chunk files in TempDir and the chunks are indexed so that
+	// the points held in memory stay within the budget.
+	MemoryBudget int64
+	TempDir      string
+	// CRS the archive is reprojected into by Convert and Merge, in any form
+	// accepted by ParseCRS. Inputs without CRS are taken to be in it.
+	CRS string
+	// Precision is the largest rounding error of positions in world units
+	// Convert and Merge may introduce, the scale is twice it unless the
+	// bounds need a coarser one to fit into int32. Zero keeps the finest
+	// scale of the inputs.
+	Precision float64
+	// HierarchyStepSize is the number of levels per hierarchy.bin chunk,
+	// smaller steps mean smaller but more requests while browsing.
+	HierarchyStepSize int
 }
@@ -0,0 +1,150 @@
+package potree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+var plyTypeName = map[AttributeType]string{
+	ATTR_INT8:   "char",
+	ATTR_INT16:  "short",
+	ATTR_INT32:  "int",
+	ATTR_INT64:  "double",
+	ATTR_UINT8:  "uchar",
+	ATTR_UINT16: "ushort",
+	ATTR_UINT32: "uint",
+	ATTR_UINT64: "double",
+	ATTR_FLOAT:  "float",
+	ATTR_DOUBLE: "double",
+}
+
+func plyPropertyName(a *Attribute, element int) string {
+	if a.Name == COLOR.Name {
+		return []string{"red", "green", "blue"}[element]
+	}
+	if a.Name == NORMAL.Name {
+		return []string{"nx", "ny", "nz"}[element]
+	}
+	name := strings.Replace(a.Name, " ", "_", -1)
+	if a.NumElements > 1 {
+		name = fmt.Sprintf("%s_%d", name, element)
+	}
+	return name
+}
+
+// WritePLY writes the points as binary little endian PLY with double
+// precision world coordinates. Colors are written as uchar, 16 bit colors
+// are scaled down.
+func WritePLY(w io.Writer, meta *Metadata, points []Attribute) error {
+	return writePLY(w, meta, points, nil)
+}
+
+// WriteLocalPLY writes the points like WritePLY but with float positions in
+// frame, which is recorded in comments of the header.
+func WriteLocalPLY(w io.Writer, frame *LocalFrame, points []Attribute) error {
+	return writePLY(w, frame.meta, points, frame)
+}
+
+func writePLY(w io.Writer, meta *Metadata, points []Attribute, frame *LocalFrame) error {
+	position := FindAttribute(points, POSITION.Name)
+	if position == nil {
+		return errors.New("points have no position attribute")
+	}
+	numPoints := position.Len()
+
+	colorShift := uint(0)
+	if rgb := FindAttribute(points, COLOR.Name); rgb != nil {
+		for i := 0; i < numPoints*3; i++ {
+			if rgb.GetFloat64(i/3, i%3) > 255 {
+				colorShift = 8
+				break
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "ply")
+	fmt.Fprintln(bw, "format binary_little_endian 1.0")
+	fmt.Fprintln(bw, "comment generated by go-potree")
+	positionType := "double"
+	if frame != nil {
+		positionType = "float"
+		for _, line := range frame.headerLines() {
+			fmt.Fprintf(bw, "comment %s\n", line)
+		}
+	}
+	fmt.Fprintf(bw, "element vertex %d\n", numPoints)
+	fmt.Fprintf(bw, "property %s x\n", positionType)
+	fmt.Fprintf(bw, "property %s y\n", positionType)
+	fmt.Fprintf(bw, "property %s z\n", positionType)
+	for i := range points {
+		a := &points[i]
+		if a.Name == POSITION.Name {
+			continue
+		}
+		tp := plyTypeName[a.GetType()]
+		if a.Name == COLOR.Name {
+			tp = "uchar"
+		}
+		for e := 0; e < a.NumElements; e++ {
+			fmt.Fprintf(bw, "property %s %s\n", tp, plyPropertyName(a, e))
+		}
+	}
+	fmt.Fprintln(bw, "end_header")
+
+	le := binary.LittleEndian
+	buf := make([]byte, 8)
+	for i := 0; i < numPoints; i++ {
+		p := meta.WorldPosition(position, i)
+		if frame != nil {
+			local, err := frame.ToLocal(p)
+			if err != nil {
+				return err
+			}
+			for k := 0; k < 3; k++ {
+				le.PutUint32(buf, math.Float32bits(float32(local[k])))
+				bw.Write(buf[:4])
+			}
+		} else {
+			for k := 0; k < 3; k++ {
+				le.PutUint64(buf, math.Float64bits(p[k]))
+				bw.Write(buf)
+			}
+		}
+		for j := range points {
+			a := &points[j]
+			if a.Name == POSITION.Name {
+				continue
+			}
+			for e := 0; e < a.NumElements; e++ {
+				v := a.GetFloat64(i, e)
+				if a.Name == COLOR.Name {
+					bw.WriteByte(uint8(uint16(v) >> colorShift))
+					continue
+				}
+				switch a.GetType() {
+				case ATTR_INT8, ATTR_UINT8:
+					bw.WriteByte(uint8(int64(v)))
+				case ATTR_INT16, ATTR_UINT16:
+					le.PutUint16(buf, uint16(int64(v)))
+					bw.Write(buf[:2])
+				case ATTR_INT32, ATTR_UINT32:
+					le.PutUint32(buf, uint32(int64(v)))
+					bw.Write(buf[:4])
+				case ATTR_FLOAT:
+					le.PutUint32(buf, math.Float32bits(float32(v)))
+					bw.Write(buf[:4])
+				default:
+					le.PutUint64(buf, math.Float64bits(v))
+					bw.Write(buf)
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,62 @@
+package potree
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+func workerCount(concurrency, n int) int {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+	return concurrency
+}
+
+// forEach calls fn for every index in [0, n) on up to concurrency
+// goroutines. Work stops being handed out once ctx is done or fn fails; the
+// error of the lowest failing index is returned so failures are reported
+// the same way regardless of scheduling.
+func forEach(ctx context.Context, concurrency, n int, fn func(i int) error) error {
+	if n == 0 {
+		return ctx.Err()
+	}
+	errs := make([]error, n)
+	jobs := make(chan int)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for w := workerCount(concurrency, n); w > 0; w-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if errs[i] = fn(i); errs[i] != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	canceled := false
+	for i := 0; i < n && !canceled; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			canceled = true
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
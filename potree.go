@@ -1,12 +1,15 @@
 package potree
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"sort"
-	"strconv"
+	"sync"
 )
 
 const (
@@ -32,6 +35,19 @@ func (s chunknodelist) Less(i, j int) bool {
 	}
 }
 
+type nodeNames []string
+
+func (s nodeNames) Len() int { return len(s) }
+
+func (s nodeNames) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s nodeNames) Less(i, j int) bool {
+	if len(s[i]) != len(s[j]) {
+		return len(s[i]) < len(s[j])
+	}
+	return s[i] < s[j]
+}
+
 type hierarchyChunk struct {
 	name  string
 	nodes chunknodelist
@@ -45,14 +61,21 @@ func (c *hierarchyChunk) sortNodes() {
 	sort.Sort(c.nodes)
 }
 
+// PotreeArchive reads and writes an archive directory. After Open, FetchNode,
+// DecodeNode, ReadNode and Query may be called from multiple goroutines at
+// once; everything else, including LoadNode which stores the points on the
+// node, must not run concurrently with other calls.
 type PotreeArchive struct {
 	path         string
 	root         *Node
 	nodeMaps     map[string]*Node
 	metadata     *Metadata
+	mu           sync.RWMutex
 	octree       *os.File
 	octreeOffset int64
-	attrOffset   int
+	options      Options
+	cache        *NodeCache
+	quantization *Quantization
 }
 
 func NewArchive(path string) *PotreeArchive {
@@ -67,41 +90,328 @@ func (b *PotreeArchive) SetRoot(root *Node) {
 	b.root = root
 }
 
-func (b *PotreeArchive) Load() error {
+// SetOptions sets how the archive is written, Outdir and Name are ignored.
+func (b *PotreeArchive) SetOptions(opts *Options) {
+	b.options = *opts
+}
+
+func (b *PotreeArchive) GetOptions() *Options {
+	return &b.options
+}
+
+// SetConcurrency sets the number of goroutines decoding or encoding nodes,
+// zero or less uses one per CPU.
+func (b *PotreeArchive) SetConcurrency(n int) {
+	b.options.Concurrency = n
+}
+
+// SetCache attaches a cache used by ReadNode and Query, nodes rewritten
+// through the archive are dropped from it.
+func (b *PotreeArchive) SetCache(c *NodeCache) {
+	b.cache = c
+}
+
+func (b *PotreeArchive) GetCache() *NodeCache {
+	return b.cache
+}
+
+func (b *PotreeArchive) GetMetadata() *Metadata {
+	return b.metadata
+}
+
+func (b *PotreeArchive) GetRoot() *Node {
+	return b.root
+}
+
+func (b *PotreeArchive) GetNode(name string) *Node {
+	return b.nodeMaps[name]
+}
+
+func (b *PotreeArchive) GetNodes() []*Node {
+	names := make([]string, 0, len(b.nodeMaps))
+	for name := range b.nodeMaps {
+		names = append(names, name)
+	}
+	sort.Sort(nodeNames(names))
+	ret := make([]*Node, len(names))
+	for i, name := range names {
+		ret[i] = b.nodeMaps[name]
+	}
+	return ret
+}
+
+func (b *PotreeArchive) LoadHierarchy() error {
 	err := b.readMetadata()
 	if err != nil {
 		return err
 	}
-	err = b.readHierarchy()
+	return b.readHierarchy()
+}
+
+// Open loads the hierarchy if needed and keeps octree.bin open for reading
+// until Close.
+func (b *PotreeArchive) Open() error {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.octree != nil {
+		return nil
+	}
+	return b.openOctree()
+}
+
+// Close closes octree.bin once reads in progress are done, later reads open
+// it per node.
+func (b *PotreeArchive) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closeOctree()
+}
+
+// keepOpen keeps octree.bin open during a bulk read unless the archive is
+// already open and returns the function releasing it.
+func (b *PotreeArchive) keepOpen() (func() error, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.octree != nil {
+		return func() error { return nil }, nil
+	}
+	if err := b.openOctree(); err != nil {
+		return nil, err
+	}
+	return b.Close, nil
+}
+
+// FetchNode reads the encoded payload of n from octree.bin.
+func (b *PotreeArchive) FetchNode(n *Node) ([]byte, error) {
+	if n.ByteSize == 0 {
+		return nil, nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	f := b.octree
+	if f == nil {
+		var err error
+		if f, err = openArchiveFile(b.getOctreePath()); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+	// payloads past the end of octree.bin fail with a short read
+	data, err := n.read(f)
+	if err != nil {
+		return nil, &ErrCorruptNode{Name: n.Name, Offset: n.ByteOffset, Err: err}
+	}
+	return data, nil
+}
+
+func (b *PotreeArchive) LoadNode(n *Node) error {
+	release, err := b.keepOpen()
 	if err != nil {
 		return err
 	}
-	for _, n := range b.nodeMaps {
-		err = b.unpackNode(n)
+	defer release()
+	return b.unpackNode(n)
+}
+
+// ReadNode returns the decoded points of n without keeping them on the
+// node, going through the cache if one is set.
+func (b *PotreeArchive) ReadNode(n *Node) ([]Attribute, error) {
+	if n.Attrs != nil {
+		return n.Attrs, nil
+	}
+	if b.cache != nil {
+		if attrs, ok := b.cache.Get(n.Name); ok {
+			return attrs, nil
+		}
+	}
+	data := n.Buffer
+	if data == nil {
+		var err error
+		if data, err = b.FetchNode(n); err != nil {
+			return nil, err
+		}
+	}
+	attrs, err := b.DecodeNode(n, data)
+	if err != nil {
+		return nil, err
+	}
+	if b.cache != nil {
+		b.cache.Put(n.Name, attrs)
+	}
+	return attrs, nil
+}
+
+func (b *PotreeArchive) Load() error {
+	return b.LoadContext(context.Background())
+}
+
+// LoadContext loads the hierarchy and decodes all nodes in parallel.
+func (b *PotreeArchive) LoadContext(ctx context.Context) error {
+	err := b.LoadHierarchy()
+	if err != nil {
+		return err
+	}
+	release, err := b.keepOpen()
+	if err != nil {
+		return err
+	}
+	defer release()
+	nodes := b.GetNodes()
+	return forEach(ctx, b.options.Concurrency, len(nodes), func(i int) error {
+		return b.unpackNode(nodes[i])
+	})
+}
+
+func (b *PotreeArchive) Save() error {
+	if b.root == nil {
+		return errors.New("archive has no root node")
+	}
+	err := os.MkdirAll(b.path, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	var files []*os.File
+	for _, p := range []string{b.getOctreePath(), b.getHierarchyPath(), b.getMetadataPath()} {
+		f, err := os.Create(p)
 		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
 			return err
 		}
+		files = append(files, f)
 	}
-	return nil
+	err = b.Write(files[0], files[1], files[2])
+	for _, f := range files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
-func (b *PotreeArchive) Save() error {
+// Write encodes the tree below the root node into the three archive files.
+func (b *PotreeArchive) Write(octree, hierarchy, metadata io.Writer) error {
+	return b.WriteContext(context.Background(), octree, hierarchy, metadata)
+}
+
+// WriteContext is Write with nodes encoded in parallel, payloads are written
+// in the same order as by a sequential write.
+func (b *PotreeArchive) WriteContext(ctx context.Context, octree, hierarchy, metadata io.Writer) error {
+	if b.root == nil {
+		return errors.New("archive has no root node")
+	}
+	err := b.applyEncoding()
+	if err != nil {
+		return err
+	}
+	err = b.encodeNodes(ctx, b.root)
+	if err != nil {
+		return err
+	}
 	b.octreeOffset = 0
-	err := b.writeOctree(b.root)
+	b.nodeMaps = make(map[string]*Node)
+	err = b.writeOctree(b.root, octree)
 	if err != nil {
 		return err
 	}
-	err = b.writeHierarchy()
+	err = b.writeHierarchy(hierarchy)
 	if err != nil {
 		return err
 	}
-	_, err = b.writeMetadata()
+	_, err = b.writeMetadata(metadata)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// appendNodes encodes the nodes and appends their payloads to octree.bin.
+// Payloads they had before stay in the file until it is compacted.
+func (b *PotreeArchive) appendNodes(nodes []*Node) error {
+	f, err := os.OpenFile(b.getOctreePath(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	b.octreeOffset = info.Size()
+	for _, n := range nodes {
+		n.Buffer = nil
+		err = b.writeOctreeNode(n, f)
+		if err != nil {
+			break
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeIndex rewrites hierarchy.bin and metadata.json from the nodes in
+// memory.
+func (b *PotreeArchive) writeIndex() error {
+	_, err := b.commitIndex("")
+	return err
+}
+
+// commitIndex writes hierarchy.bin and metadata.json to temporary files and
+// renames them over the old ones once both are complete, after renaming
+// octreeTmp over octree.bin if given. It reports whether octree.bin was
+// replaced: from then on the nodes in memory describe the new file even if
+// renaming the index fails, and writeIndex can be retried.
+func (b *PotreeArchive) commitIndex(octreeTmp string) (bool, error) {
+	hierarchyTmp := b.getHierarchyPath() + ".tmp"
+	metadataTmp := b.getMetadataPath() + ".tmp"
+	err := createFile(hierarchyTmp, b.writeHierarchy)
+	if err == nil {
+		err = createFile(metadataTmp, func(w io.Writer) error {
+			_, err := b.writeMetadata(w)
+			return err
+		})
+	}
+	if err == nil && octreeTmp != "" {
+		err = b.replaceOctree(octreeTmp)
+	}
+	if err != nil {
+		for _, p := range []string{octreeTmp, hierarchyTmp, metadataTmp} {
+			if p != "" {
+				os.Remove(p)
+			}
+		}
+		return false, err
+	}
+	replaced := octreeTmp != ""
+	if err := os.Rename(hierarchyTmp, b.getHierarchyPath()); err != nil {
+		os.Remove(hierarchyTmp)
+		os.Remove(metadataTmp)
+		return replaced, err
+	}
+	return replaced, os.Rename(metadataTmp, b.getMetadataPath())
+}
+
+// createFile creates p and fills it with write.
+func createFile(p string, write func(w io.Writer) error) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func (b *PotreeArchive) getMetadataPath() string {
 	return path.Join(b.path, MetadataName)
 }
@@ -115,11 +425,7 @@ func (b *PotreeArchive) getOctreePath() string {
 }
 
 func (b *PotreeArchive) readMetadata() error {
-	p := b.getMetadataPath()
-	if !FileExists(p) {
-		return errors.New("metadata.json not found")
-	}
-	f, err := os.Open(p)
+	f, err := openArchiveFile(b.getMetadataPath())
 	if err != nil {
 		return err
 	}
@@ -127,11 +433,17 @@ func (b *PotreeArchive) readMetadata() error {
 	return b.metadata.readMetadata(f)
 }
 
-func (b *PotreeArchive) writeMetadata() (int, error) {
-	p := b.getMetadataPath()
-	f, err := os.Open(p)
-	if err != nil {
-		return -1, err
+func (b *PotreeArchive) writeMetadata(f io.Writer) (int, error) {
+	numPoints := int64(0)
+	for _, n := range b.nodeMaps {
+		numPoints += int64(n.NumPoints)
+	}
+	b.metadata.Version = POTREE_VERSION
+	b.metadata.Points = &numPoints
+	b.metadata.BytesPerPoint = b.metadata.bytesPerPoint()
+	if b.metadata.Encoding == nil {
+		encoding := ENCODING_DEFAULT
+		b.metadata.Encoding = &encoding
 	}
 	return b.metadata.writeMetadata(f)
 }
@@ -146,72 +458,6 @@ func (b *PotreeArchive) checkHierarchy() error {
 	return nil
 }
 
-func nodeExists(nodes []*Node, node *Node) bool {
-	for _, e := range nodes {
-		if e != nil && e.Name == node.Name {
-			return true
-		}
-	}
-	return false
-}
-
-func (b *PotreeArchive) parseNode(n *Node, buffer io.ReadSeeker, chunkSize int64, nodes []*Node) {
-	nodes[0] = n
-
-	for i, current := range nodes {
-		if current == nil {
-			current = &Node{}
-			current.Name = n.Name
-		}
-		temp := &node{}
-		temp.readNode(io.LimitReader(buffer, chunkSize))
-
-		current.NumPoints = temp.NumPoints
-
-		if current.Type == NT_PROXY {
-			current.ByteOffset = temp.ByteOffset
-			current.ByteSize = temp.ByteSize
-			b.nodeMaps[current.Name] = current
-			nodes[i] = current
-		} else {
-			current.ByteOffset = temp.ByteOffset
-			current.ByteSize = temp.ByteSize
-		}
-
-		current.Type = temp.Type
-
-		if current.Type == NT_PROXY {
-			if current.Name != n.Name {
-				buffer.Seek(current.ByteOffset, io.SeekStart)
-				_child_num_nodes := int(current.ByteSize / BytesPerNode)
-				if _child_num_nodes == 0 {
-					continue
-				}
-				_nodes := make([]*Node, _child_num_nodes)
-				b.parseNode(current, buffer, current.ByteSize, _nodes)
-			}
-			continue
-		}
-
-		for child_index := 0; child_index < 8; child_index++ {
-			child_exists := ((1 << uint32(child_index)) & uint32(current.ChildMask)) != 0
-			if !child_exists {
-				continue
-			}
-
-			child_name := current.Name + strconv.Itoa(child_index)
-			child := &Node{}
-			child.Name = child_name
-			child.Parent = n
-
-			if !nodeExists(nodes, child) {
-				n.Childs[child_index] = child
-			}
-			b.nodeMaps[child.Name] = child
-		}
-	}
-}
-
 func (b *PotreeArchive) gatherChunk(start *Node, levels int) hierarchyChunk {
 	startLevel := len(start.Name) - 1
 
@@ -227,7 +473,7 @@ func (b *PotreeArchive) gatherChunk(start *Node, levels int) hierarchyChunk {
 
 		chunk.nodes = append(chunk.nodes, node)
 
-		childLevel := len(start.Name)
+		childLevel := node.Level() + 1
 		if childLevel <= startLevel+levels {
 			for _, child := range node.Childs {
 				if child == nil {
@@ -274,6 +520,11 @@ func (b *PotreeArchive) writeHierarchyChunk(c *hierarchyChunk, offset int64, buf
 		isProxy := n.Level() == chunkLevel+hierarchyStepSize
 
 		n.ChildMask = ChildMaskOf(n)
+		if n.ChildMask == 0 {
+			n.Type = NT_LEAF
+		} else {
+			n.Type = NT_NORMAL
+		}
 
 		if isProxy {
 			targetChunkIndex := chunkPointers[n.Name]
@@ -312,15 +563,21 @@ func (b *PotreeArchive) writeNode(n *Node, buffer io.Writer) error {
 	return nil
 }
 
-func (b *PotreeArchive) writeHierarchy() error {
-	hierarchy_file := b.getHierarchyPath()
-	f, err := os.Open(hierarchy_file)
-	if err != nil {
-		return err
+// hierarchyStepSize returns the step size set in the options, otherwise the
+// one the archive was written with.
+func (b *PotreeArchive) hierarchyStepSize() int {
+	if b.options.HierarchyStepSize > 0 {
+		return b.options.HierarchyStepSize
 	}
-	defer f.Close()
+	if b.metadata.Hierarchy != nil && b.metadata.Hierarchy.StepSize > 0 {
+		return int(b.metadata.Hierarchy.StepSize)
+	}
+	return HierarchyStepSize
+}
 
-	chunks := b.createHierarchyChunks(HierarchyStepSize)
+func (b *PotreeArchive) writeHierarchy(f io.Writer) error {
+	stepSize := b.hierarchyStepSize()
+	chunks := b.createHierarchyChunks(stepSize)
 
 	chunkPointers := make(map[string]int)
 	chunkByteOffsets := make([]int64, len(chunks))
@@ -343,16 +600,24 @@ func (b *PotreeArchive) writeHierarchy() error {
 		c.sortNodes()
 		si := c.chunkSize()
 
-		err := b.writeHierarchyChunk(&c, offset, f, HierarchyStepSize, chunks, chunkByteOffsets, chunkPointers)
+		err := b.writeHierarchyChunk(&c, offset, f, stepSize, chunks, chunkByteOffsets, chunkPointers)
 		if err != nil {
 			return err
 		}
 		offset += si
 	}
 
+	depth := 0
+	for _, n := range b.nodeMaps {
+		if n.Level() > depth {
+			depth = n.Level()
+		}
+	}
+
 	hierarchy := &Hierarchy{}
-	hierarchy.StepSize = HierarchyStepSize
+	hierarchy.StepSize = int64(stepSize)
 	hierarchy.FirstChunkSize = int64(len(chunks[0].nodes) * BytesPerNode)
+	hierarchy.Depth = &depth
 
 	b.metadata.Hierarchy = hierarchy
 	return nil
@@ -365,58 +630,68 @@ func (b *PotreeArchive) readHierarchy() error {
 			return err
 		}
 	}
-	if b.metadata.Hierarchy != nil {
-		first_chunk_size := b.metadata.Hierarchy.FirstChunkSize
-		hierarchy_file := b.getHierarchyPath()
-		if !FileExists(hierarchy_file) {
-			return errors.New("hierarchy.bin not found")
-		}
-		f, err := os.Open(hierarchy_file)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		b.root = &Node{}
-		b.root.Type = NT_PROXY
+	if b.metadata.Hierarchy == nil {
+		return &ErrCorruptHierarchy{Err: errors.New("metadata.json has no hierarchy")}
+	}
+	f, err := openArchiveFile(b.getHierarchyPath())
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
 
-		numNodes := int(first_chunk_size / BytesPerNode)
+	b.root = &Node{Name: "r", Box: b.metadata.BoundingBox}
+	b.nodeMaps = map[string]*Node{b.root.Name: b.root}
 
-		_nodes := make([]*Node, numNodes)
-		b.nodeMaps = make(map[string]*Node)
-		f.Seek(0, io.SeekStart)
-		b.parseNode(b.root, f, first_chunk_size, _nodes)
+	parser := newHierarchyParser(data, b.nodeMaps)
+	parser.parse(b.root, 0, b.metadata.Hierarchy.FirstChunkSize)
+	if len(parser.errs) > 0 {
+		return parser.errs[0]
 	}
 	return nil
 }
 
 func (b *PotreeArchive) openOctree() error {
-	p := b.getOctreePath()
-	if !FileExists(p) {
-		return errors.New("octree.bin not found!")
-	}
 	var err error
-	b.octree, err = os.Open(p)
-	if err != nil {
-		return err
-	}
-	return nil
+	b.octree, err = openArchiveFile(b.getOctreePath())
+	return err
 }
 
 func (b *PotreeArchive) closeOctree() error {
 	if b.octree != nil {
-		return b.octree.Close()
+		err := b.octree.Close()
+		b.octree = nil
+		return err
 	}
 	return nil
 }
 
-func (b *PotreeArchive) writeOctree(node *Node) error {
-	err := b.writeOctreeNode(node)
+// replaceOctree renames tmp over octree.bin, reopening it if the archive is
+// open so that reads don't go to the replaced file. If reopening fails reads
+// open the file per node.
+func (b *PotreeArchive) replaceOctree(tmp string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reopen := b.octree != nil
+	b.closeOctree()
+	err := os.Rename(tmp, b.getOctreePath())
+	if reopen {
+		b.openOctree()
+	}
+	return err
+}
+
+func (b *PotreeArchive) writeOctree(node *Node, w io.Writer) error {
+	err := b.writeOctreeNode(node, w)
 	if err != nil {
 		return err
 	}
 	for _, c := range node.Childs {
 		if c != nil {
-			err := b.writeOctree(c)
+			err := b.writeOctree(c, w)
 			if err != nil {
 				return err
 			}
@@ -425,50 +700,154 @@ func (b *PotreeArchive) writeOctree(node *Node) error {
 	return nil
 }
 
-func (b *PotreeArchive) writeOctreeNode(node *Node) error {
-	if b.octree == nil {
-		err := b.openOctree()
-		if err != nil {
-			return err
-		}
+// applyEncoding switches the metadata to the encoding of the options,
+// payloads in the old encoding are decoded so that they are encoded again.
+func (b *PotreeArchive) applyEncoding() error {
+	encoding := b.options.Encoding
+	if encoding == "" || encoding == b.metadata.GetEncoding() {
+		return nil
 	}
-	if node.Buffer == nil {
-		if b.metadata.IsBrotliEncoded() {
-			node.Buffer = node.compress(b.metadata.Attrs[node.AttrStart:node.AttrEnd])
-		} else {
-			node.Buffer = node.compact(b.metadata.Attrs[node.AttrStart:node.AttrEnd], false)
+	if !validEncoding(encoding) {
+		return &ErrUnsupportedEncoding{Encoding: encoding}
+	}
+	var err error
+	b.root.Traverse(func(n *Node) bool {
+		if n.Attrs == nil && n.Buffer != nil {
+			if n.Attrs, err = b.DecodeNode(n, n.Buffer); err != nil {
+				return false
+			}
 		}
+		n.Buffer = nil
+		return true
+	})
+	if err != nil {
+		return err
 	}
-	node.ByteOffset = b.octreeOffset
-	node.ByteSize = int64(len(node.Buffer))
-
-	b.octreeOffset += node.ByteSize
-	return node.write(b.octreeOffset, node.Buffer, b.octree)
+	b.metadata.Encoding = &encoding
+	return nil
 }
 
-func (b *PotreeArchive) readOctreeNode(node *Node) error {
-	if b.metadata == nil {
-		err := b.readMetadata()
-		if err != nil {
-			return err
+// encodeNodes fills the Buffer of every node below root that has none.
+func (b *PotreeArchive) encodeNodes(ctx context.Context, root *Node) error {
+	var nodes []*Node
+	root.Traverse(func(n *Node) bool {
+		if n.Buffer == nil {
+			nodes = append(nodes, n)
 		}
-	}
-	if b.octree == nil {
-		err := b.openOctree()
+		return true
+	})
+	return forEach(ctx, b.options.Concurrency, len(nodes), func(i int) error {
+		buf, err := b.encodeNode(nodes[i])
 		if err != nil {
 			return err
 		}
+		nodes[i].Buffer = buf
+		return nil
+	})
+}
+
+func (b *PotreeArchive) schemaAttributes(node *Node) ([]Attribute, error) {
+	return b.orderAttributes(node.Name, node.Attrs)
+}
+
+// orderAttributes returns the attributes of the named node in schema order.
+func (b *PotreeArchive) orderAttributes(name string, attrs []Attribute) ([]Attribute, error) {
+	ret := make([]Attribute, len(b.metadata.Attrs))
+	for i := range b.metadata.Attrs {
+		a := FindAttribute(attrs, b.metadata.Attrs[i].Name)
+		if a == nil {
+			return nil, &ErrSchemaMismatch{Node: name, Attribute: b.metadata.Attrs[i].Name, Message: "missing"}
+		}
+		ret[i] = *a
+	}
+	return ret, nil
+}
+
+func (b *PotreeArchive) encodeNode(node *Node) ([]byte, error) {
+	attrs, err := b.schemaAttributes(node)
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs) > 0 {
+		node.NumPoints = uint32(attrs[0].Len())
+	}
+	encoding := b.metadata.GetEncoding()
+	if codec, ok := nodeCodecs[encoding]; ok {
+		return node.compress(attrs, codec, &b.options)
+	}
+	switch encoding {
+	case ENCODING_DEFAULT, ENCODING_UNCOMPRESSED:
+		return node.compact(attrs, false), nil
+	default:
+		return nil, &ErrUnsupportedEncoding{Encoding: encoding}
+	}
+}
+
+func (b *PotreeArchive) writeOctreeNode(node *Node, w io.Writer) error {
+	if node.Name == "" {
+		node.Name = "r"
+	}
+	if node.Parent == nil && node != b.root {
+		return fmt.Errorf("node %s is detached from the tree", node.Name)
 	}
-	if node != nil && node.ByteSize > 0 {
-		data, err := node.read(b.octree)
+	b.nodeMaps[node.Name] = node
+	if b.cache != nil {
+		b.cache.Remove(node.Name)
+	}
+	if node.Buffer == nil {
+		buf, err := b.encodeNode(node)
 		if err != nil {
 			return err
 		}
-		node.Buffer = data
+		node.Buffer = buf
+	}
+	err := node.write(b.octreeOffset, node.Buffer, w)
+	if err != nil {
+		return err
+	}
+	b.octreeOffset += node.ByteSize
+	return nil
+}
+
+func (b *PotreeArchive) readOctreeNode(node *Node) error {
+	if node == nil {
+		return nil
 	}
+	data, err := b.FetchNode(node)
+	if err != nil {
+		return err
+	}
+	node.Buffer = data
 	return nil
 }
 
+// DecodeNode decodes a payload returned by FetchNode. It only reads the
+// archive metadata and leaves the node untouched.
+func (b *PotreeArchive) DecodeNode(node *Node, data []byte) ([]Attribute, error) {
+	var (
+		attrs []Attribute
+		err   error
+	)
+	encoding := b.metadata.GetEncoding()
+	codec, compressed := nodeCodecs[encoding]
+	switch {
+	case compressed:
+		attrs, err = node.uncompress(data, b.metadata.Attrs, codec)
+	case encoding == ENCODING_DEFAULT || encoding == ENCODING_UNCOMPRESSED:
+		attrs, err = node.uncompact(data, b.metadata.Attrs, false)
+	default:
+		return nil, &ErrUnsupportedEncoding{Encoding: encoding}
+	}
+	if serr, ok := err.(*ErrSchemaMismatch); ok {
+		serr.Node = node.Name
+		return nil, serr
+	}
+	if err != nil {
+		return nil, &ErrCorruptNode{Name: node.Name, Offset: node.ByteOffset, Err: err}
+	}
+	return attrs, nil
+}
+
 func (b *PotreeArchive) unpackNode(node *Node) error {
 	if node.Buffer == nil {
 		err := b.readOctreeNode(node)
@@ -476,12 +855,10 @@ func (b *PotreeArchive) unpackNode(node *Node) error {
 			return err
 		}
 	}
-	node.AttrStart = b.attrOffset
-	if b.metadata.IsBrotliEncoded() {
-		node.uncompress(node.Buffer, b.metadata.Attrs[node.AttrStart:node.AttrEnd])
-	} else {
-		node.uncompact(node.Buffer, b.metadata.Attrs[node.AttrStart:node.AttrEnd], false)
+	attrs, err := b.DecodeNode(node, node.Buffer)
+	if err != nil {
+		return err
 	}
-	b.attrOffset = node.AttrEnd
+	node.Attrs = attrs
 	return nil
 }
@@ -0,0 +1,1411 @@
+package potree
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func makeTestNode(name string, box AABB, meta *Metadata, numPoints int) *Node {
+	n := &Node{Name: name, Box: box}
+	offset := meta.offsetVector()
+	position := POSITION
+	intensity := INTENSITY
+	pos := make([]int32, numPoints*3)
+	values := make([]uint16, numPoints)
+	for i := 0; i < numPoints; i++ {
+		for k := 0; k < 3; k++ {
+			t := float64((i*7+k*3)%numPoints+1) / float64(numPoints+2)
+			world := box.Min[k] + t*(box.Max[k]-box.Min[k])
+			pos[i*3+k] = int32((world - offset[k]) / meta.Scale[k])
+		}
+		values[i] = uint16(i)
+	}
+	position.Data = pos
+	intensity.Data = values
+	n.Attrs = []Attribute{position, intensity}
+	n.NumPoints = uint32(numPoints)
+	return n
+}
+
+func makeTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "potree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func makeTestArchive(t *testing.T, encoding string) *PotreeArchive {
+	dir := makeTestDir(t)
+	meta := NewMetadata([]Attribute{POSITION, INTENSITY})
+	meta.BoundingBox = AABB{Min: [3]float64{0, 0, 0}, Max: [3]float64{64, 64, 64}}
+	meta.Scale = [3]float64{0.001, 0.001, 0.001}
+	meta.Offset = &[3]float64{0, 0, 0}
+	meta.Encoding = &encoding
+
+	root := makeTestNode("r", meta.BoundingBox, meta, 100)
+	for _, idx := range []int{0, 3, 7} {
+		child := makeTestNode(root.Name+string('0'+rune(idx)), root.Box.Child(idx), meta, 40+idx)
+		child.Parent = root
+		root.Childs[idx] = child
+	}
+	grandchild := makeTestNode("r70", root.Childs[7].Box.Child(0), meta, 25)
+	grandchild.Parent = root.Childs[7]
+	root.Childs[7].Childs[0] = grandchild
+
+	arch := NewArchive(dir)
+	arch.SetMetadata(meta)
+	arch.SetRoot(root)
+	if err := arch.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return arch
+}
+
+// makeGridArchive builds an archive from the n×n points at(i, j), given in
+// world coordinates and quantized with meta.
+func makeGridArchive(t *testing.T, meta *Metadata, n, maxPointsPerNode int, at func(i, j int) [3]float64) *PotreeArchive {
+	offset := meta.offsetVector()
+	position := POSITION
+	values := make([]int32, 0, n*n*3)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			p := at(i, j)
+			for k := 0; k < 3; k++ {
+				values = append(values, int32(math.Round((p[k]-offset[k])/meta.Scale[k])))
+			}
+		}
+	}
+	position.Data = values
+	bld := NewBuilder(meta)
+	bld.MaxPointsPerNode = maxPointsPerNode
+	if err := bld.Add(meta, []Attribute{position}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := bld.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := NewArchive(makeTestDir(t))
+	arch.SetMetadata(meta)
+	arch.SetRoot(root)
+	if err := arch.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return arch
+}
+
+func TestSaveLoad(t *testing.T) {
+	for _, encoding := range []string{ENCODING_DEFAULT, ENCODING_BROTLI, ENCODING_ZSTD, ENCODING_LZ4} {
+		arch := makeTestArchive(t, encoding)
+
+		loaded := NewArchive(arch.path)
+		if err := loaded.Load(); err != nil {
+			t.Fatal(err)
+		}
+		for name, n := range arch.nodeMaps {
+			ln := loaded.GetNode(name)
+			if ln == nil {
+				t.Fatalf("%s: node %s missing", encoding, name)
+			}
+			if ln.NumPoints != n.NumPoints || ln.Box != n.Box {
+				t.Errorf("%s: node %s differs", encoding, name)
+			}
+			want := n.Attrs[1].Data.([]uint16)
+			got := ln.Attrs[1].Data.([]uint16)
+			if len(want) != len(got) {
+				t.Errorf("%s: node %s has %d intensities, want %d", encoding, name, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestReencode(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	original, err := ioutil.ReadFile(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := NewArchive(arch.path)
+	if err := edited.Reencode(ENCODING_UNCOMPRESSED); err != nil {
+		t.Fatal(err)
+	}
+	if err := edited.Reencode("ZIP"); err == nil {
+		t.Error("reencoded with an unknown encoding")
+	}
+
+	loaded := NewArchive(arch.path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GetMetadata().GetEncoding() != ENCODING_UNCOMPRESSED {
+		t.Fatalf("archive is encoded as %s", loaded.GetMetadata().GetEncoding())
+	}
+	loaded.SetOptions(&Options{Encoding: ENCODING_DEFAULT})
+	if err := loaded.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, original) {
+		t.Error("octree differs after reencoding")
+	}
+	check := NewArchive(arch.path)
+	if err := check.LoadHierarchy(); err != nil {
+		t.Fatal(err)
+	}
+	if check.GetMetadata().GetEncoding() != ENCODING_DEFAULT {
+		t.Error("encoding not restored")
+	}
+}
+
+func TestHierarchyStepSize(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	for _, step := range []int{1, 2, 3} {
+		arch.SetOptions(&Options{HierarchyStepSize: step})
+		if err := arch.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded := NewArchive(arch.path)
+		if err := loaded.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.GetMetadata().Hierarchy.StepSize != int64(step) {
+			t.Errorf("step %d: metadata has step size %d", step, loaded.GetMetadata().Hierarchy.StepSize)
+		}
+		if len(loaded.GetNodes()) != 5 {
+			t.Fatalf("step %d: loaded %d nodes", step, len(loaded.GetNodes()))
+		}
+		for _, n := range arch.GetNodes() {
+			ln := loaded.GetNode(n.Name)
+			if ln == nil || ln.NumPoints != n.NumPoints || ln.ByteOffset != n.ByteOffset || ln.Box != n.Box {
+				t.Errorf("step %d: node %s differs", step, n.Name)
+			}
+		}
+
+		// rewriting the index keeps the step size the archive was written with
+		if err := loaded.writeIndex(); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.GetMetadata().Hierarchy.StepSize != int64(step) {
+			t.Errorf("step %d: rewritten with step size %d", step, loaded.GetMetadata().Hierarchy.StepSize)
+		}
+	}
+}
+
+func TestParallelLoadWrite(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	saved, err := ioutil.ReadFile(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewArchive(arch.path)
+	loaded.SetConcurrency(4)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range loaded.GetNodes() {
+		n.Buffer = nil
+	}
+	octree := &bytes.Buffer{}
+	if err := loaded.Write(octree, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(octree.Bytes(), saved) {
+		t.Error("parallel write differs from the saved octree")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewArchive(arch.path).LoadContext(ctx); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestConcurrentRead(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+
+	reader := NewArchive(arch.path)
+	if err := reader.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	nodes := reader.GetNodes()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*len(nodes))
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, n := range nodes {
+				points, err := reader.ReadNode(n)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				want := arch.GetNode(n.Name).Attrs[1].Data.([]uint16)
+				got := FindAttribute(points, INTENSITY.Name).Data.([]uint16)
+				if len(got) != len(want) || got[len(got)-1] != want[len(want)-1] {
+					errs <- fmt.Errorf("node %s decoded wrong points", n.Name)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	dir := path.Join(arch.path, "stream")
+
+	meta := NewMetadata([]Attribute{POSITION, INTENSITY})
+	meta.BoundingBox = arch.GetMetadata().BoundingBox
+	meta.Scale = arch.GetMetadata().Scale
+	meta.Offset = arch.GetMetadata().Offset
+	w, err := NewStreamWriter(dir, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := arch.GetNodes()
+	var wg sync.WaitGroup
+	for i := len(nodes) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			if err := w.WriteNode(n.Name, n.Attrs); err != nil {
+				t.Error(err)
+			}
+		}(nodes[i])
+	}
+	wg.Wait()
+	if err := w.WriteNode("r", nodes[0].Attrs); err == nil {
+		t.Error("node written twice")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := NewArchive(dir).Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.NumNodes != len(nodes) || report.NumPoints != 100+40+43+47+25 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	streamed := NewArchive(dir)
+	if err := streamed.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		sn := streamed.GetNode(n.Name)
+		if sn == nil || sn.Box != n.Box || sn.NumPoints != n.NumPoints {
+			t.Errorf("node %s differs", n.Name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+
+	report, err := arch.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.NumNodes != 5 || report.NumPoints != 100+40+43+47+25 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+
+	octree := path.Join(arch.path, OctreeName)
+	info, _ := os.Stat(octree)
+	if err := os.Truncate(octree, info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+	report, err = arch.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, issue := range report.Issues {
+		if issue.Kind == ISSUE_NODE_RANGE {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("truncated octree.bin not reported: %s", report)
+	}
+}
+
+func TestValidateCorruptPayload(t *testing.T) {
+	for _, encoding := range []string{ENCODING_BROTLI, ENCODING_ZSTD, ENCODING_LZ4} {
+		arch := makeTestArchive(t, encoding)
+		n := arch.GetNode("r3")
+		f, err := os.OpenFile(arch.getOctreePath(), os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteAt(bytes.Repeat([]byte{0xff}, int(n.ByteSize)), n.ByteOffset)
+		f.Close()
+
+		report, err := NewArchive(arch.path).Validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Issues) != 1 || report.Issues[0].Kind != ISSUE_DECODE || report.Issues[0].Node != "r3" {
+			t.Fatalf("%s: corrupt payload not reported: %s", encoding, report)
+		}
+	}
+}
+
+func TestValidateDecoderPanic(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_BROTLI)
+	codec := nodeCodecs[ENCODING_BROTLI]
+	defer func() { nodeCodecs[ENCODING_BROTLI] = codec }()
+	nodeCodecs[ENCODING_BROTLI] = nodeCodec{encode: codec.encode, decode: func(data []byte) ([]byte, error) {
+		panic("corrupt stream")
+	}}
+
+	report, err := NewArchive(arch.path).Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != report.NumNodes || report.Issues[0].Kind != ISSUE_DECODE {
+		t.Fatalf("decoder panics not reported: %s", report)
+	}
+}
+
+func TestNodeLayout(t *testing.T) {
+	meta := NewMetadata([]Attribute{POSITION, INTENSITY})
+	meta.Scale = [3]float64{0.001, 0.001, 0.001}
+	n := makeTestNode("r", AABB{Max: [3]float64{1, 1, 1}}, meta, 3)
+	position := n.Attrs[0].Data.([]int32)
+	intensity := n.Attrs[1].Data.([]uint16)
+
+	// DEFAULT interleaves the attributes point by point
+	data := n.compact(n.Attrs, false)
+	if len(data) != 3*14 {
+		t.Fatalf("%d bytes for 3 points of 14 bytes", len(data))
+	}
+	for i := 0; i < 3; i++ {
+		for k := 0; k < 3; k++ {
+			if v := int32(binary.LittleEndian.Uint32(data[i*14+k*4:])); v != position[i*3+k] {
+				t.Fatalf("point %d: coordinate %d is %d, want %d", i, k, v, position[i*3+k])
+			}
+		}
+		if v := binary.LittleEndian.Uint16(data[i*14+12:]); v != intensity[i] {
+			t.Fatalf("point %d: intensity %d, want %d", i, v, intensity[i])
+		}
+	}
+	attrs, err := n.uncompact(data, meta.Attrs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum(attrs) != checksum(n.Attrs) {
+		t.Fatal("DEFAULT payload doesn't decode to the same points")
+	}
+	if _, err := n.uncompact(data[:len(data)-1], meta.Attrs, false); err == nil {
+		t.Fatal("short DEFAULT payload decoded")
+	}
+
+	// compressed encodings store the attributes one after the other
+	data = n.compact(n.Attrs, true)
+	if len(data) != 3*16+3*2 || binary.LittleEndian.Uint16(data[3*16:]) != intensity[0] {
+		t.Fatalf("unexpected attribute-major payload of %d bytes", len(data))
+	}
+	attrs, err = n.uncompact(data, meta.Attrs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum(attrs) != checksum(n.Attrs) {
+		t.Fatal("attribute-major payload doesn't decode to the same points")
+	}
+	if _, err := n.uncompact(append(data, 0), meta.Attrs, true); err == nil {
+		t.Fatal("long attribute-major payload decoded")
+	}
+}
+
+func TestSaveTruncates(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	meta := arch.GetMetadata()
+	root := makeTestNode("r", meta.BoundingBox, meta, 10)
+	smaller := NewArchive(arch.path)
+	smaller.SetMetadata(meta)
+	smaller.SetRoot(root)
+	if err := smaller.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != root.ByteSize {
+		t.Fatalf("octree.bin holds %d bytes, the only node %d", info.Size(), root.ByteSize)
+	}
+	loaded := NewArchive(arch.path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.GetNodes()) != 1 || *loaded.GetMetadata().Points != 10 {
+		t.Fatalf("%d nodes, %d points after overwriting", len(loaded.GetNodes()), *loaded.GetMetadata().Points)
+	}
+}
+
+func TestAABB(t *testing.T) {
+	box := AABB{Min: [3]float64{0, 10, 20}, Max: [3]float64{4, 18, 36}}
+	if box.Size() != [3]float64{4, 8, 16} || box.Center() != [3]float64{2, 14, 28} {
+		t.Fatalf("size %v, center %v", box.Size(), box.Center())
+	}
+	// bit 2 of the child index selects the upper half along x, bit 0 along z
+	if c := box.Child(4); c.Min != [3]float64{2, 10, 20} || c.Max != [3]float64{4, 14, 28} {
+		t.Fatalf("child 4 is %v", c)
+	}
+	if c := box.Child(3); c.Min != [3]float64{0, 14, 28} || c.Max != [3]float64{2, 18, 36} {
+		t.Fatalf("child 3 is %v", c)
+	}
+	tolerance := [3]float64{0.5, 0.5, 0.5}
+	if !box.Contains([3]float64{4.4, 10, 36}, tolerance) || box.Contains([3]float64{4.6, 10, 36}, tolerance) {
+		t.Fatal("containment ignores the tolerance")
+	}
+}
+
+func TestConvertAndQuery(t *testing.T) {
+	dir := makeTestDir(t)
+
+	arch, err := Convert([]string{"cpotree_2.0.potree"}, &Options{Outdir: dir, Encoding: ENCODING_DEFAULT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *arch.GetMetadata().Points != 24666 {
+		t.Fatalf("converted %d points", *arch.GetMetadata().Points)
+	}
+
+	loaded := NewArchive(dir)
+	points, err := loaded.Query(nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points[0].Len() != 24666 {
+		t.Fatalf("queried %d points", points[0].Len())
+	}
+
+	box := loaded.GetRoot().Box.Child(0)
+	points, err = loaded.Query(&box, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := FindAttribute(points, POSITION.Name)
+	for i := 0; i < position.Len(); i++ {
+		if !box.Contains(loaded.GetMetadata().WorldPosition(position, i), [3]float64{}) {
+			t.Fatalf("point %d outside query box", i)
+		}
+	}
+}
+
+// referencePosition decodes the i-th position of a decompressed BROTLI
+// payload the way the Potree viewer does.
+func referencePosition(data []byte, i int) [3]int32 {
+	le := binary.LittleEndian
+	mc_0 := le.Uint32(data[i*16+4:])
+	mc_1 := le.Uint32(data[i*16+0:])
+	mc_2 := le.Uint32(data[i*16+12:])
+	mc_3 := le.Uint32(data[i*16+8:])
+	var ret [3]int32
+	for k := uint32(0); k < 3; k++ {
+		v := dealign24b((mc_3&0x00FFFFFF)>>k) | (dealign24b(((mc_3>>24)|(mc_2<<8))>>k) << 8)
+		if mc_1 != 0 || mc_2 != 0 {
+			v = v | (dealign24b((mc_1&0x00FFFFFF)>>k) << 16) | (dealign24b(((mc_1>>24)|(mc_0<<8))>>k) << 24)
+		}
+		ret[k] = int32(v)
+	}
+	return ret
+}
+
+func TestBrotliSample(t *testing.T) {
+	dir := makeTestDir(t)
+
+	archives := map[string]*PotreeArchive{}
+	for _, encoding := range []string{ENCODING_DEFAULT, ENCODING_BROTLI} {
+		outdir := path.Join(dir, encoding)
+		if _, err := Convert([]string{"cpotree_2.0.potree"}, &Options{Outdir: outdir, Encoding: encoding}); err != nil {
+			t.Fatal(err)
+		}
+		archives[encoding] = NewArchive(outdir)
+		if err := archives[encoding].Load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	brotli := archives[ENCODING_BROTLI]
+	for _, n := range archives[ENCODING_DEFAULT].GetNodes() {
+		bn := brotli.GetNode(n.Name)
+		if bn == nil || bn.NumPoints != n.NumPoints {
+			t.Fatalf("node %s differs", n.Name)
+		}
+		// BROTLI nodes hold the same points sorted by Morton code
+		order := mortonOrder(FindAttribute(n.Attrs, POSITION.Name))
+		for i := range n.Attrs {
+			want := n.Attrs[i].Subset(order)
+			got := FindAttribute(bn.Attrs, n.Attrs[i].Name)
+			if !bytes.Equal(attributeBytes(got.Data), attributeBytes(want.Data)) {
+				t.Errorf("node %s: attribute %s differs", n.Name, n.Attrs[i].Name)
+			}
+		}
+
+		meta := brotli.GetMetadata()
+		size := bn.Box.Size()
+		local := meta.NodePositions(bn, FindAttribute(bn.Attrs, POSITION.Name))
+		for i, v := range local {
+			if v < -float32(meta.Scale[i%3]) || v > float32(size[i%3]+meta.Scale[i%3]) {
+				t.Fatalf("node %s: relative coordinate %f outside the node box", n.Name, v)
+			}
+		}
+
+		data, err := (&Brotli{}).Decode(nil, bn.Buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		position := FindAttribute(bn.Attrs, POSITION.Name).Data.([]int32)
+		for i := 0; i < int(bn.NumPoints); i++ {
+			p := referencePosition(data, i)
+			if p != [3]int32{position[i*3], position[i*3+1], position[i*3+2]} {
+				t.Fatalf("node %s point %d: viewer decodes %v", n.Name, i, p)
+			}
+		}
+	}
+}
+
+func TestWorldPositions(t *testing.T) {
+	dir := makeTestDir(t)
+
+	// the child lies away from the offset, so positions relative to its box
+	// differ from the absolute ones
+	world := map[string][][3]float64{
+		"r":  {{1001, 2001, 301}, {1010.5, 2020.25, 330.75}},
+		"r7": {{1040.25, 2050.5, 333.33}, {1063.99, 2032.01, 332}, {1032, 2032, 332}},
+	}
+	integers := map[string][][3]int32{
+		"r":  {{100, 100, 100}, {1050, 2025, 3075}},
+		"r7": {{4025, 5050, 3333}, {6399, 3201, 3200}, {3200, 3200, 3200}},
+	}
+	for _, encoding := range []string{ENCODING_DEFAULT, ENCODING_BROTLI} {
+		meta := NewMetadata([]Attribute{POSITION})
+		meta.BoundingBox = AABB{Min: [3]float64{1000, 2000, 300}, Max: [3]float64{1064, 2064, 364}}
+		meta.Scale = [3]float64{0.01, 0.01, 0.01}
+		meta.Offset = &[3]float64{1000, 2000, 300}
+		meta.Encoding = &encoding
+		root := &Node{Name: "r", Box: meta.BoundingBox}
+		child := &Node{Name: "r7", Box: root.Box.Child(7), Parent: root}
+		root.Childs[7] = child
+		for _, n := range []*Node{root, child} {
+			position := POSITION
+			var data []int32
+			for _, p := range world[n.Name] {
+				q, err := meta.IntegerPosition(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = append(data, q[0], q[1], q[2])
+			}
+			position.Data = data
+			n.Attrs = []Attribute{position}
+			n.NumPoints = uint32(len(world[n.Name]))
+		}
+		arch := NewArchive(path.Join(dir, encoding))
+		arch.SetMetadata(meta)
+		arch.SetRoot(root)
+		if err := arch.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded := NewArchive(path.Join(dir, encoding))
+		if err := loaded.Load(); err != nil {
+			t.Fatal(err)
+		}
+		for name, points := range world {
+			n := loaded.GetNode(name)
+			position := FindAttribute(n.Attrs, POSITION.Name)
+			decoded := loaded.GetMetadata().WorldPositions(position)
+			ints := position.Data.([]int32)
+			// BROTLI stores the points of a node in Morton order
+			for i, p := range points {
+				found := false
+				for j := 0; j < position.Len(); j++ {
+					d := [3]float64{decoded[j*3] - p[0], decoded[j*3+1] - p[1], decoded[j*3+2] - p[2]}
+					if math.Abs(d[0]) < 1e-6 && math.Abs(d[1]) < 1e-6 && math.Abs(d[2]) < 1e-6 {
+						found = [3]int32{ints[j*3], ints[j*3+1], ints[j*3+2]} == integers[name][i]
+					}
+				}
+				if !found {
+					t.Errorf("%s: node %s doesn't decode %v to %v", encoding, name, p, integers[name][i])
+				}
+			}
+		}
+	}
+}
+
+func TestIntegerPositionOverflow(t *testing.T) {
+	meta := NewMetadata([]Attribute{POSITION})
+	meta.Scale = [3]float64{0.001, 0.001, 0.001}
+	meta.Offset = &[3]float64{1000, 0, 0}
+	if q, err := meta.IntegerPosition([3]float64{1000 + 2147483.647, -2147483.648, 0}); err != nil || q != [3]int32{math.MaxInt32, math.MinInt32, 0} {
+		t.Errorf("limits quantized to %v, %v", q, err)
+	}
+	for _, p := range [][3]float64{{1000 + 2147483.648, 0, 0}, {1000, -2147483.649, 0}, {1000, 0, 1e10}} {
+		if q, err := meta.IntegerPosition(p); err == nil {
+			t.Errorf("%v quantized to %v", p, q)
+		}
+	}
+}
+
+// TestBrotliWords decodes BROTLI payloads written word by word as the
+// Potree viewer reads them: position words at byte 4, 0, 12, 8 and rgb
+// words at byte 4, 0, upper before lower.
+func TestBrotliWords(t *testing.T) {
+	words := []uint32{
+		0, 0, 53, 0, // lower code 53: x 1, y 2, z 3
+		1, 0, 0, 0, // upper code 1: x 1<<16
+		0, 2, 0, 0, // upper code 1<<33 without mc_1 and mc_2, dropped by the viewer
+		0, 2, 0, 2, // x 1<<27 | 1<<11
+		53, 0, // r 1, g 2, b 3
+		0, 2, // r 1<<11
+		1, 0, // r 1
+		0, 0,
+	}
+	data := make([]byte, len(words)*4)
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(data[i*4:], w)
+	}
+	n := &Node{}
+	n.NumPoints = 4
+	attrs, err := n.uncompact(data, []Attribute{POSITION, COLOR}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := []int32{1, 2, 3, 1 << 16, 0, 0, 0, 0, 0, 1<<27 | 1<<11, 0, 0}
+	if fmt.Sprint(attrs[0].Data) != fmt.Sprint(position) {
+		t.Errorf("positions %v, want %v", attrs[0].Data, position)
+	}
+	rgb := []uint16{1, 2, 3, 1 << 11, 0, 0, 1, 0, 0, 0, 0, 0}
+	if fmt.Sprint(attrs[1].Data) != fmt.Sprint(rgb) {
+		t.Errorf("colors %v, want %v", attrs[1].Data, rgb)
+	}
+	for i := 0; i < 4; i++ {
+		if p := referencePosition(data, i); p != [3]int32{position[i*3], position[i*3+1], position[i*3+2]} {
+			t.Errorf("point %d: reference decodes %v", i, p)
+		}
+	}
+}
+
+func TestConvertChunked(t *testing.T) {
+	dir := makeTestDir(t)
+
+	// the smallest budget splits the sample into chunks of at most
+	// MaxPointsPerChunk points
+	opts := &Options{Outdir: dir, Encoding: ENCODING_DEFAULT, MemoryBudget: 1, TempDir: dir}
+	if _, err := Convert([]string{"cpotree_2.0.potree"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewArchive(dir).Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.NumPoints != 24666 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("%d files left in the output directory", len(entries))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := makeTestArchive(t, ENCODING_DEFAULT)
+	b := makeTestArchive(t, ENCODING_DEFAULT)
+
+	meta := b.GetMetadata()
+	offset := [3]float64{100, 50, 0}
+	for _, n := range b.GetNodes() {
+		position := &n.Attrs[0]
+		for i := 0; i < position.Len(); i++ {
+			for k := 0; k < 3; k++ {
+				position.SetFloat64(i, k, position.GetFloat64(i, k)*meta.Scale[k]/0.01)
+			}
+		}
+		n.Buffer = nil
+	}
+	meta.Scale = [3]float64{0.01, 0.01, 0.01}
+	meta.Offset = &offset
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := makeTestDir(t)
+
+	merged, err := Merge([]*PotreeArchive{NewArchive(a.path), NewArchive(b.path)}, &Options{Outdir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mmeta := merged.GetMetadata()
+	if *mmeta.Points != 2*(100+40+43+47+25) {
+		t.Fatalf("merged %d points", *mmeta.Points)
+	}
+	if mmeta.Scale != [3]float64{0.001, 0.001, 0.001} || mmeta.BoundingBox.Max[0] < 150 {
+		t.Fatalf("unexpected merged quantization %v %v", mmeta.Scale, mmeta.BoundingBox)
+	}
+	report, err := merged.Validate()
+	if err != nil || !report.OK() {
+		t.Fatalf("merged archive invalid: %v %s", err, report)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	meta := arch.GetMetadata()
+	before := NewArchive(arch.path)
+	if err := before.LoadHierarchy(); err != nil {
+		t.Fatal(err)
+	}
+
+	box := AABB{Min: [3]float64{40, 40, 40}, Max: [3]float64{64, 64, 64}}
+	extra := makeTestNode("x", box, meta, 2*MaxPointsPerChunk)
+	if err := before.Append(meta, extra.Attrs); err != nil {
+		t.Fatal(err)
+	}
+
+	after := NewArchive(arch.path)
+	if err := after.LoadHierarchy(); err != nil {
+		t.Fatal(err)
+	}
+	if *after.GetMetadata().Points != int64(100+40+43+47+25+2*MaxPointsPerChunk) {
+		t.Fatalf("archive holds %d points after append", *after.GetMetadata().Points)
+	}
+	if n := after.GetNode("r0"); n.ByteOffset != arch.GetNode("r0").ByteOffset {
+		t.Fatal("untouched node was rewritten")
+	}
+	if len(after.GetNodes()) <= 5 {
+		t.Fatal("overfull leaf was not split")
+	}
+	report, err := after.Validate()
+	if err != nil || !report.OK() {
+		t.Fatalf("archive invalid after append: %v %s", err, report)
+	}
+}
+
+func TestAppendFailure(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	meta := arch.GetMetadata()
+	edited := NewArchive(arch.path)
+	if err := edited.LoadHierarchy(); err != nil {
+		t.Fatal(err)
+	}
+	records := make(map[string]node)
+	for _, n := range edited.GetNodes() {
+		records[n.Name] = n.node
+	}
+	if err := os.Mkdir(arch.getHierarchyPath()+".tmp", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	box := AABB{Min: [3]float64{40, 40, 40}, Max: [3]float64{64, 64, 64}}
+	extra := makeTestNode("x", box, meta, 2*MaxPointsPerChunk)
+	if err := edited.Append(meta, extra.Attrs); err == nil {
+		t.Fatal("append succeeded without writable hierarchy")
+	}
+	if len(edited.GetNodes()) != len(records) {
+		t.Fatalf("%d nodes after failed append, want %d", len(edited.GetNodes()), len(records))
+	}
+	for _, n := range edited.GetNodes() {
+		if n.node != records[n.Name] {
+			t.Fatalf("node %s is %+v after failed append, want %+v", n.Name, n.node, records[n.Name])
+		}
+	}
+	if *edited.GetMetadata().Points != *meta.Points {
+		t.Fatalf("metadata counts %d points after failed append", *edited.GetMetadata().Points)
+	}
+	r70 := edited.GetNode("r70")
+	attrs, err := edited.ReadNode(r70)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs[0].Len() != 25 {
+		t.Fatalf("r70 holds %d points after failed append", attrs[0].Len())
+	}
+}
+
+func TestEditAndCompact(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	total := 100 + 40 + 43 + 47 + 25
+
+	edited := NewArchive(arch.path)
+	deleted, err := edited.DeletePoints(func(p *Point) bool {
+		return p.Get(INTENSITY.Name, 0) < 10
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 50 {
+		t.Fatalf("deleted %d points", deleted)
+	}
+	updated, err := edited.SetAttribute(INTENSITY.Name, 0, 1000, func(p *Point) bool {
+		return p.Position()[2] > 32
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == 0 {
+		t.Fatal("no points updated")
+	}
+	reclaimed, err := edited.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed <= 0 {
+		t.Fatalf("compaction reclaimed %d bytes", reclaimed)
+	}
+
+	loaded := NewArchive(arch.path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if *loaded.GetMetadata().Points != int64(total-deleted) {
+		t.Fatalf("archive holds %d points", *loaded.GetMetadata().Points)
+	}
+	count := 0
+	for _, n := range loaded.GetNodes() {
+		for _, v := range FindAttribute(n.Attrs, INTENSITY.Name).Data.([]uint16) {
+			if v == 1000 {
+				count++
+			}
+		}
+	}
+	if count != updated {
+		t.Fatalf("%d points carry the new value, updated %d", count, updated)
+	}
+	report, err := loaded.Validate()
+	if err != nil || !report.OK() {
+		t.Fatalf("archive invalid after edit: %v %s", err, report)
+	}
+}
+
+func TestAddRemoveAttribute(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+
+	edited := NewArchive(arch.path)
+	height := Attribute{Name: "height", Type: "float", NumElements: 1, ElementSize: 4, Size: 4}
+	err := edited.AddAttributeFunc(height, func(p *Point, values []float64) {
+		values[0] = p.Position()[2]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := edited.RemoveAttribute(INTENSITY.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewArchive(arch.path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	meta := loaded.GetMetadata()
+	if len(meta.Attrs) != 2 || meta.Attrs[1].Name != "height" || meta.BytesPerPoint != 16 {
+		t.Fatalf("unexpected schema %+v", meta.Attrs)
+	}
+	for _, n := range loaded.GetNodes() {
+		position := FindAttribute(n.Attrs, POSITION.Name)
+		h := FindAttribute(n.Attrs, "height")
+		for i := 0; i < position.Len(); i++ {
+			z := meta.WorldPosition(position, i)[2]
+			if d := h.GetFloat64(i, 0) - z; d > 1e-3 || d < -1e-3 {
+				t.Fatalf("node %s point %d: height %f, z %f", n.Name, i, h.GetFloat64(i, 0), z)
+			}
+		}
+	}
+}
+
+func TestRewriteFailure(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_BROTLI)
+
+	edited := NewArchive(arch.path)
+	if err := edited.LoadHierarchy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := edited.unpackNode(edited.GetNode("r0")); err != nil {
+		t.Fatal(err)
+	}
+	before := make(map[string][2]int64)
+	for _, n := range edited.GetNodes() {
+		before[n.Name] = [2]int64{n.ByteOffset, n.ByteSize}
+	}
+	height := Attribute{Name: "height", Type: "float", NumElements: 1, ElementSize: 4, Size: 4}
+	computed := 0
+	err := edited.AddAttribute(height, func(n *Node, points []Attribute) (interface{}, error) {
+		if computed == 3 {
+			return nil, errors.New("compute failed")
+		}
+		computed++
+		return make([]float32, n.NumPoints), nil
+	})
+	if err == nil || computed != 3 {
+		t.Fatalf("expected the fourth node to fail, got %v after %d nodes", err, computed)
+	}
+	if len(edited.GetMetadata().Attrs) != 2 {
+		t.Fatalf("schema changed to %+v", edited.GetMetadata().Attrs)
+	}
+	for _, n := range edited.GetNodes() {
+		if at := [2]int64{n.ByteOffset, n.ByteSize}; at != before[n.Name] {
+			t.Fatalf("node %s moved from %v to %v", n.Name, before[n.Name], at)
+		}
+		if n.Name == "r0" {
+			if len(n.Attrs) != 2 {
+				t.Fatalf("loaded node r0 has %d attributes", len(n.Attrs))
+			}
+		} else if n.Attrs != nil {
+			t.Fatalf("node %s left decoded", n.Name)
+		}
+		if _, err := edited.ReadNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(arch.getOctreePath() + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary octree left behind: %v", err)
+	}
+	err = edited.AddAttribute(height, func(n *Node, points []Attribute) (interface{}, error) {
+		return make([]float32, n.NumPoints), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewArchive(arch.path).Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenRewrite(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_BROTLI)
+
+	opened := NewArchive(arch.path)
+	if err := opened.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	readAll := func() {
+		for _, n := range opened.GetNodes() {
+			if _, err := opened.ReadNode(n); err != nil {
+				t.Fatalf("node %s: %v", n.Name, err)
+			}
+		}
+	}
+	readAll()
+	height := Attribute{Name: "height", Type: "float", NumElements: 1, ElementSize: 4, Size: 4}
+	err := opened.AddAttributeFunc(height, func(p *Point, values []float64) {
+		values[0] = p.Position()[2]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll()
+	if _, err := opened.SetAttribute(INTENSITY.Name, 0, 7, func(p *Point) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opened.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	readAll()
+	attrs, err := opened.ReadNode(opened.GetNode("r70"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FindAttribute(attrs, "height") == nil || FindAttribute(attrs, INTENSITY.Name).GetFloat64(0, 0) != 7 {
+		t.Fatal("reads after the rewrite return the old payloads")
+	}
+}
+
+func TestCompactFailedIndex(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	edited := NewArchive(arch.path)
+	if _, err := edited.SetAttribute(INTENSITY.Name, 0, 7, func(p *Point) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(arch.getHierarchyPath()+".tmp", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edited.Compact(); err == nil {
+		t.Fatal("compaction succeeded without writable hierarchy")
+	}
+	after, err := ioutil.ReadFile(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("octree.bin replaced before the index was written")
+	}
+	if _, err := os.Stat(arch.getOctreePath() + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary octree left behind: %v", err)
+	}
+	if err := NewArchive(arch.path).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edited.ReadNode(edited.GetNode("r70")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactFailedRename(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	edited := NewArchive(arch.path)
+	if _, err := edited.SetAttribute(INTENSITY.Name, 0, 7, func(p *Point) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	// a directory in place of hierarchy.bin fails the rename after
+	// octree.bin has been replaced
+	if err := os.Remove(arch.getHierarchyPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Join(arch.getHierarchyPath(), "blocked"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edited.Compact(); err == nil {
+		t.Fatal("compaction succeeded without renaming the hierarchy")
+	}
+	info, err := os.Stat(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(0)
+	for _, n := range edited.GetNodes() {
+		size += n.ByteSize
+		attrs, err := edited.ReadNode(n)
+		if err != nil {
+			t.Fatalf("node %s: %v", n.Name, err)
+		}
+		if FindAttribute(attrs, INTENSITY.Name).GetFloat64(0, 0) != 7 {
+			t.Fatalf("node %s reads the wrong payload", n.Name)
+		}
+	}
+	if info.Size() != size {
+		t.Fatalf("octree.bin holds %d bytes, the nodes %d", info.Size(), size)
+	}
+
+	if err := os.RemoveAll(arch.getHierarchyPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := edited.writeIndex(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewArchive(arch.path).Validate()
+	if err != nil || !report.OK() {
+		t.Fatalf("archive invalid after retrying the index: %v %s", err, report)
+	}
+}
+
+func TestCorruptArchive(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_BROTLI)
+	var missing *ErrMissingFile
+	if err := NewArchive(path.Join(arch.path, "none")).Load(); !errors.As(err, &missing) {
+		t.Fatalf("expected a missing file, got %v", err)
+	}
+	var unsupported *ErrUnsupportedEncoding
+	if err := NewArchive(arch.path).Reencode("ZIP"); !errors.As(err, &unsupported) {
+		t.Fatalf("expected an unsupported encoding, got %v", err)
+	}
+
+	hierarchy, err := ioutil.ReadFile(arch.getHierarchyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(arch.getHierarchyPath(), hierarchy[:BytesPerNode], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var broken *ErrCorruptHierarchy
+	if err := NewArchive(arch.path).LoadHierarchy(); !errors.As(err, &broken) || broken.Node != "r" {
+		t.Fatalf("expected a corrupt hierarchy at the root, got %v", err)
+	}
+	if err := ioutil.WriteFile(arch.getHierarchyPath(), hierarchy, 0644); err != nil {
+		t.Fatal(err)
+	}
+	noHierarchy := NewArchive(arch.path)
+	noHierarchy.SetMetadata(NewMetadata([]Attribute{POSITION}))
+	if err := noHierarchy.readHierarchy(); !errors.As(err, &broken) {
+		t.Fatalf("expected a missing hierarchy, got %v", err)
+	}
+
+	loaded := NewArchive(arch.path)
+	if err := loaded.LoadHierarchy(); err != nil {
+		t.Fatal(err)
+	}
+	n := loaded.GetNode("r3")
+	f, err := os.OpenFile(arch.getOctreePath(), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(bytes.Repeat([]byte{0xff}, int(n.ByteSize)), n.ByteOffset)
+	f.Close()
+
+	var corrupt *ErrCorruptNode
+	if _, err := loaded.ReadNode(n); !errors.As(err, &corrupt) || corrupt.Name != "r3" || corrupt.Offset != n.ByteOffset {
+		t.Fatalf("expected node r3 to be corrupt, got %v", err)
+	}
+	if _, err := loaded.ReadNode(loaded.GetNode("r0")); err != nil {
+		t.Fatal(err)
+	}
+
+	last := loaded.GetNode("r70")
+	if err := os.Truncate(arch.getOctreePath(), last.ByteOffset+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.ReadNode(last); !errors.As(err, &corrupt) || corrupt.Name != "r70" {
+		t.Fatalf("expected node r70 to be corrupt, got %v", err)
+	}
+	last.ByteSize = 1 << 50
+	if _, err := loaded.ReadNode(last); !errors.As(err, &corrupt) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a short read of node r70, got %v", err)
+	}
+	if err := NewArchive(arch.path).Load(); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a corrupt node, got %v", err)
+	}
+}
+
+func TestRequantize(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	before, err := NewArchive(arch.path).Query(nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := arch.Requantize(0.005)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Scale != [3]float64{0.01, 0.01, 0.01} || q.MaxError != [3]float64{0.005, 0.005, 0.005} {
+		t.Fatalf("unexpected quantization %s", q)
+	}
+	loaded := NewArchive(arch.path)
+	after, err := loaded.Query(nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := loaded.Validate()
+	if err != nil || !report.OK() {
+		t.Fatalf("requantized archive invalid: %v %s", err, report)
+	}
+	oldPosition, newPosition := FindAttribute(before, POSITION.Name), FindAttribute(after, POSITION.Name)
+	for i := 0; i < oldPosition.Len(); i++ {
+		a := arch.GetMetadata().WorldPosition(newPosition, i)
+		b := [3]float64{oldPosition.GetFloat64(i, 0) * 0.001, oldPosition.GetFloat64(i, 1) * 0.001, oldPosition.GetFloat64(i, 2) * 0.001}
+		for k := 0; k < 3; k++ {
+			if math.Abs(a[k]-b[k]) > 0.005+1e-9 {
+				t.Fatalf("point %d moved from %v to %v", i, b, a)
+			}
+		}
+	}
+
+	meta := NewMetadata([]Attribute{POSITION})
+	meta.Scale = [3]float64{0.001, 0.001, 0.001}
+	meta.BoundingBox = AABB{Max: [3]float64{1e7, 1, 1}}
+	if q := meta.GetQuantization(); q.Overflow != [3]bool{true, false, false} {
+		t.Errorf("overflow not detected: %s", q)
+	}
+	if q := NewQuantization(meta.BoundingBox, 0.0005); q.Overflows() || q.Scale[0] <= 0.001 || q.Scale[1] != 0.001 {
+		t.Errorf("unexpected quantization for large bounds: %s", q)
+	}
+	position := POSITION
+	position.Data = []int32{math.MaxInt32, 0, 0}
+	src := NewMetadata([]Attribute{POSITION})
+	src.Scale = [3]float64{1, 1, 1}
+	if _, err := ConformPoints(meta, src, []Attribute{position}); err == nil {
+		t.Error("overflowing position converted")
+	}
+}
+
+func TestConvertPrecision(t *testing.T) {
+	dir := makeTestDir(t)
+
+	arch, err := Convert([]string{"cpotree_2.0.potree"}, &Options{Outdir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := arch.GetQuantization(); q.MaxError != [3]float64{} || q.Overflows() {
+		t.Errorf("lossless conversion reports %s", q)
+	}
+	arch, err = Convert([]string{"cpotree_2.0.potree"}, &Options{Outdir: dir, Precision: 0.05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := arch.GetQuantization(); q.Scale != [3]float64{0.1, 0.1, 0.1} || q.MaxError != [3]float64{0.05, 0.05, 0.05} {
+		t.Errorf("unexpected quantization %s", q)
+	}
+}
+
+func TestEstimateNormals(t *testing.T) {
+	// a plane rising along x, split over several nodes
+	meta := NewMetadata([]Attribute{POSITION})
+	meta.Scale = [3]float64{0.001, 0.001, 0.001}
+	meta.Offset = &[3]float64{}
+	arch := makeGridArchive(t, meta, 300, 5000, func(i, j int) [3]float64 {
+		x, y := float64(i)/25, float64(j)/25+float64(i%3)*0.005
+		return [3]float64{x, y, 0.5*x + 2}
+	})
+	if len(arch.GetNodes()) < 5 {
+		t.Fatalf("only %d nodes", len(arch.GetNodes()))
+	}
+
+	want := [3]float64{-0.5 / math.Sqrt(1.25), 0, 1 / math.Sqrt(1.25)}
+	for _, viewpoint := range []*[3]float64{nil, {5, 5, -100}} {
+		if err := arch.EstimateNormals(8, viewpoint); err != nil {
+			t.Fatal(err)
+		}
+		points, err := NewArchive(arch.path).Query(nil, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		normal := FindAttribute(points, NORMAL.Name)
+		if normal == nil || normal.Len() != 90000 {
+			t.Fatalf("normals missing in %d attributes", len(points))
+		}
+		sign := 1.0
+		if viewpoint != nil {
+			sign = -1
+		}
+		for i := 0; i < normal.Len(); i++ {
+			for a := 0; a < 3; a++ {
+				if math.Abs(normal.GetFloat64(i, a)-sign*want[a]) > 1e-3 {
+					t.Fatalf("point %d has normal %v, want %v", i, []float64{normal.GetFloat64(i, 0), normal.GetFloat64(i, 1), normal.GetFloat64(i, 2)}, want)
+				}
+			}
+		}
+	}
+	if len(arch.GetMetadata().Attrs) != 2 {
+		t.Errorf("normals not replaced: %d attributes", len(arch.GetMetadata().Attrs))
+	}
+}
+
+func TestEstimateNormalComponents(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	if err := arch.AddAttributeFunc(NORMAL_X, func(p *Point, values []float64) {}); err != nil {
+		t.Fatal(err)
+	}
+	var mismatch *ErrSchemaMismatch
+	if err := arch.EstimateNormals(8, nil); !errors.As(err, &mismatch) {
+		t.Fatalf("incomplete normal components accepted: %v", err)
+	}
+	for _, attr := range []Attribute{NORMAL_Y, NORMAL_Z} {
+		if err := arch.AddAttributeFunc(attr, func(p *Point, values []float64) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	numAttrs := len(arch.GetMetadata().Attrs)
+	if err := arch.EstimateNormals(8, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(arch.GetMetadata().Attrs) != numAttrs || arch.GetMetadata().Get(NORMAL.Name) != nil {
+		t.Fatalf("normal attribute added next to the components: %d attributes", len(arch.GetMetadata().Attrs))
+	}
+	points, err := NewArchive(arch.path).Query(nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y, z := FindAttribute(points, NORMAL_X.Name), FindAttribute(points, NORMAL_Y.Name), FindAttribute(points, NORMAL_Z.Name)
+	estimated := 0
+	for i := 0; i < x.Len(); i++ {
+		length := math.Sqrt(x.GetFloat64(i, 0)*x.GetFloat64(i, 0) + y.GetFloat64(i, 0)*y.GetFloat64(i, 0) + z.GetFloat64(i, 0)*z.GetFloat64(i, 0))
+		if length != 0 {
+			if math.Abs(length-1) > 1e-5 {
+				t.Fatalf("point %d has a normal of length %f", i, length)
+			}
+			estimated++
+		}
+	}
+	if estimated == 0 {
+		t.Fatal("no normals written to the components")
+	}
+
+	if err := os.Mkdir(arch.getHierarchyPath()+".tmp", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := arch.EstimateNormals(8, &[3]float64{0, 0, -100}); err == nil {
+		t.Fatal("normals estimated without writable hierarchy")
+	}
+	kept, err := NewArchive(arch.path).Query(nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z := FindAttribute(kept, NORMAL_Z.Name); z == nil || !reflect.DeepEqual(z.Data, FindAttribute(points, NORMAL_Z.Name).Data) {
+		t.Fatal("normals lost by a failed estimation")
+	}
+}
+
+func TestClassifyGround(t *testing.T) {
+	// sloped terrain with a 10 m building whose roof points must not be
+	// ground
+	meta := NewMetadata([]Attribute{POSITION, INTENSITY})
+	meta.Scale = [3]float64{0.01, 0.01, 0.01}
+	meta.Offset = &[3]float64{}
+	var roof int
+	arch := makeGridArchive(t, meta, 160, 2000, func(i, j int) [3]float64 {
+		x, y := float64(i)/2, float64(j)/2
+		z := 0.05*x + 0.02*y
+		if x >= 30 && x < 40 && y >= 30 && y < 40 {
+			z += 8
+			roof++
+		}
+		return [3]float64{x, y, z}
+	})
+
+	numGround, err := arch.ClassifyGround(GroundOptions{CellSize: 1, MaxWindow: 20, TileSize: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numGround != 160*160-roof {
+		t.Errorf("%d ground points, want %d", numGround, 160*160-roof)
+	}
+	points, err := NewArchive(arch.path).Query(nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	class := FindAttribute(points, CLASSIFICATION.Name)
+	if class == nil {
+		t.Fatal("classification not added")
+	}
+	pos := FindAttribute(points, POSITION.Name)
+	for i := 0; i < pos.Len(); i++ {
+		p := meta.WorldPosition(pos, i)
+		want := float64(LAS_CLASS_GROUND)
+		if p[2] > 0.05*p[0]+0.02*p[1]+1 {
+			want = LAS_CLASS_UNCLASSIFIED
+		}
+		if class.GetFloat64(i, 0) != want {
+			t.Fatalf("point %v has class %v", p, class.GetFloat64(i, 0))
+		}
+	}
+	report, err := NewArchive(arch.path).Validate()
+	if err != nil || !report.OK() {
+		t.Fatalf("classified archive invalid: %v %s", err, report)
+	}
+	if again, err := NewArchive(arch.path).ClassifyGround(GroundOptions{CellSize: 1, MaxWindow: 20}); err != nil || again != numGround {
+		t.Errorf("reclassified %d ground points, %v", again, err)
+	}
+}
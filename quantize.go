@@ -0,0 +1,160 @@
+package potree
+
+import (
+	"fmt"
+	"math"
+
+	vec3d "github.com/flywave/go3d/float64/vec3"
+)
+
+// Quantization describes how world coordinates map to the int32 integers of
+// the position attribute.
+type Quantization struct {
+	Scale  [3]float64
+	Offset [3]float64
+	// MaxError is the worst-case error per axis introduced by quantizing
+	// positions, zero where input positions already lie on the grid.
+	MaxError [3]float64
+	// Overflow flags the axes whose bounds don't fit into int32.
+	Overflow [3]bool
+}
+
+func newQuantization(scale, offset [3]float64, box AABB) Quantization {
+	q := Quantization{Scale: scale, Offset: offset}
+	for k := 0; k < 3; k++ {
+		q.MaxError[k] = scale[k] / 2
+		for _, v := range []float64{box.Min[k], box.Max[k]} {
+			i := math.Round((v - offset[k]) / scale[k])
+			if i < math.MinInt32 || i > math.MaxInt32 {
+				q.Overflow[k] = true
+			}
+		}
+	}
+	return q
+}
+
+// NewQuantization picks the offset at the minimum of box and a scale of
+// twice precision, so that rounding errors stay within precision. The scale
+// is coarsened on axes too long for 2^30 steps.
+func NewQuantization(box AABB, precision float64) Quantization {
+	step := 2 * precision
+	so := ComputeScaleOffset(vec3d.T(box.Min), vec3d.T(box.Max), vec3d.T{step, step, step})
+	return newQuantization(so.scale, so.offset, box)
+}
+
+// Overflows reports whether any axis overflows int32.
+func (q Quantization) Overflows() bool {
+	return q.Overflow[0] || q.Overflow[1] || q.Overflow[2]
+}
+
+func (q Quantization) String() string {
+	s := fmt.Sprintf("scale %v, offset %v, max error %v", q.Scale, q.Offset, q.MaxError)
+	if q.Overflows() {
+		s += fmt.Sprintf(", int32 overflow on axes %v", q.Overflow)
+	}
+	return s
+}
+
+// GetQuantization returns the quantization of the positions within their
+// bounds, the error is half the scale.
+func (l *Metadata) GetQuantization() Quantization {
+	return newQuantization(l.Scale, l.offsetVector(), l.tightBounds())
+}
+
+func onGrid(v float64) bool {
+	return math.Abs(v-math.Round(v)) < 1e-6
+}
+
+// quantizationError returns the worst-case error of converting positions of
+// src to dst, zero on axes where the grid of src is part of the one of dst.
+func quantizationError(src, dst *Metadata) [3]float64 {
+	var ret [3]float64
+	tr, err := projectionTransform(src, dst)
+	so, do := src.offsetVector(), dst.offsetVector()
+	for k := 0; k < 3; k++ {
+		if tr != nil || err != nil || !onGrid(src.Scale[k]/dst.Scale[k]) || !onGrid((so[k]-do[k])/dst.Scale[k]) {
+			ret[k] = dst.Scale[k] / 2
+		}
+	}
+	return ret
+}
+
+// inputQuantization returns the quantization of meta with the error of
+// converting the positions of every input.
+func inputQuantization(meta *Metadata, inputs []*Metadata) Quantization {
+	q := meta.GetQuantization()
+	q.MaxError = [3]float64{}
+	for _, src := range inputs {
+		e := quantizationError(src, meta)
+		for k := 0; k < 3; k++ {
+			q.MaxError[k] = math.Max(q.MaxError[k], e[k])
+		}
+	}
+	return q
+}
+
+// checkPosition returns an error if world coordinates don't fit into int32
+// with the Scale and Offset of l.
+func (l *Metadata) checkPosition(p [3]float64) error {
+	offset := l.offsetVector()
+	for k := 0; k < 3; k++ {
+		v := math.Round((p[k] - offset[k]) / l.Scale[k])
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return fmt.Errorf("position %v overflows int32 with scale %v and offset %v", p, l.Scale, offset)
+		}
+	}
+	return nil
+}
+
+// GetQuantization returns the quantization of the archive positions. After
+// Convert, Merge or Requantize MaxError is the worst-case error introduced
+// from the input positions, otherwise half the scale.
+func (b *PotreeArchive) GetQuantization() Quantization {
+	if b.quantization != nil {
+		return *b.quantization
+	}
+	return b.metadata.GetQuantization()
+}
+
+// Requantize rewrites the positions of every node with a scale of twice
+// precision and the offset at the minimum of the point bounds, see
+// NewQuantization. It returns the new quantization.
+func (b *PotreeArchive) Requantize(precision float64) (Quantization, error) {
+	if precision <= 0 {
+		return Quantization{}, fmt.Errorf("invalid precision %v", precision)
+	}
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return Quantization{}, err
+		}
+	}
+	src := *b.metadata
+	q := NewQuantization(src.tightBounds(), precision)
+	if q.Overflows() {
+		return q, fmt.Errorf("requantized positions overflow int32: %s", q)
+	}
+	update := func(meta *Metadata) {
+		offset := q.Offset
+		meta.Scale = q.Scale
+		meta.Offset = &offset
+	}
+	err := b.rewriteNodes(update, func(n *Node) error {
+		for i := range n.Attrs {
+			if n.Attrs[i].Name != POSITION.Name {
+				continue
+			}
+			position, err := requantize(&n.Attrs[i], &src, b.metadata, nil)
+			if err != nil {
+				return err
+			}
+			n.Attrs[i] = position
+		}
+		return nil
+	})
+	if err != nil {
+		return Quantization{}, err
+	}
+	q = inputQuantization(b.metadata, []*Metadata{&src})
+	b.quantization = &q
+	return q, nil
+}
@@ -0,0 +1,94 @@
+package potree
+
+import (
+	"fmt"
+)
+
+// Query collects the points of the nodes rooted at the given names, or the
+// whole tree if none are given, down to maxLevel (all levels if negative).
+// With a box only nodes intersecting it are visited and only points inside
+// it are returned. Nodes are decoded on demand with ReadNode.
+func (b *PotreeArchive) Query(box *AABB, maxLevel int, names ...string) ([]Attribute, error) {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return nil, err
+		}
+	}
+	starts := []*Node{b.root}
+	if len(names) > 0 {
+		starts = starts[:0]
+		for _, name := range names {
+			n := b.GetNode(name)
+			if n == nil {
+				return nil, fmt.Errorf("node %s not found", name)
+			}
+			starts = append(starts, n)
+		}
+	}
+
+	release, err := b.keepOpen()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ret := NewPoints(b.metadata.Attrs, 0)
+	for _, start := range starts {
+		start.Traverse(func(n *Node) bool {
+			if maxLevel >= 0 && n.Level() > maxLevel {
+				return true
+			}
+			if box != nil && !box.Intersects(n.Box) {
+				return true
+			}
+			points, rerr := b.ReadNode(n)
+			if rerr != nil {
+				err = rerr
+				return false
+			}
+			attrs, serr := b.orderAttributes(n.Name, points)
+			if serr != nil {
+				err = serr
+				return false
+			}
+			if box != nil {
+				position := FindAttribute(attrs, POSITION.Name)
+				var indices []int
+				for i := 0; i < position.Len(); i++ {
+					if box.Contains(b.metadata.WorldPosition(position, i), [3]float64{}) {
+						indices = append(indices, i)
+					}
+				}
+				for i := range attrs {
+					attrs[i] = attrs[i].Subset(indices)
+				}
+			}
+			for i := range ret {
+				ret[i].Append(&attrs[i])
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// cachedPosition returns the decoded positions of n, keeping the node in
+// cache for passes that read neighbouring nodes repeatedly.
+func (b *PotreeArchive) cachedPosition(cache *NodeCache, n *Node) (*Attribute, error) {
+	attrs, ok := cache.Get(n.Name)
+	if !ok {
+		var err error
+		if attrs, err = b.ReadNode(n); err != nil {
+			return nil, err
+		}
+		cache.Put(n.Name, attrs)
+	}
+	position := FindAttribute(attrs, POSITION.Name)
+	if position == nil {
+		return nil, &ErrSchemaMismatch{Node: n.Name, Attribute: POSITION.Name, Message: "missing"}
+	}
+	return position, nil
+}
@@ -0,0 +1,42 @@
+package potree
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+type PointReader interface {
+	GetMetadata() *Metadata
+	ReadPoints(max int) ([]Attribute, error)
+	Close() error
+}
+
+func OpenPointReader(p string) (PointReader, error) {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".las":
+		return OpenLASReader(p)
+	case ".csv", ".txt", ".xyz":
+		return OpenCSVReader(p)
+	case ".potree":
+		return OpenCPotreeReader(p)
+	}
+	return nil, fmt.Errorf("unsupported point cloud format: %s", p)
+}
+
+func ReadAllPoints(rd PointReader) ([]Attribute, error) {
+	ret := NewPoints(rd.GetMetadata().Attrs, 0)
+	for {
+		points, err := rd.ReadPoints(MaxPointsPerChunk * 10)
+		for i := range points {
+			ret[i].Append(&points[i])
+		}
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
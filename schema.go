@@ -0,0 +1,169 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// rewriteNodes decodes every node with the current metadata, applies update
+// to the metadata, lets transform change the attributes of each node to
+// match it and writes all nodes to a new octree.bin. On failure before
+// octree.bin is replaced the metadata and nodes are left as they were.
+func (b *PotreeArchive) rewriteNodes(update func(meta *Metadata), transform func(n *Node) error) error {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return err
+		}
+	}
+	oldMeta := *b.metadata
+	old := &PotreeArchive{path: b.path, metadata: &oldMeta}
+	release, err := old.keepOpen()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tmp := b.getOctreePath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	nodes := b.GetNodes()
+	saved := make([]Node, len(nodes))
+	for i, n := range nodes {
+		saved[i].ByteOffset, saved[i].ByteSize, saved[i].Buffer = n.ByteOffset, n.ByteSize, n.Buffer
+		if n.Attrs != nil {
+			saved[i].Attrs = append([]Attribute(nil), n.Attrs...)
+		}
+	}
+	oldOffset := b.octreeOffset
+	update(b.metadata)
+	b.octreeOffset = 0
+	for _, n := range nodes {
+		loaded := n.Attrs != nil
+		if !loaded {
+			if err = old.unpackNode(n); err != nil {
+				break
+			}
+		}
+		if err = transform(n); err != nil {
+			break
+		}
+		n.Buffer = nil
+		if err = b.writeOctreeNode(n, f); err != nil {
+			break
+		}
+		if !loaded {
+			n.Attrs, n.Buffer = nil, nil
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	replaced := false
+	if err == nil {
+		release()
+		replaced, err = b.commitIndex(tmp)
+	}
+	if err != nil && !replaced {
+		*b.metadata = oldMeta
+		b.octreeOffset = oldOffset
+		for i, n := range nodes {
+			n.ByteOffset, n.ByteSize = saved[i].ByteOffset, saved[i].ByteSize
+			n.Attrs, n.Buffer = saved[i].Attrs, saved[i].Buffer
+		}
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// AddAttribute appends attr to the schema and rewrites every node with the
+// values returned by compute. compute receives the decoded points of a node
+// and returns a slice of the attribute type holding NumElements values per
+// point.
+func (b *PotreeArchive) AddAttribute(attr Attribute, compute func(n *Node, points []Attribute) (interface{}, error)) error {
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return err
+		}
+	}
+	if b.metadata.Get(attr.Name) != nil {
+		return fmt.Errorf("attribute %s already exists", attr.Name)
+	}
+	if attr.GetType() == ATTR_UNDEFINED || attr.NumElements <= 0 || attr.Size != attr.NumElements*AttributeTypeSize[attr.GetType()] {
+		return &ErrSchemaMismatch{Attribute: attr.Name, Message: "invalid layout"}
+	}
+	attr.Buffer, attr.Data, attr.Min, attr.Max = nil, nil, nil, nil
+
+	schema := append(append([]Attribute(nil), b.metadata.Attrs...), attr)
+	update := func(meta *Metadata) { meta.Attrs = schema }
+	err := b.rewriteNodes(update, func(n *Node) error {
+		data, err := compute(n, n.Attrs)
+		if err != nil {
+			return err
+		}
+		tp, _, count := attributeDataPointer(data)
+		if tp != attr.GetType() || count != int(n.NumPoints)*attr.NumElements {
+			return &ErrSchemaMismatch{Node: n.Name, Attribute: attr.Name, Message: fmt.Sprintf("values must be %d %s", int(n.NumPoints)*attr.NumElements, attr.Type)}
+		}
+		values := attr
+		values.Data = data
+		n.Attrs = append(n.Attrs, values)
+		extendAttributeRanges(b.metadata, n.Attrs[len(n.Attrs)-1:])
+		return nil
+	})
+	return err
+}
+
+// AddAttributeFunc is AddAttribute with values computed point by point,
+// compute fills the NumElements values of each point.
+func (b *PotreeArchive) AddAttributeFunc(attr Attribute, compute func(p *Point, values []float64)) error {
+	return b.AddAttribute(attr, func(n *Node, points []Attribute) (interface{}, error) {
+		ret := NewPoints([]Attribute{attr}, int(n.NumPoints))[0]
+		values := make([]float64, attr.NumElements)
+		p := &Point{Node: n, meta: b.metadata, attrs: points}
+		for p.Index = 0; p.Index < int(n.NumPoints); p.Index++ {
+			for e := range values {
+				values[e] = 0
+			}
+			compute(p, values)
+			for e, v := range values {
+				ret.SetFloat64(p.Index, e, v)
+			}
+		}
+		return ret.Data, nil
+	})
+}
+
+// RemoveAttribute drops the named attribute from the schema and every node.
+func (b *PotreeArchive) RemoveAttribute(name string) error {
+	if name == POSITION.Name {
+		return errors.New("position can't be removed")
+	}
+	if b.root == nil {
+		if err := b.LoadHierarchy(); err != nil {
+			return err
+		}
+	}
+	if b.metadata.Get(name) == nil {
+		return fmt.Errorf("attribute %s not found", name)
+	}
+	var schema []Attribute
+	for _, attr := range b.metadata.Attrs {
+		if attr.Name != name {
+			schema = append(schema, attr)
+		}
+	}
+	update := func(meta *Metadata) { meta.Attrs = schema }
+	return b.rewriteNodes(update, func(n *Node) error {
+		var attrs []Attribute
+		for _, attr := range n.Attrs {
+			if attr.Name != name {
+				attrs = append(attrs, attr)
+			}
+		}
+		n.Attrs = attrs
+		return nil
+	})
+}
@@ -0,0 +1,191 @@
+package potree
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type servedFile interface {
+	open() (io.ReadSeeker, func() error, time.Time, string, error)
+}
+
+type diskFile string
+
+func (f diskFile) open() (io.ReadSeeker, func() error, time.Time, string, error) {
+	fd, err := os.Open(string(f))
+	if err != nil {
+		return nil, nil, time.Time{}, "", err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, nil, time.Time{}, "", err
+	}
+	etag := fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+	return fd, fd.Close, info.ModTime(), etag, nil
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+	etag    string
+}
+
+func newMemoryFile(data []byte) *memoryFile {
+	h := fnv.New64a()
+	h.Write(data)
+	return &memoryFile{data: data, modTime: time.Now(), etag: fmt.Sprintf(`"%x"`, h.Sum64())}
+}
+
+func (f *memoryFile) open() (io.ReadSeeker, func() error, time.Time, string, error) {
+	return bytes.NewReader(f.data), func() error { return nil }, f.modTime, f.etag, nil
+}
+
+// Server serves archives in the three file layout read by the Potree
+// viewer, each under its own path prefix. Directories are served from disk,
+// single-file .potree containers are converted in memory when added.
+type Server struct {
+	AllowOrigin string
+	mu          sync.RWMutex
+	archives    map[string]map[string]servedFile
+}
+
+func NewServer() *Server {
+	return &Server{AllowOrigin: "*", archives: make(map[string]map[string]servedFile)}
+}
+
+func servedPrefix(prefix string) string {
+	return strings.TrimSuffix(path.Join("/", prefix), "/") + "/"
+}
+
+// Add serves the archive directory or .potree file p under prefix.
+func (s *Server) Add(prefix string, p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]servedFile)
+	if info.IsDir() {
+		for _, name := range []string{MetadataName, HierarchyName, OctreeName} {
+			if !FileExists(path.Join(p, name)) {
+				return fmt.Errorf("%s: %s not found", p, name)
+			}
+			files[name] = diskFile(path.Join(p, name))
+		}
+	} else {
+		octree, hierarchy, metadata, err := convertInMemory(p)
+		if err != nil {
+			return err
+		}
+		files[OctreeName] = newMemoryFile(octree)
+		files[HierarchyName] = newMemoryFile(hierarchy)
+		files[MetadataName] = newMemoryFile(metadata)
+	}
+
+	s.mu.Lock()
+	s.archives[servedPrefix(prefix)] = files
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Server) Remove(prefix string) {
+	s.mu.Lock()
+	delete(s.archives, servedPrefix(prefix))
+	s.mu.Unlock()
+}
+
+func (s *Server) Prefixes() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make([]string, 0, len(s.archives))
+	for prefix := range s.archives {
+		ret = append(ret, prefix)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func convertInMemory(p string) ([]byte, []byte, []byte, error) {
+	rd, err := OpenPointReader(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rd.Close()
+
+	arch, err := buildArchive([]PointReader{rd}, &Options{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	octree, hierarchy, metadata := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	if err := arch.Write(octree, hierarchy, metadata); err != nil {
+		return nil, nil, nil, err
+	}
+	return octree.Bytes(), hierarchy.Bytes(), metadata.Bytes(), nil
+}
+
+func (s *Server) lookup(p string) (servedFile, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	best := ""
+	for prefix := range s.archives {
+		if strings.HasPrefix(p, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return nil, ""
+	}
+	name := strings.TrimPrefix(p, best)
+	return s.archives[best][name], name
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.AllowOrigin != "" {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", s.AllowOrigin)
+		h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Range, If-None-Match, If-Modified-Since, If-Range")
+		h.Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag")
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, name := s.lookup(path.Clean("/" + r.URL.Path))
+	if f == nil {
+		http.NotFound(w, r)
+		return
+	}
+	content, closer, modTime, etag, err := f.open()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer closer()
+
+	if name == MetadataName {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, name, modTime, content)
+}
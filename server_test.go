@@ -0,0 +1,74 @@
+package potree
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+
+	srv := NewServer()
+	if err := srv.Add("test", arch.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Add("sample", "cpotree_2.0.potree"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	octree, err := ioutil.ReadFile(arch.getOctreePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/test/octree.bin", nil)
+	req.Header.Set("Range", "bytes=10-19")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != string(octree[10:20]) {
+		t.Fatalf("range request returned %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatal("missing CORS header")
+	}
+
+	etag := resp.Header.Get("ETag")
+	req, _ = http.NewRequest("GET", ts.URL+"/test/octree.bin", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if etag == "" || resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("conditional request returned %d for etag %q", resp.StatusCode, etag)
+	}
+
+	resp, err = http.Get(ts.URL + "/sample/metadata.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &Metadata{}
+	err = meta.readMetadata(resp.Body)
+	resp.Body.Close()
+	if err != nil || meta.Points == nil || *meta.Points != 24666 {
+		t.Fatalf("unexpected converted metadata: %v", err)
+	}
+
+	resp, err = http.Get(ts.URL + "/test/other.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown file returned %d", resp.StatusCode)
+	}
+}
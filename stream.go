@@ -0,0 +1,139 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// StreamWriter writes an archive node by node without keeping points in
+// memory. Payloads are appended to octree.bin as soon as a node is written,
+// only the hierarchy records are kept until Close writes hierarchy.bin and
+// metadata.json. The BoundingBox of the metadata must be the octree bounds.
+type StreamWriter struct {
+	arch   *PotreeArchive
+	mu     sync.Mutex
+	octree *os.File
+	offset int64
+	nodes  map[string]*Node
+	closed bool
+}
+
+func NewStreamWriter(dir string, meta *Metadata) (*StreamWriter, error) {
+	if FindAttribute(meta.Attrs, POSITION.Name) == nil {
+		return nil, errors.New("schema has no position attribute")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	arch := NewArchive(dir)
+	arch.SetMetadata(meta)
+	octree, err := os.Create(arch.getOctreePath())
+	if err != nil {
+		return nil, err
+	}
+	if meta.Spacing == nil {
+		spacing := meta.BoundingBox.Size()[0] / SamplingGridSize
+		meta.Spacing = &spacing
+	}
+	updateAttributeRanges(meta, nil)
+	return &StreamWriter{arch: arch, octree: octree, nodes: make(map[string]*Node)}, nil
+}
+
+// GetArchive returns the archive being written, its hierarchy is available
+// after Close.
+func (w *StreamWriter) GetArchive() *PotreeArchive {
+	return w.arch
+}
+
+func validNodeName(name string) bool {
+	if len(name) == 0 || name[0] != 'r' {
+		return false
+	}
+	for _, c := range name[1:] {
+		if c < '0' || c > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteNode encodes the points of the named node and appends them to
+// octree.bin. Nodes may be written in any order and from multiple
+// goroutines, but each node only once. Ancestors that are never written are
+// stored without points.
+func (w *StreamWriter) WriteNode(name string, points []Attribute) error {
+	if !validNodeName(name) {
+		return fmt.Errorf("invalid node name %q", name)
+	}
+	n := &Node{Name: name}
+	n.Attrs = points
+	buf, err := w.arch.encodeNode(n)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("stream writer is closed")
+	}
+	if _, ok := w.nodes[name]; ok {
+		return fmt.Errorf("node %s written twice", name)
+	}
+	if err := n.write(w.offset, buf, w.octree); err != nil {
+		return err
+	}
+	w.offset += n.ByteSize
+	extendAttributeRanges(w.arch.metadata, points)
+	n.Attrs = nil
+	w.nodes[name] = n
+	return nil
+}
+
+// Close links the written nodes into a tree and writes hierarchy.bin and
+// metadata.json.
+func (w *StreamWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.octree.Close(); err != nil {
+		return err
+	}
+
+	for name := range w.nodes {
+		for p := name[:len(name)-1]; len(p) > 0; p = p[:len(p)-1] {
+			if _, ok := w.nodes[p]; !ok {
+				w.nodes[p] = &Node{Name: p}
+			}
+		}
+	}
+	if _, ok := w.nodes["r"]; !ok {
+		w.nodes["r"] = &Node{Name: "r"}
+	}
+	names := make([]string, 0, len(w.nodes))
+	for name := range w.nodes {
+		names = append(names, name)
+	}
+	sort.Sort(nodeNames(names))
+	for _, name := range names {
+		n := w.nodes[name]
+		if name == "r" {
+			n.Box = w.arch.metadata.BoundingBox
+			continue
+		}
+		idx := int(name[len(name)-1] - '0')
+		n.Parent = w.nodes[name[:len(name)-1]]
+		n.Parent.Childs[idx] = n
+		n.Box = n.Parent.Box.Child(idx)
+	}
+
+	w.arch.root = w.nodes["r"]
+	w.arch.nodeMaps = w.nodes
+	return w.arch.writeIndex()
+}
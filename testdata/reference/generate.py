@@ -0,0 +1,259 @@
+#!/usr/bin/env python3
+"""Writes the Potree 2.0 reference archives in this directory.
+
+The archives are built from the format description of PotreeConverter 2.x and
+the decoders of the Potree viewer, without any code of this package:
+
+- hierarchy.bin holds 22 byte records (type, child mask, point count, byte
+  offset, byte size) in breadth first order per chunk, node r7 is a proxy
+  whose chunk follows the first one.
+- DEFAULT payloads interleave the attributes point by point.
+- BROTLI payloads store the attributes one after the other with the points
+  sorted by Morton code, positions as 4 uint32 words and colors as 2 uint32
+  words laid out as DecoderWorker_brotli.js reads them. The brotli streams
+  use uncompressed meta-blocks so that no brotli encoder is needed.
+
+expected.json records the hierarchy records and the decoded points of every
+node. Run python3 generate.py to rewrite them, the Go tests never do.
+"""
+
+import json
+import os
+import struct
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+SCALE = 0.001
+BOX_MIN = [500000.0, 4000000.0, 0.0]
+# 2^18 m, so integer coordinates reach 2^28 and use the upper Morton words
+BOX_SIZE = 262144.0
+
+ATTRIBUTES = [
+    ("position", "int32", 3, 4),
+    ("intensity", "uint16", 1, 2),
+    ("classification", "uint8", 1, 1),
+    ("rgb", "uint16", 3, 2),
+]
+
+# name: number of points; r7 starts the second hierarchy chunk
+NODES = [("r", 20), ("r0", 15), ("r3", 12), ("r7", 10), ("r70", 8), ("r77", 6)]
+FIRST_CHUNK = ["r", "r0", "r3", "r7"]
+SECOND_CHUNK = ["r7", "r70", "r77"]
+
+NT_NORMAL, NT_LEAF, NT_PROXY = 0, 1, 2
+
+
+class Random:
+    """A linear congruential generator, so the archives never change."""
+
+    def __init__(self, seed):
+        self.state = seed
+
+    def next(self, n):
+        self.state = (self.state * 6364136223846793005 + 1442695040888963407) % (1 << 64)
+        return (self.state >> 33) % n
+
+
+def node_box(name):
+    lo = [0, 0, 0]
+    size = int(BOX_SIZE / SCALE)
+    for c in name[1:]:
+        size //= 2
+        index = int(c)
+        for axis, bit in enumerate((4, 2, 1)):
+            if index & bit:
+                lo[axis] += size
+    return lo, size
+
+
+def interleave(x, y, z, bits):
+    code = 0
+    for i in range(bits):
+        code |= ((x >> i) & 1) << (3 * i)
+        code |= ((y >> i) & 1) << (3 * i + 1)
+        code |= ((z >> i) & 1) << (3 * i + 2)
+    return code
+
+
+def position_words(x, y, z):
+    lower = interleave(x & 0xFFFF, y & 0xFFFF, z & 0xFFFF, 16)
+    upper = interleave(x >> 16, y >> 16, z >> 16, 16)
+    # DecoderWorker_brotli.js: mc_1, mc_0, mc_3, mc_2 at byte 0, 4, 8, 12
+    mc_0, mc_1 = upper >> 32, upper & 0xFFFFFFFF
+    mc_2, mc_3 = lower >> 32, lower & 0xFFFFFFFF
+    # the viewer only adds the upper words if mc_1 or mc_2 is set
+    assert upper == 0 or mc_1 != 0 or mc_2 != 0, (x, y, z)
+    return (upper, lower), struct.pack("<4I", mc_1, mc_0, mc_3, mc_2)
+
+
+def rgb_words(r, g, b):
+    code = interleave(r, g, b, 16)
+    return struct.pack("<2I", code & 0xFFFFFFFF, code >> 32)
+
+
+def make_points(name, count, rnd):
+    lo, size = node_box(name)
+    points = []
+    for _ in range(count):
+        position = [lo[k] + rnd.next(size) for k in range(3)]
+        points.append({
+            "position": position,
+            "intensity": [rnd.next(65536)],
+            "classification": [rnd.next(32)],
+            "rgb": [rnd.next(65536) for _ in range(3)],
+        })
+    return points
+
+
+def default_payload(points):
+    out = b""
+    for p in points:
+        out += struct.pack("<3i", *p["position"])
+        out += struct.pack("<H", p["intensity"][0])
+        out += struct.pack("<B", p["classification"][0])
+        out += struct.pack("<3H", *p["rgb"])
+    return out
+
+
+def brotli_payload(points):
+    points.sort(key=lambda p: position_words(*p["position"])[0])
+    out = b"".join(position_words(*p["position"])[1] for p in points)
+    out += b"".join(struct.pack("<H", p["intensity"][0]) for p in points)
+    out += b"".join(struct.pack("<B", p["classification"][0]) for p in points)
+    out += b"".join(rgb_words(*p["rgb"]) for p in points)
+    return brotli_stored(out)
+
+
+class BitWriter:
+    def __init__(self):
+        self.data = bytearray()
+        self.bits = 0
+
+    def write(self, value, n):
+        for i in range(n):
+            if self.bits % 8 == 0:
+                self.data.append(0)
+            self.data[-1] |= ((value >> i) & 1) << (self.bits % 8)
+            self.bits += 1
+
+    def align(self):
+        self.bits = len(self.data) * 8
+
+
+def brotli_stored(data):
+    """Wraps data in a brotli stream of uncompressed meta-blocks (RFC 7932)."""
+    w = BitWriter()
+    w.write(0, 1)  # WBITS 16
+    for start in range(0, len(data), 1 << 16):
+        block = data[start:start + (1 << 16)]
+        w.write(0, 1)  # ISLAST
+        w.write(0, 2)  # MNIBBLES 4
+        w.write(len(block) - 1, 16)
+        w.write(1, 1)  # ISUNCOMPRESSED
+        w.align()
+        w.data += block
+        w.bits = len(w.data) * 8
+    w.write(1, 1)  # ISLAST
+    w.write(1, 1)  # ISLASTEMPTY
+    return bytes(w.data)
+
+
+def child_mask(name):
+    mask = 0
+    for other, _ in NODES:
+        if len(other) == len(name) + 1 and other.startswith(name):
+            mask |= 1 << int(other[-1])
+    return mask
+
+
+def record(kind, mask, num_points, offset, size):
+    return struct.pack("<BBIqq", kind, mask, num_points, offset, size)
+
+
+def write_archive(encoding, nodes):
+    directory = os.path.join(HERE, encoding)
+    os.makedirs(directory, exist_ok=True)
+
+    octree = b""
+    placed = {}
+    for name, points in nodes:
+        payload = default_payload(points) if encoding == "DEFAULT" else brotli_payload(points)
+        placed[name] = (len(octree), len(payload))
+        octree += payload
+
+    def node_record(name, kind=None):
+        mask = child_mask(name)
+        if kind is None:
+            kind = NT_NORMAL if mask else NT_LEAF
+        offset, size = placed[name]
+        return record(kind, mask, len(dict(nodes)[name]), offset, size)
+
+    second = b"".join(node_record(n) for n in SECOND_CHUNK)
+    first_size = len(FIRST_CHUNK) * 22
+    first = b"".join(node_record(n) for n in FIRST_CHUNK[:-1])
+    first += record(NT_PROXY, child_mask("r7"), len(dict(nodes)["r7"]), first_size, len(second))
+    hierarchy = first + second
+
+    with open(os.path.join(directory, "octree.bin"), "wb") as f:
+        f.write(octree)
+    with open(os.path.join(directory, "hierarchy.bin"), "wb") as f:
+        f.write(hierarchy)
+
+    all_points = [p for _, points in nodes for p in points]
+    attributes = []
+    for name, typ, num, elsize in ATTRIBUTES:
+        values = [p[name] for p in all_points]
+        lo = [min(v[e] for v in values) for e in range(num)]
+        hi = [max(v[e] for v in values) for e in range(num)]
+        if name == "position":
+            lo = [BOX_MIN[e] + lo[e] * SCALE for e in range(3)]
+            hi = [BOX_MIN[e] + hi[e] * SCALE for e in range(3)]
+        attributes.append({
+            "name": name, "description": "", "size": num * elsize, "numElements": num,
+            "elementSize": elsize, "type": typ, "min": lo, "max": hi,
+        })
+    metadata = {
+        "version": "2.0",
+        "name": "reference " + encoding,
+        "description": "",
+        "points": len(all_points),
+        "projection": "",
+        "hierarchy": {"firstChunkSize": first_size, "stepSize": 1, "depth": 2},
+        "offset": BOX_MIN,
+        "scale": [SCALE, SCALE, SCALE],
+        "spacing": BOX_SIZE / 128,
+        "boundingBox": {"min": BOX_MIN, "max": [v + BOX_SIZE for v in BOX_MIN]},
+        "encoding": encoding,
+        "bytesPerPoint": sum(a["size"] for a in attributes),
+        "attributes": attributes,
+    }
+    with open(os.path.join(directory, "metadata.json"), "w") as f:
+        json.dump(metadata, f, indent="\t")
+        f.write("\n")
+
+    expected = []
+    for name, points in nodes:
+        kind = NT_NORMAL if child_mask(name) else NT_LEAF
+        offset, size = placed[name]
+        entry = {"name": name, "type": kind, "childMask": child_mask(name),
+                 "numPoints": len(points), "byteOffset": offset, "byteSize": size}
+        for attr, _, _, _ in ATTRIBUTES:
+            entry[attr] = [v for p in points for v in p[attr]]
+        expected.append(entry)
+    return {"hierarchySize": len(hierarchy), "octreeSize": len(octree), "nodes": expected}
+
+
+def main():
+    rnd = Random(2)
+    nodes = [(name, make_points(name, count, rnd)) for name, count in NODES]
+    expected = {}
+    for encoding in ("DEFAULT", "BROTLI"):
+        copies = [(name, [dict(p) for p in points]) for name, points in nodes]
+        expected[encoding] = write_archive(encoding, copies)
+    with open(os.path.join(HERE, "expected.json"), "w") as f:
+        json.dump(expected, f, indent=1)
+        f.write("\n")
+
+
+if __name__ == "__main__":
+    main()
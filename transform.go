@@ -0,0 +1,514 @@
+package potree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	vec3d "github.com/flywave/go3d/float64/vec3"
+)
+
+func (e Ellipsoid) flattening() float64 {
+	if e.InvF == 0 {
+		return 0
+	}
+	return 1 / e.InvF
+}
+
+// E2 returns the squared first eccentricity.
+func (e Ellipsoid) E2() float64 {
+	f := e.flattening()
+	return f * (2 - f)
+}
+
+// B returns the semi-minor axis.
+func (e Ellipsoid) B() float64 {
+	return e.A * (1 - e.flattening())
+}
+
+// GeodeticToGeocentric converts longitude and latitude in radians and the
+// ellipsoidal height to geocentric coordinates.
+func (e Ellipsoid) GeodeticToGeocentric(lon, lat, h float64) [3]float64 {
+	e2 := e.E2()
+	sinLat, cosLat := math.Sincos(lat)
+	n := e.A / math.Sqrt(1-e2*sinLat*sinLat)
+	return [3]float64{(n + h) * cosLat * math.Cos(lon), (n + h) * cosLat * math.Sin(lon), (n*(1-e2) + h) * sinLat}
+}
+
+// GeocentricToGeodetic is the inverse of GeodeticToGeocentric using
+// Bowring's formula.
+func (e Ellipsoid) GeocentricToGeodetic(p [3]float64) (lon, lat, h float64) {
+	a, b, e2 := e.A, e.B(), e.E2()
+	lon = math.Atan2(p[1], p[0])
+	r := math.Hypot(p[0], p[1])
+	ep2 := e2 / (1 - e2)
+	theta := math.Atan2(p[2]*a, r*b)
+	sinT, cosT := math.Sincos(theta)
+	lat = math.Atan2(p[2]+ep2*b*sinT*sinT*sinT, r-e2*a*cosT*cosT*cosT)
+	sinLat, cosLat := math.Sincos(lat)
+	n := a / math.Sqrt(1-e2*sinLat*sinLat)
+	if math.Abs(cosLat) > 1e-10 {
+		h = r/cosLat - n
+	} else {
+		h = math.Abs(p[2]) - b
+	}
+	return lon, lat, h
+}
+
+// helmert is a seven parameter similarity transformation of geocentric
+// coordinates.
+type helmert struct {
+	t [3]float64
+	m [3][3]float64
+}
+
+func newHelmert(params []float64) *helmert {
+	p := make([]float64, 7)
+	copy(p, params)
+	sec := math.Pi / (180 * 3600)
+	rx, ry, rz, s := p[3]*sec, p[4]*sec, p[5]*sec, 1+p[6]*1e-6
+	return &helmert{
+		t: [3]float64{p[0], p[1], p[2]},
+		m: [3][3]float64{
+			{s, -s * rz, s * ry},
+			{s * rz, s, -s * rx},
+			{-s * ry, s * rx, s},
+		},
+	}
+}
+
+func (h *helmert) forward(p [3]float64) [3]float64 {
+	var ret [3]float64
+	for i := 0; i < 3; i++ {
+		ret[i] = h.t[i] + h.m[i][0]*p[0] + h.m[i][1]*p[1] + h.m[i][2]*p[2]
+	}
+	return ret
+}
+
+func (h *helmert) inverse(p [3]float64) [3]float64 {
+	m := h.m
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) - m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) + m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	inv := [3][3]float64{
+		{m[1][1]*m[2][2] - m[1][2]*m[2][1], m[0][2]*m[2][1] - m[0][1]*m[2][2], m[0][1]*m[1][2] - m[0][2]*m[1][1]},
+		{m[1][2]*m[2][0] - m[1][0]*m[2][2], m[0][0]*m[2][2] - m[0][2]*m[2][0], m[0][2]*m[1][0] - m[0][0]*m[1][2]},
+		{m[1][0]*m[2][1] - m[1][1]*m[2][0], m[0][1]*m[2][0] - m[0][0]*m[2][1], m[0][0]*m[1][1] - m[0][1]*m[1][0]},
+	}
+	d := [3]float64{p[0] - h.t[0], p[1] - h.t[1], p[2] - h.t[2]}
+	var ret [3]float64
+	for i := 0; i < 3; i++ {
+		ret[i] = (inv[i][0]*d[0] + inv[i][1]*d[1] + inv[i][2]*d[2]) / det
+	}
+	return ret
+}
+
+// projection maps longitude and latitude in radians to metres.
+type projection interface {
+	forward(lon, lat float64) (float64, float64)
+	inverse(x, y float64) (float64, float64)
+}
+
+func newProjection(c *CRS) (projection, error) {
+	e := c.Datum.Ellipsoid
+	lat0, lon0 := c.Lat0*UNIT_DEGREE, c.Lon0*UNIT_DEGREE
+	switch c.Method {
+	case PROJ_TMERC:
+		return newTransverseMercator(e, lat0, lon0, c.K0, c.FalseEasting, c.FalseNorthing), nil
+	case PROJ_MERC:
+		k0 := c.K0
+		if c.LatTS != 0 {
+			sin, cos := math.Sincos(c.LatTS * UNIT_DEGREE)
+			k0 = cos / math.Sqrt(1-e.E2()*sin*sin)
+		}
+		return &mercator{a: e.A, e: math.Sqrt(e.E2()), lon0: lon0, k0: k0, x0: c.FalseEasting, y0: c.FalseNorthing}, nil
+	case PROJ_WEBMERC:
+		return &mercator{a: e.A, lon0: lon0, k0: 1, x0: c.FalseEasting, y0: c.FalseNorthing}, nil
+	case PROJ_SOMERC:
+		return newSwissObliqueMercator(e, lat0, lon0, c.K0, c.FalseEasting, c.FalseNorthing), nil
+	}
+	return nil, fmt.Errorf("unsupported projection %s", c.Method)
+}
+
+// transverseMercator uses the Krüger series to fourth order in n, accurate
+// to a millimetre within a few thousand kilometres of the central meridian.
+type transverseMercator struct {
+	lon0, k0, x0, y0 float64
+	e, n, a          float64
+	alpha, beta      [4]float64
+	delta            [4]float64
+	q0               float64
+}
+
+func newTransverseMercator(e Ellipsoid, lat0, lon0, k0, x0, y0 float64) *transverseMercator {
+	f := e.flattening()
+	n := f / (2 - f)
+	n2, n3, n4 := n*n, n*n*n, n*n*n*n
+	t := &transverseMercator{lon0: lon0, k0: k0, x0: x0, y0: y0, e: math.Sqrt(e.E2()), n: n}
+	t.a = e.A / (1 + n) * (1 + n2/4 + n4/64)
+	t.alpha = [4]float64{n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180, 13*n2/48 - 3*n3/5 + 557*n4/1440, 61*n3/240 - 103*n4/140, 49561 * n4 / 161280}
+	t.beta = [4]float64{n/2 - 2*n2/3 + 37*n3/96 - n4/360, n2/48 + n3/15 - 437*n4/1440, 17*n3/480 - 37*n4/840, 4397 * n4 / 161280}
+	t.delta = [4]float64{2*n - 2*n2/3 - 2*n3 + 116*n4/45, 7*n2/3 - 8*n3/5 - 227*n4/45, 56*n3/15 - 136*n4/35, 4279 * n4 / 630}
+	xi0 := t.conformal(lat0)
+	t.q0 = xi0
+	for j := 0; j < 4; j++ {
+		t.q0 += t.alpha[j] * math.Sin(2*float64(j+1)*xi0)
+	}
+	return t
+}
+
+func (t *transverseMercator) conformal(lat float64) float64 {
+	sin := math.Sin(lat)
+	return math.Atan(math.Sinh(math.Atanh(sin) - t.e*math.Atanh(t.e*sin)))
+}
+
+func (t *transverseMercator) forward(lon, lat float64) (float64, float64) {
+	chi := t.conformal(lat)
+	dl := lon - t.lon0
+	xi := math.Atan2(math.Tan(chi), math.Cos(dl))
+	eta := math.Atanh(math.Cos(chi) * math.Sin(dl))
+	x, y := eta, xi
+	for j := 0; j < 4; j++ {
+		k := 2 * float64(j+1)
+		x += t.alpha[j] * math.Cos(k*xi) * math.Sinh(k*eta)
+		y += t.alpha[j] * math.Sin(k*xi) * math.Cosh(k*eta)
+	}
+	return t.x0 + t.k0*t.a*x, t.y0 + t.k0*t.a*(y-t.q0)
+}
+
+func (t *transverseMercator) inverse(x, y float64) (float64, float64) {
+	xi := (y-t.y0)/(t.k0*t.a) + t.q0
+	eta := (x - t.x0) / (t.k0 * t.a)
+	xip, etap := xi, eta
+	for j := 0; j < 4; j++ {
+		k := 2 * float64(j+1)
+		xip -= t.beta[j] * math.Sin(k*xi) * math.Cosh(k*eta)
+		etap -= t.beta[j] * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+	chi := math.Asin(math.Sin(xip) / math.Cosh(etap))
+	lat := chi
+	for j := 0; j < 4; j++ {
+		lat += t.delta[j] * math.Sin(2*float64(j+1)*chi)
+	}
+	return t.lon0 + math.Atan2(math.Sinh(etap), math.Cos(xip)), lat
+}
+
+// mercator is the normal Mercator projection, e is zero for the spherical
+// Web Mercator.
+type mercator struct {
+	a, e, lon0, k0, x0, y0 float64
+}
+
+func (m *mercator) forward(lon, lat float64) (float64, float64) {
+	if math.Abs(lat) > math.Pi/2-1e-10 {
+		return math.NaN(), math.NaN()
+	}
+	es := m.e * math.Sin(lat)
+	y := math.Log(math.Tan(math.Pi/4+lat/2) * math.Pow((1-es)/(1+es), m.e/2))
+	return m.x0 + m.a*m.k0*(lon-m.lon0), m.y0 + m.a*m.k0*y
+}
+
+func (m *mercator) inverse(x, y float64) (float64, float64) {
+	t := math.Exp(-(y - m.y0) / (m.a * m.k0))
+	lat := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15 && m.e != 0; i++ {
+		es := m.e * math.Sin(lat)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-es)/(1+es), m.e/2))
+		if math.Abs(next-lat) < 1e-12 {
+			lat = next
+			break
+		}
+		lat = next
+	}
+	return m.lon0 + (x-m.x0)/(m.a*m.k0), lat
+}
+
+// swissObliqueMercator follows the somerc projection of PROJ used by the
+// Swiss LV03 and LV95 grids.
+type swissObliqueMercator struct {
+	a, e, es, lon0, x0, y0 float64
+	c, k, kr, sinp0, cosp0 float64
+}
+
+func newSwissObliqueMercator(e Ellipsoid, lat0, lon0, k0, x0, y0 float64) *swissObliqueMercator {
+	s := &swissObliqueMercator{a: e.A, es: e.E2(), e: math.Sqrt(e.E2()), lon0: lon0, x0: x0, y0: y0}
+	cp := math.Cos(lat0)
+	cp *= cp
+	s.c = math.Sqrt(1 + s.es*cp*cp/(1-s.es))
+	sp := math.Sin(lat0)
+	s.sinp0 = sp / s.c
+	phip0 := math.Asin(s.sinp0)
+	s.cosp0 = math.Cos(phip0)
+	sp *= s.e
+	s.k = math.Log(math.Tan(math.Pi/4+phip0/2)) - s.c*(math.Log(math.Tan(math.Pi/4+lat0/2))-s.e/2*math.Log((1+sp)/(1-sp)))
+	s.kr = k0 * math.Sqrt(1-s.es) / (1 - sp*sp)
+	return s
+}
+
+func (s *swissObliqueMercator) forward(lon, lat float64) (float64, float64) {
+	sp := s.e * math.Sin(lat)
+	phip := 2*math.Atan(math.Exp(s.c*(math.Log(math.Tan(math.Pi/4+lat/2))-s.e/2*math.Log((1+sp)/(1-sp)))+s.k)) - math.Pi/2
+	lamp := s.c * (lon - s.lon0)
+	cp := math.Cos(phip)
+	phipp := math.Asin(s.cosp0*math.Sin(phip) - s.sinp0*cp*math.Cos(lamp))
+	lampp := math.Asin(cp * math.Sin(lamp) / math.Cos(phipp))
+	return s.x0 + s.a*s.kr*lampp, s.y0 + s.a*s.kr*math.Log(math.Tan(math.Pi/4+phipp/2))
+}
+
+func (s *swissObliqueMercator) inverse(x, y float64) (float64, float64) {
+	phipp := 2 * (math.Atan(math.Exp((y-s.y0)/s.a/s.kr)) - math.Pi/4)
+	lampp := (x - s.x0) / s.a / s.kr
+	cp := math.Cos(phipp)
+	phip := math.Asin(s.cosp0*math.Sin(phipp) + s.sinp0*cp*math.Cos(lampp))
+	lamp := math.Asin(cp * math.Sin(lampp) / math.Cos(phip))
+	con := (s.k - math.Log(math.Tan(math.Pi/4+phip/2))) / s.c
+	for i := 0; i < 10; i++ {
+		esp := s.e * math.Sin(phip)
+		delp := (con + math.Log(math.Tan(math.Pi/4+phip/2)) - s.e/2*math.Log((1+esp)/(1-esp))) * (1 - esp*esp) * math.Cos(phip) / (1 - s.es)
+		phip -= delp
+		if math.Abs(delp) < 1e-12 {
+			break
+		}
+	}
+	return s.lon0 + lamp/s.c, phip
+}
+
+// Transform converts coordinates from one CRS to another through geodetic
+// coordinates, with a Helmert transformation over WGS 84 when the datums
+// differ. Heights are ellipsoidal, vertical CRSs only change their unit as
+// no geoid models are applied.
+type Transform struct {
+	src, dst         *CRS
+	srcProj, dstProj projection
+	srcShift         *helmert
+	dstShift         *helmert
+}
+
+func sameDatum(a, b *Datum) bool {
+	return sameFloat(a.Ellipsoid.A, b.Ellipsoid.A) && sameFloat(a.Ellipsoid.InvF, b.Ellipsoid.InvF) && sameHelmert(a.ToWGS84, b.ToWGS84)
+}
+
+// NewTransform returns the transform from src to dst, an error if the datum
+// shift between them is unknown or a projection isn't supported.
+func NewTransform(src, dst *CRS) (*Transform, error) {
+	if src == nil || dst == nil {
+		return nil, errors.New("transform needs a source and target CRS")
+	}
+	t := &Transform{src: src, dst: dst}
+	var err error
+	if src.Type == CRS_PROJECTED {
+		if t.srcProj, err = newProjection(src); err != nil {
+			return nil, err
+		}
+	}
+	if dst.Type == CRS_PROJECTED {
+		if t.dstProj, err = newProjection(dst); err != nil {
+			return nil, err
+		}
+	}
+	if !sameDatum(&src.Datum, &dst.Datum) {
+		if src.Datum.ToWGS84 == nil || dst.Datum.ToWGS84 == nil {
+			return nil, fmt.Errorf("no datum shift from %s to %s", src.Datum.Name, dst.Datum.Name)
+		}
+		t.srcShift, t.dstShift = newHelmert(src.Datum.ToWGS84), newHelmert(dst.Datum.ToWGS84)
+	}
+	return t, nil
+}
+
+// GetSource returns the CRS converted from.
+func (t *Transform) GetSource() *CRS {
+	return t.src
+}
+
+// GetTarget returns the CRS converted to.
+func (t *Transform) GetTarget() *CRS {
+	return t.dst
+}
+
+func (t *Transform) toGeodetic(p [3]float64) (float64, float64, float64) {
+	c := t.src
+	_, vf := c.GetVerticalUnit()
+	switch c.Type {
+	case CRS_GEOCENTRIC:
+		return c.Datum.Ellipsoid.GeocentricToGeodetic([3]float64{p[0] * c.UnitFactor, p[1] * c.UnitFactor, p[2] * c.UnitFactor})
+	case CRS_GEOGRAPHIC:
+		if c.IsLatLon() {
+			p[0], p[1] = p[1], p[0]
+		}
+		return p[0] * c.UnitFactor, p[1] * c.UnitFactor, p[2] * vf
+	}
+	lon, lat := t.srcProj.inverse(p[0]*c.UnitFactor, p[1]*c.UnitFactor)
+	return lon, lat, p[2] * vf
+}
+
+func (t *Transform) fromGeodetic(lon, lat, h float64) [3]float64 {
+	c := t.dst
+	_, vf := c.GetVerticalUnit()
+	switch c.Type {
+	case CRS_GEOCENTRIC:
+		p := c.Datum.Ellipsoid.GeodeticToGeocentric(lon, lat, h)
+		return [3]float64{p[0] / c.UnitFactor, p[1] / c.UnitFactor, p[2] / c.UnitFactor}
+	case CRS_GEOGRAPHIC:
+		if c.IsLatLon() {
+			return [3]float64{lat / c.UnitFactor, lon / c.UnitFactor, h / vf}
+		}
+		return [3]float64{lon / c.UnitFactor, lat / c.UnitFactor, h / vf}
+	}
+	x, y := t.dstProj.forward(lon, lat)
+	return [3]float64{x / c.UnitFactor, y / c.UnitFactor, h / vf}
+}
+
+// Apply converts p given in the axis order and units of the source CRS.
+func (t *Transform) Apply(p [3]float64) ([3]float64, error) {
+	lon, lat, h := t.toGeodetic(p)
+	if t.srcShift != nil {
+		xyz := t.src.Datum.Ellipsoid.GeodeticToGeocentric(lon, lat, h)
+		xyz = t.dstShift.inverse(t.srcShift.forward(xyz))
+		lon, lat, h = t.dst.Datum.Ellipsoid.GeocentricToGeodetic(xyz)
+	}
+	ret := t.fromGeodetic(lon, lat, h)
+	for k := 0; k < 3; k++ {
+		if math.IsNaN(ret[k]) || math.IsInf(ret[k], 0) {
+			return ret, fmt.Errorf("%v can't be converted from %s to %s", p, t.src, t.dst)
+		}
+	}
+	return ret, nil
+}
+
+// TransformBox returns the bounds of box in the target CRS, sampled on a
+// grid as edges don't stay straight.
+func (t *Transform) TransformBox(box AABB) (AABB, error) {
+	const steps = 4
+	ret := AABB{Min: [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, Max: [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}}
+	size := box.Size()
+	for i := 0; i <= steps; i++ {
+		for j := 0; j <= steps; j++ {
+			for k := 0; k <= steps; k++ {
+				p := [3]float64{box.Min[0] + size[0]*float64(i)/steps, box.Min[1] + size[1]*float64(j)/steps, box.Min[2] + size[2]*float64(k)/steps}
+				q, err := t.Apply(p)
+				if err != nil {
+					return AABB{}, err
+				}
+				for a := 0; a < 3; a++ {
+					ret.Min[a] = math.Min(ret.Min[a], q[a])
+					ret.Max[a] = math.Max(ret.Max[a], q[a])
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// metresPerUnit returns the approximate length in metres of one unit along
+// each axis of c, degrees are taken at the equator.
+func metresPerUnit(c *CRS) [3]float64 {
+	_, vf := c.GetVerticalUnit()
+	switch c.Type {
+	case CRS_GEOGRAPHIC:
+		f := c.UnitFactor * c.Datum.Ellipsoid.A
+		return [3]float64{f, f, vf}
+	case CRS_GEOCENTRIC:
+		return [3]float64{c.UnitFactor, c.UnitFactor, c.UnitFactor}
+	}
+	return [3]float64{c.UnitFactor, c.UnitFactor, vf}
+}
+
+// convertScale returns the scale in dst that keeps the finest precision of
+// scale in src.
+func convertScale(scale [3]float64, src, dst *CRS) [3]float64 {
+	from, to := metresPerUnit(src), metresPerUnit(dst)
+	precision := math.Inf(1)
+	for k := 0; k < 3; k++ {
+		precision = math.Min(precision, scale[k]*from[k])
+	}
+	var ret [3]float64
+	for k := 0; k < 3; k++ {
+		ret[k] = precision / to[k]
+	}
+	return ret
+}
+
+// projectionTransform returns the transform from the CRS of src to the one
+// of dst, nil if either has none or they are the same.
+func projectionTransform(src, dst *Metadata) (*Transform, error) {
+	if src.Projection == nil || dst.Projection == nil || *src.Projection == *dst.Projection {
+		return nil, nil
+	}
+	from, err := src.GetCRS()
+	if err != nil {
+		return nil, err
+	}
+	to, err := dst.GetCRS()
+	if err != nil {
+		return nil, err
+	}
+	if from == nil || to == nil || from.Equal(to) {
+		return nil, nil
+	}
+	return NewTransform(from, to)
+}
+
+// reprojectBounds converts a bounding box and scale of src into the CRS of
+// dst, they are returned unchanged if no reprojection is needed.
+func reprojectBounds(box AABB, scale [3]float64, src, dst *Metadata) (AABB, [3]float64, error) {
+	tr, err := projectionTransform(src, dst)
+	if err != nil || tr == nil {
+		return box, scale, err
+	}
+	if box, err = tr.TransformBox(box); err != nil {
+		return box, scale, err
+	}
+	return box, convertScale(scale, tr.src, tr.dst), nil
+}
+
+// reprojectMetadata moves the bounding box of meta into dst and picks a
+// scale and offset that keep the precision of the current scale.
+func reprojectMetadata(meta *Metadata, dst *CRS) error {
+	src, err := meta.GetCRS()
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		return errors.New("metadata has no CRS to reproject from")
+	}
+	tr, err := NewTransform(src, dst)
+	if err != nil {
+		return err
+	}
+	box, err := tr.TransformBox(meta.BoundingBox)
+	if err != nil {
+		return err
+	}
+	so := ComputeScaleOffset(vec3d.T(box.Min), vec3d.T(box.Max), vec3d.T(convertScale(meta.Scale, src, dst)))
+	meta.BoundingBox = box
+	meta.Scale = so.scale
+	offset := so.offset
+	meta.Offset = &offset
+	meta.SetCRS(dst)
+	return nil
+}
+
+// ReprojectPoints converts points described by meta to dst. The returned
+// metadata has the bounds of the converted points and a scale keeping their
+// precision.
+func ReprojectPoints(meta *Metadata, points []Attribute, dst *CRS) (*Metadata, []Attribute, error) {
+	position := FindAttribute(points, POSITION.Name)
+	if position == nil {
+		return nil, nil, errors.New("points have no position attribute")
+	}
+	ret := *meta
+	ret.Attrs = append([]Attribute(nil), meta.Attrs...)
+	if position.Len() > 0 {
+		ret.BoundingBox = pointBounds(meta, position)
+	}
+	if err := reprojectMetadata(&ret, dst); err != nil {
+		return nil, nil, err
+	}
+	converted, err := ConformPoints(&ret, meta, points)
+	if err != nil {
+		return nil, nil, err
+	}
+	if position.Len() > 0 {
+		ret.BoundingBox = pointBounds(&ret, FindAttribute(converted, POSITION.Name))
+	}
+	updateAttributeRanges(&ret, converted)
+	return &ret, converted, nil
+}
@@ -0,0 +1,116 @@
+package potree
+
+import (
+	"math"
+	"testing"
+)
+
+func mustTransform(t *testing.T, src, dst string) *Transform {
+	a, err := ParseCRS(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseCRS(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTransform(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		in, out  [3]float64
+		tol      float64
+	}{
+		{"EPSG:4326", "EPSG:32632", [3]float64{45, 9, 100}, [3]float64{500000, 4982950.400, 100}, 0.001},
+		{"EPSG:4326", "EPSG:32632", [3]float64{48, 11, 520}, [3]float64{649187.87, 5318235.61, 520}, 0.01},
+		{"EPSG:4326", "EPSG:32632", [3]float64{48, 7, 0}, [3]float64{350812.13, 5318235.61, 0}, 0.01},
+		{"EPSG:4326", "EPSG:32732", [3]float64{-48, 11, 0}, [3]float64{649187.87, 4681764.39, 0}, 0.01},
+		{"EPSG:4326", "EPSG:3857", [3]float64{0, 180, 0}, [3]float64{20037508.342789244, 0, 0}, 1e-6},
+		{"EPSG:4326", "EPSG:4978", [3]float64{0, 0, 0}, [3]float64{6378137, 0, 0}, 1e-6},
+		{"EPSG:4326", "EPSG:4978", [3]float64{90, 0, 0}, [3]float64{0, 0, 6356752.3142}, 1e-3},
+		{"EPSG:4150", "EPSG:2056", [3]float64{46.9524055555556, 7.43958333333333, 0}, [3]float64{2600000, 1200000, 0}, 1e-3},
+		// heights stay ellipsoidal, so the datum shift lowers them onto Bessel 1841
+		{"EPSG:4326", "EPSG:2056", [3]float64{46 + 2/60.0 + 38.87/3600, 8 + 43/60.0 + 49.79/3600, 0}, [3]float64{2699999.76, 1099999.97, -50.603}, 2},
+	}
+	for _, test := range tests {
+		tr := mustTransform(t, test.src, test.dst)
+		out, err := tr.Apply(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := 0; k < 3; k++ {
+			if math.Abs(out[k]-test.out[k]) > test.tol {
+				t.Errorf("%s to %s: %v became %v, want %v", test.src, test.dst, test.in, out, test.out)
+				break
+			}
+		}
+		back, err := mustTransform(t, test.dst, test.src).Apply(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := 0; k < 3; k++ {
+			if math.Abs(back[k]-test.in[k]) > 1e-6 {
+				t.Errorf("%s to %s: %v came back as %v", test.src, test.dst, test.in, back)
+				break
+			}
+		}
+	}
+
+	if _, err := mustTransform(t, "EPSG:4326", "EPSG:3857").Apply([3]float64{90, 0, 0}); err == nil {
+		t.Error("pole projected to Web Mercator")
+	}
+}
+
+func TestReprojectMerge(t *testing.T) {
+	arch := makeTestArchive(t, ENCODING_DEFAULT)
+	meta := arch.GetMetadata()
+	offset := [3]float64{500000, 5000000, 0}
+	meta.Offset = &offset
+	meta.BoundingBox = AABB{Min: offset, Max: [3]float64{offset[0] + 64, offset[1] + 64, 64}}
+	utm, _ := NewCRSFromEPSG(32632)
+	meta.SetCRS(utm)
+	if err := arch.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := makeTestDir(t)
+
+	merged, err := Merge([]*PotreeArchive{NewArchive(arch.path)}, &Options{Outdir: dir, CRS: "EPSG:4978"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mmeta := merged.GetMetadata()
+	crs, err := mmeta.GetCRS()
+	if err != nil || crs.Type != CRS_GEOCENTRIC {
+		t.Fatalf("merged archive in %v, %v", crs, err)
+	}
+	if *mmeta.Points != 100+40+43+47+25 || mmeta.Scale[0] > 0.001 {
+		t.Fatalf("unexpected merged archive: %d points, scale %v", *mmeta.Points, mmeta.Scale)
+	}
+	report, err := merged.Validate()
+	if err != nil || !report.OK() {
+		t.Fatalf("reprojected archive invalid: %v %s", err, report)
+	}
+
+	tr := mustTransform(t, "EPSG:32632", "EPSG:4978")
+	want, _ := tr.Apply(offset)
+	points, err := NewArchive(dir).Query(nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := FindAttribute(points, POSITION.Name)
+	for i := 0; i < position.Len(); i++ {
+		p := mmeta.WorldPosition(position, i)
+		for k := 0; k < 3; k++ {
+			if math.Abs(p[k]-want[k]) > 150 {
+				t.Fatalf("point %d at %v, far from %v", i, p, want)
+			}
+		}
+	}
+}
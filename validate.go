@@ -0,0 +1,211 @@
+package potree
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+type IssueKind string
+
+const (
+	ISSUE_METADATA        IssueKind = "metadata"
+	ISSUE_BYTES_PER_POINT IssueKind = "bytes-per-point"
+	ISSUE_HIERARCHY_SIZE  IssueKind = "hierarchy-size"
+	ISSUE_HIERARCHY       IssueKind = "hierarchy"
+	ISSUE_NODE_RANGE      IssueKind = "node-range"
+	ISSUE_DECODE          IssueKind = "decode"
+	ISSUE_POINT_COUNT     IssueKind = "point-count"
+	ISSUE_POINT_BOUNDS    IssueKind = "point-bounds"
+)
+
+type ValidationIssue struct {
+	Kind    IssueKind `json:"kind"`
+	Node    string    `json:"node,omitempty"`
+	Message string    `json:"message"`
+}
+
+type ValidationReport struct {
+	Path      string            `json:"path"`
+	NumNodes  int               `json:"numNodes"`
+	NumPoints int64             `json:"numPoints"`
+	Issues    []ValidationIssue `json:"issues"`
+}
+
+func (r *ValidationReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *ValidationReport) add(kind IssueKind, node string, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, ValidationIssue{Kind: kind, Node: node, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ValidationReport) String() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s: %d nodes, %d points, %d issues\n", r.Path, r.NumNodes, r.NumPoints, len(r.Issues))
+	for _, issue := range r.Issues {
+		if issue.Node != "" {
+			fmt.Fprintf(sb, "  [%s] %s: %s\n", issue.Kind, issue.Node, issue.Message)
+		} else {
+			fmt.Fprintf(sb, "  [%s] %s\n", issue.Kind, issue.Message)
+		}
+	}
+	return sb.String()
+}
+
+// Validate checks the archive files on disk for structural consistency and
+// reports every problem found instead of stopping at the first one. The
+// returned error is only set when the files can't be read at all.
+func (b *PotreeArchive) Validate() (*ValidationReport, error) {
+	report := &ValidationReport{Path: b.path}
+	chk := NewArchive(b.path)
+
+	if err := chk.readMetadata(); err != nil {
+		report.add(ISSUE_METADATA, "", "%v", err)
+		return report, nil
+	}
+	meta := chk.metadata
+
+	sum := meta.bytesPerPoint()
+	if meta.BytesPerPoint != sum {
+		report.add(ISSUE_BYTES_PER_POINT, "", "bytesPerPoint is %d, attributes sum to %d", meta.BytesPerPoint, sum)
+	}
+
+	if meta.Hierarchy == nil {
+		report.add(ISSUE_METADATA, "", "metadata.json has no hierarchy")
+		return report, nil
+	}
+	if !FileExists(chk.getHierarchyPath()) {
+		report.add(ISSUE_HIERARCHY, "", "hierarchy.bin not found")
+		return report, nil
+	}
+	hierarchy, err := ioutil.ReadFile(chk.getHierarchyPath())
+	if err != nil {
+		return nil, err
+	}
+	firstChunkSize := meta.Hierarchy.FirstChunkSize
+	if firstChunkSize%BytesPerNode != 0 {
+		report.add(ISSUE_HIERARCHY_SIZE, "", "firstChunkSize %d is not a multiple of %d", firstChunkSize, BytesPerNode)
+	}
+	if firstChunkSize > int64(len(hierarchy)) {
+		report.add(ISSUE_HIERARCHY_SIZE, "", "firstChunkSize %d exceeds hierarchy.bin size %d", firstChunkSize, len(hierarchy))
+	}
+	if len(hierarchy)%BytesPerNode != 0 {
+		report.add(ISSUE_HIERARCHY_SIZE, "", "hierarchy.bin size %d is not a multiple of %d", len(hierarchy), BytesPerNode)
+	}
+
+	chk.root = &Node{Name: "r", Box: meta.BoundingBox}
+	chk.nodeMaps = map[string]*Node{chk.root.Name: chk.root}
+	parser := newHierarchyParser(hierarchy, chk.nodeMaps)
+	parser.parse(chk.root, 0, firstChunkSize)
+	for _, err := range parser.errs {
+		e := err.(*ErrCorruptHierarchy)
+		report.add(ISSUE_HIERARCHY, e.Node, "offset %d: %v", e.Offset, e.Err)
+	}
+
+	if !FileExists(chk.getOctreePath()) {
+		report.add(ISSUE_NODE_RANGE, "", "octree.bin not found")
+		return report, nil
+	}
+	octree, err := os.Open(chk.getOctreePath())
+	if err != nil {
+		return nil, err
+	}
+	defer octree.Close()
+	info, err := octree.Stat()
+	if err != nil {
+		return nil, err
+	}
+	octreeSize := info.Size()
+
+	names := make([]string, 0, len(chk.nodeMaps))
+	for name := range chk.nodeMaps {
+		names = append(names, name)
+	}
+	sort.Sort(nodeNames(names))
+
+	for _, name := range names {
+		n := chk.nodeMaps[name]
+		report.NumNodes++
+		report.NumPoints += int64(n.NumPoints)
+
+		if n.ByteOffset < 0 || n.ByteSize < 0 || n.ByteOffset+n.ByteSize > octreeSize {
+			report.add(ISSUE_NODE_RANGE, name, "byte range [%d, %d) is outside octree.bin of %d bytes", n.ByteOffset, n.ByteOffset+n.ByteSize, octreeSize)
+			continue
+		}
+		data := make([]byte, n.ByteSize)
+		if _, err := octree.ReadAt(data, n.ByteOffset); err != nil {
+			return nil, err
+		}
+		chk.validateNode(n, data, report)
+	}
+
+	if meta.Points != nil && *meta.Points != report.NumPoints {
+		report.add(ISSUE_POINT_COUNT, "", "metadata declares %d points, hierarchy holds %d", *meta.Points, report.NumPoints)
+	}
+	return report, nil
+}
+
+// validateNode checks the payload of n, a codec panicking on corrupt input is
+// reported as a decode issue.
+func (b *PotreeArchive) validateNode(n *Node, data []byte, report *ValidationReport) {
+	meta := b.metadata
+	defer func() {
+		if r := recover(); r != nil {
+			report.add(ISSUE_DECODE, n.Name, "decoder failed: %v", r)
+		}
+	}()
+
+	codec, compressed := nodeCodecs[meta.GetEncoding()]
+	if compressed {
+		if len(data) > 0 {
+			var err error
+			if data, err = codec.decode(data); err != nil {
+				report.add(ISSUE_DECODE, n.Name, "%v", err)
+				return
+			}
+		}
+		encodedSize := 0
+		for i := range meta.Attrs {
+			encodedSize += encodedAttribute(&meta.Attrs[i], true).Size
+		}
+		if encodedSize == 0 || len(data)%encodedSize != 0 || len(data)/encodedSize != int(n.NumPoints) {
+			report.add(ISSUE_POINT_COUNT, n.Name, "decoded %d bytes, expected %d points of %d bytes", len(data), n.NumPoints, encodedSize)
+			return
+		}
+	} else {
+		bytesPerPoint := meta.bytesPerPoint()
+		if bytesPerPoint == 0 || len(data)%bytesPerPoint != 0 || len(data)/bytesPerPoint != int(n.NumPoints) {
+			report.add(ISSUE_POINT_COUNT, n.Name, "%d bytes, expected %d points of %d bytes", len(data), n.NumPoints, bytesPerPoint)
+			return
+		}
+	}
+
+	attrs, err := n.uncompact(data, meta.Attrs, compressed)
+	if err != nil {
+		report.add(ISSUE_DECODE, n.Name, "%v", err)
+		return
+	}
+
+	for i := range attrs {
+		if attrs[i].Name != POSITION.Name {
+			continue
+		}
+		position := &attrs[i]
+		if position.Len() != int(n.NumPoints) {
+			report.add(ISSUE_POINT_COUNT, n.Name, "decoded %d positions, expected %d", position.Len(), n.NumPoints)
+			return
+		}
+		outside := 0
+		for p := 0; p < position.Len(); p++ {
+			if !n.Box.Contains(meta.WorldPosition(position, p), meta.Scale) {
+				outside++
+			}
+		}
+		if outside > 0 {
+			report.add(ISSUE_POINT_BOUNDS, n.Name, "%d of %d points lie outside the node box", outside, n.NumPoints)
+		}
+	}
+}
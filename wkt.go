@@ -0,0 +1,498 @@
+package potree
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wktNode is a WKT keyword with its bracketed arguments: quoted strings,
+// numbers, bare enums and nested nodes.
+type wktNode struct {
+	keyword string
+	args    []interface{}
+}
+
+type wktEnum string
+
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func parseWKT(s string) (*wktNode, error) {
+	p := &wktParser{s: s}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	n, ok := v.(*wktNode)
+	if !ok {
+		return nil, fmt.Errorf("invalid WKT at %d", p.pos)
+	}
+	p.skip()
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("invalid WKT, trailing data at %d", p.pos)
+	}
+	return n, nil
+}
+
+func (p *wktParser) skip() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *wktParser) value() (interface{}, error) {
+	p.skip()
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("invalid WKT, unexpected end")
+	}
+	c := p.s[p.pos]
+	switch {
+	case c == '"':
+		var buf strings.Builder
+		for p.pos++; ; p.pos++ {
+			if p.pos >= len(p.s) {
+				return nil, fmt.Errorf("invalid WKT, unterminated string")
+			}
+			if p.s[p.pos] == '"' {
+				if p.pos+1 < len(p.s) && p.s[p.pos+1] == '"' {
+					p.pos++
+				} else {
+					p.pos++
+					return buf.String(), nil
+				}
+			}
+			buf.WriteByte(p.s[p.pos])
+		}
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.s) && strings.IndexByte("0123456789.eE+-", p.s[p.pos]) >= 0 {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.s[start:p.pos], 64)
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] == '_' || unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos]))) {
+		p.pos++
+	}
+	word := p.s[start:p.pos]
+	if word == "" {
+		return nil, fmt.Errorf("invalid WKT at %d", p.pos)
+	}
+	p.skip()
+	if p.pos >= len(p.s) || (p.s[p.pos] != '[' && p.s[p.pos] != '(') {
+		return wktEnum(word), nil
+	}
+	close := byte(']')
+	if p.s[p.pos] == '(' {
+		close = ')'
+	}
+	p.pos++
+	n := &wktNode{keyword: strings.ToUpper(word)}
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.args = append(n.args, v)
+		p.skip()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("invalid WKT, unterminated %s", n.keyword)
+		}
+		c := p.s[p.pos]
+		p.pos++
+		if c == close {
+			return n, nil
+		}
+		if c != ',' {
+			return nil, fmt.Errorf("invalid WKT at %d", p.pos-1)
+		}
+	}
+}
+
+func (n *wktNode) name() string {
+	if len(n.args) > 0 {
+		if s, ok := n.args[0].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func (n *wktNode) number(i int) float64 {
+	if i < len(n.args) {
+		if f, ok := n.args[i].(float64); ok {
+			return f
+		}
+	}
+	return 0
+}
+
+func (n *wktNode) enum(i int) string {
+	if i < len(n.args) {
+		switch v := n.args[i].(type) {
+		case wktEnum:
+			return string(v)
+		case string:
+			return v
+		}
+	}
+	return ""
+}
+
+func (n *wktNode) nodes() []*wktNode {
+	var ret []*wktNode
+	for _, a := range n.args {
+		if c, ok := a.(*wktNode); ok {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func (n *wktNode) child(keywords ...string) *wktNode {
+	for _, c := range n.nodes() {
+		for _, k := range keywords {
+			if c.keyword == k {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (n *wktNode) children(keyword string) []*wktNode {
+	var ret []*wktNode
+	for _, c := range n.nodes() {
+		if c.keyword == keyword {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// epsg returns the EPSG code of an AUTHORITY or ID child, 0 if there is
+// none.
+func (n *wktNode) epsg() int {
+	id := n.child("AUTHORITY", "ID")
+	if id == nil || !strings.EqualFold(id.name(), "EPSG") || len(id.args) < 2 {
+		return 0
+	}
+	switch v := id.args[1].(type) {
+	case float64:
+		return int(v)
+	case string:
+		code, _ := strconv.Atoi(v)
+		return code
+	}
+	return 0
+}
+
+// unit returns the name and factor of the unit of n, taken from the first
+// axis if n has none itself.
+func (n *wktNode) unit(def string, factor float64) (string, float64) {
+	if u := n.child("UNIT", "LENGTHUNIT", "ANGLEUNIT"); u != nil {
+		return u.name(), u.number(1)
+	}
+	for _, axis := range n.children("AXIS") {
+		if u := axis.child("UNIT", "LENGTHUNIT", "ANGLEUNIT"); u != nil {
+			return u.name(), u.number(1)
+		}
+	}
+	return def, factor
+}
+
+func normalizeWKTName(s string) string {
+	var buf strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+var wktProjectedKeywords = map[string]bool{"PROJCS": true, "PROJCRS": true, "PROJECTEDCRS": true}
+
+var wktGeographicKeywords = map[string]bool{
+	"GEOGCS": true, "GEOGCRS": true, "GEOGRAPHICCRS": true, "GEODCRS": true, "GEODETICCRS": true,
+	"BASEGEOGCRS": true, "BASEGEODCRS": true, "GEOCCS": true,
+}
+
+var wktVerticalKeywords = map[string]bool{"VERT_CS": true, "VERTCRS": true, "VERTICALCRS": true}
+
+func crsFromWKT(n *wktNode) (*CRS, error) {
+	var (
+		ret *CRS
+		err error
+	)
+	switch {
+	case n.keyword == "COMPD_CS" || n.keyword == "COMPOUNDCRS":
+		var vertical *VerticalCRS
+		for _, c := range n.nodes() {
+			switch {
+			case wktVerticalKeywords[c.keyword]:
+				vertical = verticalFromWKT(c)
+			case c.keyword == "AUTHORITY" || c.keyword == "ID":
+			case ret == nil:
+				if ret, err = crsFromWKT(c); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if ret == nil {
+			return nil, fmt.Errorf("%s has no horizontal CRS", n.keyword)
+		}
+		ret.Vertical = vertical
+		return ret, nil
+	case n.keyword == "BOUNDCRS":
+		src := n.child("SOURCECRS")
+		if src == nil || len(src.nodes()) == 0 {
+			return nil, fmt.Errorf("BOUNDCRS has no source CRS")
+		}
+		if ret, err = crsFromWKT(src.nodes()[0]); err != nil {
+			return nil, err
+		}
+		if tr := n.child("ABRIDGEDTRANSFORMATION"); tr != nil {
+			ret.Datum.ToWGS84 = helmertFromWKT(tr)
+		}
+		return ret, nil
+	case wktProjectedKeywords[n.keyword]:
+		ret, err = projectedFromWKT(n)
+	case wktGeographicKeywords[n.keyword]:
+		ret, err = geographicFromWKT(n)
+	case wktVerticalKeywords[n.keyword]:
+		return nil, fmt.Errorf("%s is a vertical CRS", n.name())
+	default:
+		return nil, fmt.Errorf("unsupported WKT %s", n.keyword)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret.Datum.ToWGS84 == nil && ret.EPSG != 0 {
+		if known, err := NewCRSFromEPSG(ret.EPSG); err == nil && known.Type == ret.Type {
+			ret.Datum.ToWGS84 = known.Datum.ToWGS84
+		}
+	}
+	return ret, nil
+}
+
+func datumFromWKT(n *wktNode) Datum {
+	var ret Datum
+	d := n.child("DATUM", "GEODETICDATUM", "TRF", "ENSEMBLE", "DATUMENSEMBLE")
+	if d == nil {
+		return ret
+	}
+	ret.Name = d.name()
+	if e := d.child("SPHEROID", "ELLIPSOID"); e != nil {
+		ret.Ellipsoid = Ellipsoid{Name: e.name(), A: e.number(1), InvF: e.number(2)}
+		if u := e.child("LENGTHUNIT"); u != nil && u.number(1) != 0 {
+			ret.Ellipsoid.A *= u.number(1)
+		}
+	}
+	if t := d.child("TOWGS84"); t != nil {
+		ret.ToWGS84 = make([]float64, 7)
+		for i := range ret.ToWGS84 {
+			ret.ToWGS84[i] = t.number(i)
+		}
+	}
+	if ret.ToWGS84 == nil {
+		name := normalizeWKTName(ret.Name)
+		for _, s := range []string{"wgs84", "wgs1984", "worldgeodeticsystem1984", "etrs89", "europeanterrestrialreferencesystem1989", "northamericandatum1983"} {
+			if strings.Contains(name, normalizeWKTName(s)) {
+				ret.ToWGS84 = make([]float64, 7)
+			}
+		}
+	}
+	return ret
+}
+
+// helmertFromWKT reads the parameters of a WKT2 ABRIDGEDTRANSFORMATION,
+// coordinate frame rotations are turned into position vector ones.
+func helmertFromWKT(n *wktNode) []float64 {
+	ret := make([]float64, 7)
+	names := []string{"xaxistranslation", "yaxistranslation", "zaxistranslation", "xaxisrotation", "yaxisrotation", "zaxisrotation", "scaledifference"}
+	for _, p := range n.children("PARAMETER") {
+		for i, name := range names {
+			if normalizeWKTName(p.name()) == name {
+				ret[i] = p.number(1)
+			}
+		}
+	}
+	if m := n.child("METHOD"); m != nil && strings.Contains(normalizeWKTName(m.name()), "coordinateframe") {
+		for i := 3; i < 6; i++ {
+			ret[i] = -ret[i]
+		}
+	}
+	return ret
+}
+
+func geographicFromWKT(n *wktNode) (*CRS, error) {
+	ret := &CRS{Name: n.name(), EPSG: n.epsg(), Type: CRS_GEOGRAPHIC, Method: PROJ_LONGLAT, K0: 1, Datum: datumFromWKT(n)}
+	if ret.Datum.Ellipsoid.A == 0 {
+		return nil, fmt.Errorf("%s has no ellipsoid", ret.Name)
+	}
+	cs := n.child("CS")
+	if n.keyword == "GEOCCS" || (cs != nil && strings.EqualFold(cs.enum(0), "cartesian")) {
+		ret.Type, ret.Method = CRS_GEOCENTRIC, PROJ_GEOCENT
+		ret.Unit, ret.UnitFactor = n.unit("metre", UNIT_METRE)
+		ret.Axes = []string{AXIS_GEOCENTRICX, AXIS_GEOCENTRICY, AXIS_GEOCENTRICZ}
+		return ret, nil
+	}
+	ret.Unit, ret.UnitFactor = n.unit("degree", UNIT_DEGREE)
+	ret.Axes = axesFromWKT(n)
+	if ret.Axes == nil {
+		ret.Axes = []string{AXIS_EAST, AXIS_NORTH}
+	}
+	return ret, nil
+}
+
+func axesFromWKT(n *wktNode) []string {
+	var ret []string
+	for _, axis := range n.children("AXIS") {
+		dir := strings.ToLower(axis.enum(1))
+		switch dir {
+		case "east", "north", "up":
+		case "geocentricx", "geocentricy", "geocentricz":
+			dir = "geocentric" + strings.ToUpper(dir[len(dir)-1:])
+		}
+		ret = append(ret, dir)
+	}
+	return ret
+}
+
+var wktParameters = map[string]string{
+	"latitudeoforigin":              "lat_0",
+	"latitudeofnaturalorigin":       "lat_0",
+	"latitudeofcenter":              "lat_0",
+	"latitudeofprojectioncentre":    "lat_0",
+	"centralmeridian":               "lon_0",
+	"longitudeofnaturalorigin":      "lon_0",
+	"longitudeofcenter":             "lon_0",
+	"longitudeofprojectioncentre":   "lon_0",
+	"longitudeoforigin":             "lon_0",
+	"scalefactor":                   "k",
+	"scalefactoratnaturalorigin":    "k",
+	"scalefactoroninitialline":      "k",
+	"falseeasting":                  "x_0",
+	"eastingatprojectioncentre":     "x_0",
+	"falsenorthing":                 "y_0",
+	"northingatprojectioncentre":    "y_0",
+	"standardparallel1":             "lat_ts",
+	"latitudeof1ststandardparallel": "lat_ts",
+	"azimuth":                       "azimuth",
+	"azimuthofinitialline":          "azimuth",
+	"azimuthofcentreline":           "azimuth",
+	"rectifiedgridangle":            "gamma",
+	"angleofrectifiedtoskewedgrid":  "gamma",
+	"anglefromrectifiedtoskewgrid":  "gamma",
+	"latitudeoffalseorigin":         "lat_0",
+	"longitudeoffalseorigin":        "lon_0",
+	"eastingatfalseorigin":          "x_0",
+	"northingatfalseorigin":         "y_0",
+	"scalefactoratprojectioncentre": "k",
+}
+
+func projectionMethod(name string, extension string) (string, error) {
+	n := normalizeWKTName(name)
+	switch {
+	case strings.Contains(n, "pseudomercator") || strings.Contains(n, "webmercator") || strings.Contains(extension, "+nadgrids=@null"):
+		return PROJ_WEBMERC, nil
+	case strings.Contains(n, "obliquemercator") || strings.Contains(n, "swissoblique"):
+		return PROJ_SOMERC, nil
+	case strings.Contains(n, "transversemercator") || strings.Contains(n, "gausskruger"):
+		return PROJ_TMERC, nil
+	case strings.Contains(n, "mercator"):
+		return PROJ_MERC, nil
+	}
+	return "", fmt.Errorf("unsupported projection %s", name)
+}
+
+func projectedFromWKT(n *wktNode) (*CRS, error) {
+	base := n.child("GEOGCS", "BASEGEOGCRS", "BASEGEODCRS", "GEOGCRS")
+	if base == nil {
+		return nil, fmt.Errorf("%s has no geographic CRS", n.name())
+	}
+	geog, err := geographicFromWKT(base)
+	if err != nil {
+		return nil, err
+	}
+	ret := &CRS{Name: n.name(), EPSG: n.epsg(), Type: CRS_PROJECTED, K0: 1, Datum: geog.Datum}
+	ret.Unit, ret.UnitFactor = n.unit("metre", UNIT_METRE)
+	ret.Axes = axesFromWKT(n)
+	if ret.Axes == nil {
+		ret.Axes = []string{AXIS_EAST, AXIS_NORTH}
+	}
+
+	method, params := n.child("PROJECTION"), n
+	if conv := n.child("CONVERSION"); conv != nil {
+		method, params = conv.child("METHOD", "PROJECTION"), conv
+	}
+	if method == nil {
+		return nil, fmt.Errorf("%s has no projection", ret.Name)
+	}
+	extension := ""
+	if ext := n.child("EXTENSION"); ext != nil {
+		extension = ext.enum(1)
+	}
+	if ret.Method, err = projectionMethod(method.name(), extension); err != nil {
+		return nil, err
+	}
+	if ret.Method == PROJ_WEBMERC {
+		ret.Datum = projDatums["WGS84"]
+		return ret, nil
+	}
+
+	values := make(map[string]float64)
+	for _, p := range params.children("PARAMETER") {
+		key, ok := wktParameters[normalizeWKTName(p.name())]
+		if !ok {
+			continue
+		}
+		v := p.number(1)
+		switch key {
+		case "x_0", "y_0":
+			if u := p.child("LENGTHUNIT", "UNIT"); u != nil && u.number(1) != 0 {
+				v *= u.number(1)
+			} else if params == n {
+				v *= ret.UnitFactor
+			}
+		default:
+			factor := UNIT_DEGREE
+			if u := p.child("ANGLEUNIT"); u != nil && u.number(1) != 0 {
+				factor = u.number(1)
+			} else if params == n && geog.UnitFactor != 0 {
+				factor = geog.UnitFactor
+			}
+			if key != "k" && !sameFloat(factor, UNIT_DEGREE) {
+				v *= factor / UNIT_DEGREE
+			}
+		}
+		values[key] = v
+	}
+	ret.Lat0, ret.Lon0, ret.LatTS = values["lat_0"], values["lon_0"], values["lat_ts"]
+	ret.FalseEasting, ret.FalseNorthing = values["x_0"], values["y_0"]
+	if k, ok := values["k"]; ok {
+		ret.K0 = k
+	}
+	if ret.Method == PROJ_SOMERC {
+		if az, ok := values["azimuth"]; ok && math.Abs(az-90) > 1e-9 {
+			return nil, fmt.Errorf("unsupported oblique mercator azimuth %g", az)
+		}
+	}
+	return ret, nil
+}
+
+func verticalFromWKT(n *wktNode) *VerticalCRS {
+	ret := &VerticalCRS{Name: n.name(), EPSG: n.epsg()}
+	if d := n.child("VERT_DATUM", "VDATUM", "VERTICALDATUM"); d != nil {
+		ret.Datum = d.name()
+	}
+	ret.Unit, ret.UnitFactor = n.unit("metre", UNIT_METRE)
+	return ret
+}
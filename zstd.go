@@ -0,0 +1,50 @@
+package potree
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Zstd struct{}
+
+var (
+	zstdEncoders sync.Map
+	zstdDecoder  *zstd.Decoder
+	zstdInit     sync.Once
+	zstdErr      error
+)
+
+// EncodeLevel compresses src with the zstd level from 1 to 22 mapped to the
+// nearest encoder speed, zero uses the default speed. The shared encoders and
+// decoder run up to GOMAXPROCS calls at once.
+func (Zstd) EncodeLevel(dst, src []byte, level int) ([]byte, error) {
+	speed := zstd.SpeedDefault
+	if level > 0 {
+		speed = zstd.EncoderLevelFromZstd(level)
+	}
+	enc, ok := zstdEncoders.Load(speed)
+	if !ok {
+		w, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(speed), zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)))
+		if err != nil {
+			return nil, err
+		}
+		enc, _ = zstdEncoders.LoadOrStore(speed, w)
+	}
+	return enc.(*zstd.Encoder).EncodeAll(src, dst[:0]), nil
+}
+
+func (z Zstd) Encode(dst, src []byte) ([]byte, error) {
+	return z.EncodeLevel(dst, src, 0)
+}
+
+func (Zstd) Decode(dst, src []byte) ([]byte, error) {
+	zstdInit.Do(func() {
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(runtime.GOMAXPROCS(0)))
+	})
+	if zstdErr != nil {
+		return nil, zstdErr
+	}
+	return zstdDecoder.DecodeAll(src, dst[:0])
+}